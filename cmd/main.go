@@ -1,35 +1,328 @@
 package main
 
 import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/amm"
+	"defi/internal/api"
+	"defi/internal/auth"
+	"defi/internal/cache"
+	"defi/internal/command"
 	"defi/internal/config"
 	"defi/internal/db"
 	"defi/internal/eventbus"
 	"defi/internal/eventstore"
+	"defi/internal/health"
+	"defi/internal/idempotency"
+	"defi/internal/ledger"
+	"defi/internal/lending"
+	"defi/internal/migrate"
 	"defi/internal/model"
+	"defi/internal/oracle"
+	"defi/internal/orderbook"
+	"defi/internal/ratelimit"
+	"defi/internal/saga"
+	"defi/internal/scheduler"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	mqConfigs, dbConfigs, cacheConfigs, err := config.LoadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-secret" {
+		runEncryptSecret()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "new-api-key" {
+		runNewAPIKey(os.Args[2:])
+		return
+	}
+
+	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		// String redacts secrets, so the dump is safe to share.
+		fmt.Println(cfg)
+		return
+	}
+
+	database := db.InitDB(cfg.DB(), cfg.Cache)
+	defer database.Close()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(database, os.Args[2:])
+		return
+	}
+	migrateUp(newMigrator(database))
+
+	store, err := eventstore.NewEventStore(database.SQL, database.Driver)
+	if err != nil {
+		log.Fatalf("Failed to create event store: %v", err)
+	}
+	store.Reads = database
+	topicOf := eventstore.TopicByAggregateType("events")
+	store.OutboxTopic = topicOf
+
+	// Cached states carry their version and loads replay the events after
+	// it, so appends need not invalidate them.
+	states := cache.NewStateCache(cache.New(database, cfg.Cache.Type), cache.DefaultStateTTL)
+	mqEventBus := eventbus.InitEventBus(cfg.MQ())
+	defer mqEventBus.Close()
+
+	// Backend names double as the names of their config sections.
+	config.Subscribe(cfg.Backends.DB, "database", database.ReconfigureSQL)
+	config.Subscribe(config.SectionRedisCluster, "redis", database.ReconfigureRedis)
+	config.Subscribe(cfg.Backends.MQ, "event bus", mqEventBus.Reconfigure)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := &eventstore.OutboxRelay{Store: store, Bus: mqEventBus}
+	go relay.Run(ctx)
+
+	registerAssets()
+	// Command handlers load aggregates through the state cache.
+	domain := aggregate.WithSnapshots(store, states)
+	prices := oracle.New(domain, oracle.Options{}, oracleSources()...)
+	go prices.Run(ctx, oracleInterval)
+	// Lending values positions at the stored prices, whichever replica
+	// recorded them.
+	feeds := oracle.NewPrices()
+	go feeds.Run(ctx, store, oracleInterval)
+	bus := command.NewBus()
+	ledger.New(domain).Register(bus)
+	amm.Register(bus, domain)
+	lending.New(domain, lendingPrice(feeds)).Register(bus)
+	orderbook.Register(bus, domain)
+	sagas := saga.NewManager(domain)
+	go sagas.Run(ctx, store, sagaInterval)
+
+	jobs, err := scheduler.New(database.SQL, database.Driver)
+	if err != nil {
+		log.Fatalf("Failed to create scheduler: %v", err)
+	}
+	registerSchedulable(jobs)
+	// Without Reads this store reads the primary: the scheduler's fence must
+	// see repeated attempts' own writes, and resumed streams must see every
+	// event the bus has delivered.
+	primary, err := eventstore.NewEventStore(database.SQL, database.Driver)
+	if err != nil {
+		log.Fatalf("Failed to create event store: %v", err)
+	}
+	jobs.Commands, jobs.Events, jobs.Fence = bus, mqEventBus, primary
+	go jobs.Run(ctx)
 
-	database := db.InitDB(dbConfigs.MySQL, cacheConfigs.Cache)
-	defer func() {
-		if database.SQL != nil {
-			database.SQL.Close()
+	api.InitEventStore(store)
+	api.InitSnapshots(states)
+	registerStates()
+	// POST /events only accepts events with a schema in EVENT_SCHEMAS, one
+	// <aggregate type>/<event type>.json file each.
+	if dir := os.Getenv("EVENT_SCHEMAS"); dir != "" {
+		if err := api.LoadEventSchemas(dir); err != nil {
+			log.Fatalf("Failed to load event schemas: %v", err)
+		}
+	}
+	keys, err := idempotency.New(database)
+	if err != nil {
+		log.Fatalf("Failed to create idempotency store: %v", err)
+	}
+	if sqlKeys, ok := keys.(*idempotency.SQLStore); ok {
+		go sqlKeys.Run(ctx, time.Hour)
+	}
+	api.InitIdempotency(keys, idempotencyRetention())
+	hub, err := api.NewHub(mqEventBus, topicOf, streamTopics(topicOf)...)
+	if err != nil {
+		log.Fatalf("Failed to start event streaming: %v", err)
+	}
+	hub.Log = primary
+	api.InitStream(hub)
+	if cfg.Auth.Disabled {
+		log.Printf("Warning: API authentication is disabled")
+	} else {
+		authenticator, err := auth.New(cfg.Auth)
+		if err != nil {
+			log.Fatalf("Failed to configure authentication: %v", err)
 		}
-		if database.Redis != nil {
-			database.Redis.Close()
+		api.InitAuth(authenticator)
+	}
+	if !cfg.RateLimit.Disabled {
+		if err := api.InitRateLimit(ratelimit.New(database), cfg.RateLimit); err != nil {
+			log.Fatalf("Failed to configure rate limits: %v", err)
+		}
+	}
+	// Only the primary database is critical to readiness; the other
+	// dependencies degrade the service when they fail.
+	checks := health.NewRegistry()
+	checks.Register(database.HealthChecks()...)
+	checks.Register(mqEventBus.HealthCheck())
+	checks.Register(config.HealthChecks()...)
+	api.InitHealth(checks)
+	serverTLS, err := auth.ServerTLS(cfg.Auth.TLS)
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+	server := &http.Server{Addr: httpAddr(), Handler: api.NewRouter(), TLSConfig: serverTLS}
+	go func() {
+		var err error
+		if serverTLS != nil {
+			// The certificate is already in TLSConfig.
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server failed: %v", err)
 		}
 	}()
-
-	store := eventstore.InitEventStore(database.SQL)
-	mqEventBus := eventbus.InitEventBus(mqConfigs.Kafka)
+	defer server.Shutdown(context.Background())
 
 	publishEvent(mqEventBus)
 	consumeEvent(mqEventBus, store)
+
+	waitForShutdown()
+}
+
+// httpAddr returns HTTP_ADDR, the address the API listens on, or :8080.
+func httpAddr() string {
+	if addr := os.Getenv("HTTP_ADDR"); addr != "" {
+		return addr
+	}
+	return ":8080"
+}
+
+// idempotencyRetention returns IDEMPOTENCY_RETENTION, how long responses
+// are replayed for their Idempotency-Key, or zero for the default.
+func idempotencyRetention() time.Duration {
+	value := os.Getenv("IDEMPOTENCY_RETENTION")
+	if value == "" {
+		return 0
+	}
+	retain, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid IDEMPOTENCY_RETENTION %q: %v", value, err)
+	}
+	return retain
+}
+
+// How often the oracle polls its sources and the saga manager retries
+// steps and enforces timeouts.
+const (
+	oracleInterval = 10 * time.Second
+	sagaInterval   = 5 * time.Second
+)
+
+// registerAssets declares the decimals of the assets listed in ASSETS as
+// comma-separated asset=decimals pairs, e.g. ETH=18,USDC=6.
+func registerAssets() {
+	for _, entry := range strings.Split(os.Getenv("ASSETS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		asset, value, ok := strings.Cut(entry, "=")
+		decimals, err := strconv.ParseUint(value, 10, 8)
+		if !ok || err != nil {
+			log.Fatalf("Invalid ASSETS entry %q, expected asset=decimals", entry)
+		}
+		if err := model.RegisterAsset(asset, uint8(decimals)); err != nil {
+			log.Fatalf("Invalid ASSETS entry %q: %v", entry, err)
+		}
+	}
+}
+
+// oracleSources returns the price sources listed in ORACLE_SOURCES as
+// comma-separated name=location pairs. HTTP(S) locations are polled; other
+// locations are files of recorded ticks.
+func oracleSources() []oracle.Source {
+	var sources []oracle.Source
+	for _, entry := range strings.Split(os.Getenv("ORACLE_SOURCES"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, location, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Fatalf("Invalid ORACLE_SOURCES entry %q, expected name=location", entry)
+		}
+		if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+			sources = append(sources, oracle.NewHTTPSource(name, location, 5*time.Second))
+			continue
+		}
+		source, err := oracle.NewFileSource(name, location)
+		if err != nil {
+			log.Fatalf("Failed to load price source %s: %v", name, err)
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// lendingPrice values lending positions at the latest stored prices.
+func lendingPrice(prices *oracle.Prices) lending.PriceFunc {
+	return func(asset string) (lending.Price, bool) {
+		obs, ok := prices.Latest(asset)
+		return lending.Price{Value: obs.Price, EventID: obs.EventID, At: obs.At}, ok
+	}
+}
+
+// registerSchedulable lets the scheduler store the commands that are run
+// later or periodically. The names are stored with each job.
+func registerSchedulable(jobs *scheduler.Scheduler) {
+	scheduler.RegisterCommand[ledger.PostEntry](jobs, "ledger.post_entry")
+	scheduler.RegisterCommand[lending.Accrue](jobs, "lending.accrue")
+	scheduler.RegisterCommand[orderbook.PlaceOrder](jobs, "orderbook.place_order")
+	scheduler.RegisterCommand[orderbook.CancelOrder](jobs, "orderbook.cancel_order")
+}
+
+// aggregateStates are the aggregate types served by the API.
+var aggregateStates = map[string]func() aggregate.State{
+	ledger.AccountType:      func() aggregate.State { return ledger.NewAccount() },
+	ledger.JournalEntryType: func() aggregate.State { return ledger.NewJournalEntry() },
+	amm.PoolType:            func() aggregate.State { return amm.NewPool() },
+	lending.MarketType:      func() aggregate.State { return lending.NewMarket() },
+	orderbook.BookType:      func() aggregate.State { return orderbook.NewBook() },
+	oracle.FeedType:         func() aggregate.State { return oracle.NewFeed() },
+	saga.SagaType:           func() aggregate.State { return saga.NewSaga() },
+}
+
+// registerStates lets the API replay the state of every aggregate type.
+func registerStates() {
+	for aggregateType, newState := range aggregateStates {
+		api.RegisterState(aggregateType, newState)
+	}
+}
+
+// streamTopics returns the outbox topics of every aggregate type.
+func streamTopics(topicOf func(model.Event) string) []string {
+	var topics []string
+	for aggregateType := range aggregateStates {
+		topics = append(topics, topicOf(model.Event{AggregateType: aggregateType}))
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, reloading config on SIGHUP.
+func waitForShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			log.Printf("Received %s, shutting down", sig)
+			return
+		}
+		log.Printf("Received SIGHUP, reloading config")
+		if err := config.Reload(); err != nil {
+			log.Printf("Config reload failed: %v", err)
+		}
+	}
 }
 
 func publishEvent(mqEventBus eventbus.EventBus) {
@@ -52,3 +345,76 @@ func consumeEvent(mqEventBus eventbus.EventBus, store *eventstore.BaseEventStore
 		log.Fatalf("Failed to consume event: %v", err)
 	}
 }
+
+func runMigrate(database *db.DB, args []string) {
+	migrator := newMigrator(database)
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		migrateUp(migrator)
+	case "status":
+		statuses, err := migrator.Status(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + time.UnixMilli(st.AppliedAt).Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, state)
+		}
+	default:
+		log.Fatalf("Unknown migrate command %q, expected up or status", cmd)
+	}
+}
+
+func newMigrator(database *db.DB) *migrate.Migrator {
+	migrator, err := migrate.New(database.SQL, database.Driver)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	return migrator
+}
+
+func migrateUp(migrator *migrate.Migrator) {
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to apply migrations: %v", err)
+	}
+	log.Printf("Applied %d migration(s)", len(applied))
+}
+
+// runEncryptSecret reads a secret from stdin and prints an ${enc:...}
+// reference for use in config, encrypted with the local secret key.
+func runEncryptSecret() {
+	key, err := config.LocalSecretKey()
+	if err != nil {
+		log.Fatalf("Failed to load secret key: %v", err)
+	}
+	plaintext, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatalf("Failed to read secret: %v", err)
+	}
+	ref, err := config.EncryptSecret(key, strings.TrimRight(string(plaintext), "\r\n"))
+	if err != nil {
+		log.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	fmt.Println(ref)
+}
+
+// runNewAPIKey prints a new API key with the given ID, and the hash to put
+// in the auth section of the config. Only the hash should be stored.
+func runNewAPIKey(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("Usage: new-api-key <id>")
+	}
+	key, hash, err := auth.NewAPIKey(args[0])
+	if err != nil {
+		log.Fatalf("Failed to create API key: %v", err)
+	}
+	fmt.Printf("key:  %s\nhash: %s\n", key, hash)
+}
@@ -0,0 +1,211 @@
+package aggregate
+
+import (
+	"context"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// State is the in-memory state of an aggregate, rebuilt by applying its
+// events in order. Apply must be deterministic and must not fail for events
+// already in the store other than through corruption.
+type State interface {
+	Apply(event model.Event) error
+}
+
+// Store is the part of the event store repositories use.
+// *eventstore.BaseEventStore and *eventstore.MemoryEventStore implement it.
+type Store interface {
+	LoadEvents(ctx context.Context, aggregateID string, afterVersion int64) ([]model.Event, error)
+	AppendEvents(ctx context.Context, aggregateID string, expectedVersion int64, events []model.Event) error
+}
+
+// Root is a loaded aggregate: its state at Version plus the events raised
+// since it was loaded.
+type Root[S State] struct {
+	ID      string
+	Type    string
+	Version int64
+	State   S
+	// Metadata is copied into every raised event, e.g. a correlation ID.
+	Metadata map[string]string
+	changes  []model.Event
+}
+
+// Raise records a new event with payload encoded as JSON in Data and applies
+// it to State right away, so later decisions in the same command see it.
+func (r *Root[S]) Raise(eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s payload: %w", eventType, err)
+	}
+	event := model.Event{
+		ID:            model.NewID(),
+		AggregateID:   r.ID,
+		AggregateType: r.Type,
+		Version:       r.Version + int64(len(r.changes)) + 1,
+		Type:          eventType,
+		Data:          string(data),
+		Timestamp:     time.Now().UnixMilli(),
+	}
+	if len(r.Metadata) > 0 {
+		event.Metadata = make(map[string]string, len(r.Metadata))
+		for k, v := range r.Metadata {
+			event.Metadata[k] = v
+		}
+	}
+	if err := r.State.Apply(event); err != nil {
+		return err
+	}
+	r.changes = append(r.changes, event)
+	return nil
+}
+
+// Changes returns the events raised since the aggregate was loaded or saved.
+func (r *Root[S]) Changes() []model.Event {
+	return r.changes
+}
+
+// Repository loads and saves aggregates of one type.
+type Repository[S State] struct {
+	store    Store
+	typeName string
+	newState func() S
+}
+
+// NewRepository returns a repository for aggregateType. newState returns the
+// zero state events are applied to, typically a pointer to a new struct.
+func NewRepository[S State](store Store, aggregateType string, newState func() S) *Repository[S] {
+	return &Repository[S]{store: store, typeName: aggregateType, newState: newState}
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a context whose metadata is added to every event
+// raised on aggregates loaded with it, e.g. to correlate the events of a
+// saga. Keys already in ctx are overridden.
+func WithMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range MetadataFrom(ctx) {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFrom returns the metadata set with WithMetadata. It must not be
+// modified.
+func MetadataFrom(ctx context.Context) map[string]string {
+	metadata, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return metadata
+}
+
+// Load replays the events of id. An aggregate without events is returned at
+// version zero in its initial state, with Metadata from ctx. With a
+// SnapshotStore, replay starts from the cached state and the result is
+// cached again.
+func (r *Repository[S]) Load(ctx context.Context, id string) (*Root[S], error) {
+	root := &Root[S]{ID: id, Type: r.typeName, State: r.newState()}
+	if metadata := MetadataFrom(ctx); len(metadata) > 0 {
+		root.Metadata = make(map[string]string, len(metadata))
+		for k, v := range metadata {
+			root.Metadata[k] = v
+		}
+	}
+	snapshots := r.snapshots()
+	if snapshots != nil {
+		if snap, ok := snapshots.Get(ctx, id); ok && snap.Type == r.typeName {
+			state := r.newState()
+			if err := Restore(snap, state); err != nil {
+				log.Printf("Discarding cached state of %s: %v", id, err)
+			} else {
+				root.State, root.Version = state, snap.Version
+			}
+		}
+	}
+	events, err := r.store.LoadEvents(ctx, id, root.Version)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if err := root.State.Apply(event); err != nil {
+			return nil, fmt.Errorf("failed to apply %s event %d of %s: %w", event.Type, event.Version, id, err)
+		}
+		root.Version = event.Version
+	}
+	if snapshots != nil && len(events) > 0 {
+		// LoadEvents reads the primary, so this is the latest state.
+		TakeSnapshot(ctx, snapshots, id, r.typeName, root.Version, root.State)
+	}
+	return root, nil
+}
+
+func (r *Repository[S]) snapshots() Snapshots {
+	if s, ok := r.store.(*SnapshotStore); ok {
+		return s.Snapshots
+	}
+	return nil
+}
+
+// Save appends the raised events, failing with
+// eventstore.ErrConcurrencyConflict if the aggregate changed since Load.
+func (r *Repository[S]) Save(ctx context.Context, root *Root[S]) error {
+	if len(root.changes) == 0 {
+		return nil
+	}
+	if err := r.store.AppendEvents(ctx, root.ID, root.Version, root.changes); err != nil {
+		return err
+	}
+	root.saved()
+	return nil
+}
+
+// BatchStore is a Store that can append to several aggregates atomically.
+type BatchStore interface {
+	Store
+	AppendBatch(ctx context.Context, appends []eventstore.Append) error
+}
+
+// Changed is implemented by *Root of any state type, so aggregates of
+// different types can be saved together with SaveAll.
+type Changed interface {
+	pending() eventstore.Append
+	saved()
+}
+
+func (r *Root[S]) pending() eventstore.Append {
+	return eventstore.Append{AggregateID: r.ID, ExpectedVersion: r.Version, Events: r.changes}
+}
+
+func (r *Root[S]) saved() {
+	r.Version += int64(len(r.changes))
+	r.changes = nil
+}
+
+// SaveAll saves the changes of every root in one transaction, for commands
+// whose invariants span aggregates. It fails with
+// eventstore.ErrConcurrencyConflict if any of them changed since loading.
+func SaveAll(ctx context.Context, store BatchStore, roots ...Changed) error {
+	appends := make([]eventstore.Append, 0, len(roots))
+	for _, root := range roots {
+		if a := root.pending(); len(a.Events) > 0 {
+			appends = append(appends, a)
+		}
+	}
+	if len(appends) == 0 {
+		return nil
+	}
+	if err := store.AppendBatch(ctx, appends); err != nil {
+		return err
+	}
+	for _, root := range roots {
+		root.saved()
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package aggregate
+
+import (
+	"defi/internal/model"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrIllegalTransition is returned by Machine.Next for events the aggregate's
+// current lifecycle state does not allow.
+var ErrIllegalTransition = errors.New("illegal transition")
+
+// Guard vetoes an otherwise allowed transition given the aggregate state
+// before the event is applied.
+type Guard[S any] func(state S, event model.Event) error
+
+type transition[S any] struct {
+	to     model.State
+	guards []Guard[S]
+}
+
+// Machine declares the lifecycle of an aggregate type: which event types are
+// allowed in each model.State and the state each one leads to. Declare it
+// once per type and call Next at the start of State.Apply, so illegal events
+// are refused both when raised and when replayed.
+type Machine[S any] struct {
+	initial     model.State
+	transitions map[model.State]map[string]transition[S]
+}
+
+func NewMachine[S any](initial model.State) *Machine[S] {
+	return &Machine[S]{
+		initial:     initial,
+		transitions: make(map[model.State]map[string]transition[S]),
+	}
+}
+
+// Allow permits eventType in from, moving the aggregate to to if every guard
+// passes. It panics if the pair is already declared.
+func (m *Machine[S]) Allow(from model.State, eventType string, to model.State, guards ...Guard[S]) *Machine[S] {
+	if m.transitions[from] == nil {
+		m.transitions[from] = make(map[string]transition[S])
+	}
+	if _, ok := m.transitions[from][eventType]; ok {
+		panic(fmt.Sprintf("aggregate: transition %s --%s--> already declared", from, eventType))
+	}
+	m.transitions[from][eventType] = transition[S]{to: to, guards: guards}
+	return m
+}
+
+// Initial returns the state of an aggregate without events.
+func (m *Machine[S]) Initial() model.State {
+	return m.initial
+}
+
+// Next returns the state after applying event in current. The empty state is
+// taken as the initial state.
+func (m *Machine[S]) Next(state S, current model.State, event model.Event) (model.State, error) {
+	if current == "" {
+		current = m.initial
+	}
+	t, ok := m.transitions[current][event.Type]
+	if !ok {
+		return current, fmt.Errorf("%w: %s not allowed in state %s", ErrIllegalTransition, event.Type, current)
+	}
+	for _, guard := range t.guards {
+		if err := guard(state, event); err != nil {
+			return current, fmt.Errorf("%w: %s in state %s: %v", ErrIllegalTransition, event.Type, current, err)
+		}
+	}
+	return t.to, nil
+}
+
+// Can reports whether eventType is declared for current, ignoring guards.
+func (m *Machine[S]) Can(current model.State, eventType string) bool {
+	if current == "" {
+		current = m.initial
+	}
+	_, ok := m.transitions[current][eventType]
+	return ok
+}
+
+// Events returns the event types declared for current, sorted.
+func (m *Machine[S]) Events(current model.State) []string {
+	if current == "" {
+		current = m.initial
+	}
+	var events []string
+	for eventType := range m.transitions[current] {
+		events = append(events, eventType)
+	}
+	sort.Strings(events)
+	return events
+}
+
+type edge struct {
+	from, to  model.State
+	eventType string
+	guarded   bool
+}
+
+// edges lists every transition in a stable order for export.
+func (m *Machine[S]) edges() []edge {
+	var edges []edge
+	for from, byType := range m.transitions {
+		for eventType, t := range byType {
+			edges = append(edges, edge{from: from, to: t.to, eventType: eventType, guarded: len(t.guards) > 0})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].eventType < edges[j].eventType
+	})
+	return edges
+}
+
+// DOT renders the machine as a Graphviz digraph. Guarded transitions are
+// dashed.
+func (m *Machine[S]) DOT(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", name)
+	fmt.Fprintf(&b, "  start [shape=point];\n  start -> %q;\n", m.initial)
+	for _, e := range m.edges() {
+		style := ""
+		if e.guarded {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  %q -> %q [label=%q%s];\n", e.from, e.to, e.eventType, style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the machine as a Mermaid stateDiagram. Guarded transitions
+// are suffixed with [guarded].
+func (m *Machine[S]) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	fmt.Fprintf(&b, "  [*] --> %s\n", m.initial)
+	for _, e := range m.edges() {
+		label := e.eventType
+		if e.guarded {
+			label += " [guarded]"
+		}
+		fmt.Fprintf(&b, "  %s --> %s: %s\n", e.from, e.to, label)
+	}
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package aggregate
+
+import (
+	"context"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	Status  model.State
+	Balance int
+}
+
+var accountLifecycle = NewMachine[*account](model.StateInitial).
+	Allow(model.StateInitial, "AccountOpened", model.StateActive).
+	Allow(model.StateActive, "Deposited", model.StateActive).
+	Allow(model.StateActive, "AccountClosed", model.StateClosed, func(a *account, _ model.Event) error {
+		if a.Balance != 0 {
+			return errors.New("balance must be zero")
+		}
+		return nil
+	})
+
+func (a *account) Apply(event model.Event) error {
+	next, err := accountLifecycle.Next(a, a.Status, event)
+	if err != nil {
+		return err
+	}
+	if event.Type == "Deposited" {
+		var amount int
+		if err := json.Unmarshal([]byte(event.Data), &amount); err != nil {
+			return err
+		}
+		a.Balance += amount
+	}
+	a.Status = next
+	return nil
+}
+
+func TestMachine(t *testing.T) {
+	repo := NewRepository(eventstore.NewMemoryEventStore(), "account", func() *account { return &account{} })
+	root, err := repo.Load(context.Background(), "a1")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if err := root.Raise("Deposited", 1); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("deposit before opening: got %v", err)
+	}
+	if err := root.Raise("AccountOpened", nil); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := root.Raise("Deposited", 5); err != nil {
+		t.Fatalf("deposit: %v", err)
+	}
+	if err := root.Raise("AccountClosed", nil); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("close with balance: got %v", err)
+	}
+	if err := root.Raise("Deposited", -5); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	if err := root.Raise("AccountClosed", nil); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := root.Raise("Deposited", 1); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("deposit after closing: got %v", err)
+	}
+	if n := len(root.Changes()); n != 4 {
+		t.Errorf("got %d changes, want 4 (rejected events must not be recorded)", n)
+	}
+	if got := accountLifecycle.Events(model.StateActive); strings.Join(got, ",") != "AccountClosed,Deposited" {
+		t.Errorf("got events %v", got)
+	}
+
+	mermaid := accountLifecycle.Mermaid()
+	for _, line := range []string{"[*] --> initial", "active --> closed: AccountClosed [guarded]"} {
+		if !strings.Contains(mermaid, line) {
+			t.Errorf("mermaid output lacks %q:\n%s", line, mermaid)
+		}
+	}
+	if dot := accountLifecycle.DOT("account"); !strings.Contains(dot, `"active" -> "closed" [label="AccountClosed", style=dashed];`) {
+		t.Errorf("unexpected DOT output:\n%s", dot)
+	}
+}
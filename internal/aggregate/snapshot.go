@@ -0,0 +1,56 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// Snapshot is the state of an aggregate, encoded as JSON, after the event at
+// Version.
+type Snapshot struct {
+	AggregateID string
+	Type        string
+	Version     int64
+	State       json.RawMessage
+}
+
+// Snapshots caches aggregate state. Readers replay only the events after a
+// snapshot's Version, so a snapshot that is behind costs a longer replay but
+// is never wrong, and entries need no invalidation. Snapshots must only be
+// taken from events read on the primary. cache.StateCache implements it.
+type Snapshots interface {
+	Get(ctx context.Context, aggregateID string) (Snapshot, bool)
+	Put(ctx context.Context, snapshot Snapshot) error
+}
+
+// SnapshotStore is a BatchStore whose repositories start loading from
+// Snapshots. State types must survive a round trip through encoding/json.
+type SnapshotStore struct {
+	BatchStore
+	Snapshots Snapshots
+}
+
+// WithSnapshots returns store with snapshots for repositories to use.
+func WithSnapshots(store BatchStore, snapshots Snapshots) *SnapshotStore {
+	return &SnapshotStore{BatchStore: store, Snapshots: snapshots}
+}
+
+// Restore decodes snap into state, which must be in its initial state.
+func Restore(snap Snapshot, state State) error {
+	return json.Unmarshal(snap.State, state)
+}
+
+// TakeSnapshot caches state as the state of aggregateID at version. Failures
+// are logged: the cache only saves work.
+func TakeSnapshot(ctx context.Context, snapshots Snapshots, aggregateID, aggregateType string, version int64, state State) {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("Failed to encode state of %s: %v", aggregateID, err)
+		return
+	}
+	snap := Snapshot{AggregateID: aggregateID, Type: aggregateType, Version: version, State: raw}
+	if err := snapshots.Put(ctx, snap); err != nil {
+		log.Printf("Failed to cache state of %s: %v", aggregateID, err)
+	}
+}
@@ -0,0 +1,87 @@
+package aggregate
+
+import (
+	"context"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"testing"
+)
+
+type counter struct {
+	Count int
+}
+
+func (c *counter) Apply(event model.Event) error {
+	c.Count++
+	return nil
+}
+
+type memorySnapshots map[string]Snapshot
+
+func (m memorySnapshots) Get(_ context.Context, id string) (Snapshot, bool) {
+	snap, ok := m[id]
+	return snap, ok
+}
+
+func (m memorySnapshots) Put(_ context.Context, snap Snapshot) error {
+	m[snap.AggregateID] = snap
+	return nil
+}
+
+// loadCounter counts the events loaded from the store.
+type loadCounter struct {
+	*eventstore.MemoryEventStore
+	loaded int
+}
+
+func (s *loadCounter) LoadEvents(ctx context.Context, id string, afterVersion int64) ([]model.Event, error) {
+	events, err := s.MemoryEventStore.LoadEvents(ctx, id, afterVersion)
+	s.loaded += len(events)
+	return events, err
+}
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	store := &loadCounter{MemoryEventStore: eventstore.NewMemoryEventStore()}
+	snapshots := memorySnapshots{}
+	repo := NewRepository(WithSnapshots(store, snapshots), "counter", func() *counter { return &counter{} })
+	raise := func(n int) {
+		root, err := repo.Load(ctx, "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			root.Raise("Counted", struct{}{})
+		}
+		if err := repo.Save(ctx, root); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raise(3)
+	raise(2)
+	// The second load replayed the 3 events; the cached state was at 3 and
+	// only the 2 after it are replayed now.
+	store.loaded = 0
+	root, err := repo.Load(ctx, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Version != 5 || root.State.Count != 5 || store.loaded != 2 {
+		t.Errorf("got version %d, count %d after loading %d events", root.Version, root.State.Count, store.loaded)
+	}
+	if snap := snapshots["c"]; snap.Version != 5 || string(snap.State) != `{"Count":5}` {
+		t.Errorf("snapshot: %+v", snap)
+	}
+
+	// Snapshots of another type or that fail to decode are ignored.
+	snapshots["c"] = Snapshot{AggregateID: "c", Type: "other", Version: 5, State: json.RawMessage(`{"Count":50}`)}
+	if root, _ := repo.Load(ctx, "c"); root.State.Count != 5 {
+		t.Errorf("other type: count %d", root.State.Count)
+	}
+	snapshots["c"] = Snapshot{AggregateID: "c", Type: "counter", Version: 5, State: json.RawMessage(`[`)}
+	if root, _ := repo.Load(ctx, "c"); root.State.Count != 5 {
+		t.Errorf("corrupt: count %d", root.State.Count)
+	}
+}
@@ -0,0 +1,68 @@
+package amm
+
+import (
+	"context"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"errors"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	bus := command.NewBus()
+	Register(bus, store)
+	eth := func(s string) model.Amount { return model.MustParseAmount(s, 18) }
+	usdc := func(s string) model.Amount { return model.MustParseAmount(s, 6) }
+	dispatch := func(cmd interface{}) error { return bus.Dispatch(ctx, cmd) }
+
+	if err := dispatch(CreatePool{PoolID: "eth-usdc", Token0: "ETH", Token1: "USDC", Decimals0: 18, Decimals1: 6, FeeBps: 25}); err == nil {
+		t.Error("expected a fee outside the tiers to be invalid")
+	}
+	if err := dispatch(CreatePool{PoolID: "eth-usdc", Token0: "ETH", Token1: "USDC", Decimals0: 18, Decimals1: 6, FeeBps: 30}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := dispatch(AddLiquidity{PoolID: "eth-usdc", Provider: "lp", Amount0: eth("100"), Amount1: usdc("200000")}); err != nil {
+		t.Fatalf("add liquidity: %v", err)
+	}
+
+	// 1 ETH in a 100 ETH pool: 0.3% fee plus about 1% price impact.
+	if err := dispatch(Swap{PoolID: "eth-usdc", Trader: "t", TokenIn: "ETH", AmountIn: eth("1"), MinAmountOut: usdc("1980")}); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("expected slippage rejection, got %v", err)
+	}
+	if err := dispatch(Swap{PoolID: "eth-usdc", Trader: "t", TokenIn: "ETH", AmountIn: eth("10"), MaxPriceImpactBps: 500}); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("expected price impact rejection, got %v", err)
+	}
+	if err := dispatch(Swap{PoolID: "eth-usdc", Trader: "t", TokenIn: "ETH", AmountIn: eth("1"), MinAmountOut: usdc("1970")}); err != nil {
+		t.Fatalf("swap: %v", err)
+	}
+
+	stats := NewPoolStats()
+	if err := stats.CatchUp(ctx, store); err != nil {
+		t.Fatalf("catch up: %v", err)
+	}
+	snap, ok := stats.Pool("eth-usdc")
+	if !ok {
+		t.Fatal("pool missing from stats")
+	}
+	// out = 0.997 * 200000 / (100 + 0.997), rounded down to 6 decimals.
+	if want := usdc("198025.683932"); snap.Reserve1.Cmp(want) != 0 {
+		t.Errorf("USDC reserve = %s, want %s", snap.Reserve1, want)
+	}
+	if snap.Reserve0.Cmp(eth("101")) != 0 || snap.Swaps != 1 || snap.Fees["ETH"].Cmp(eth("0.003")) != 0 {
+		t.Errorf("unexpected stats %+v", snap)
+	}
+	tvl, err := stats.TVL("eth-usdc", func(token string) (model.Amount, bool) {
+		return map[string]model.Amount{"ETH": usdc("2000"), "USDC": usdc("1")}[token], true
+	})
+	if err != nil || tvl.Cmp(usdc("400025.683932")) != 0 {
+		t.Errorf("TVL = %s, %v", tvl, err)
+	}
+
+	lp := snap.Shares
+	if err := dispatch(RemoveLiquidity{PoolID: "eth-usdc", Provider: "lp", Shares: lp}); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("removing locked shares: got %v", err)
+	}
+}
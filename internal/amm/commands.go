@@ -0,0 +1,247 @@
+package amm
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/command"
+	"defi/internal/model"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+type CreatePool struct {
+	PoolID    string
+	Token0    string
+	Token1    string
+	Decimals0 uint8
+	Decimals1 uint8
+	FeeBps    int64
+}
+
+func (c CreatePool) Validate() error {
+	switch {
+	case c.PoolID == "":
+		return errors.New("pool ID is required")
+	case c.Token0 == "" || c.Token1 == "":
+		return errors.New("both tokens are required")
+	case c.Token0 == c.Token1:
+		return errors.New("tokens must differ")
+	}
+	if err := model.CheckAssetDecimals(c.Token0, c.Decimals0); err != nil {
+		return err
+	}
+	if err := model.CheckAssetDecimals(c.Token1, c.Decimals1); err != nil {
+		return err
+	}
+	for _, tier := range FeeTiers {
+		if c.FeeBps == tier {
+			return nil
+		}
+	}
+	return fmt.Errorf("fee must be one of %v basis points", FeeTiers)
+}
+
+// AddLiquidity deposits at most Amount0 and Amount1. After the first
+// deposit only the amounts matching the current reserve ratio are taken.
+type AddLiquidity struct {
+	PoolID    string
+	Provider  string
+	Amount0   model.Amount
+	Amount1   model.Amount
+	MinShares model.Amount
+}
+
+func (c AddLiquidity) Validate() error {
+	switch {
+	case c.PoolID == "" || c.Provider == "":
+		return errors.New("pool ID and provider are required")
+	case c.Amount0.Sign() <= 0 || c.Amount1.Sign() <= 0:
+		return errors.New("amounts must be positive")
+	}
+	return nil
+}
+
+type RemoveLiquidity struct {
+	PoolID     string
+	Provider   string
+	Shares     model.Amount
+	MinAmount0 model.Amount
+	MinAmount1 model.Amount
+}
+
+func (c RemoveLiquidity) Validate() error {
+	switch {
+	case c.PoolID == "" || c.Provider == "":
+		return errors.New("pool ID and provider are required")
+	case c.Shares.Sign() <= 0:
+		return errors.New("shares must be positive")
+	}
+	return nil
+}
+
+// Swap sells exactly AmountIn of TokenIn. It is rejected if it would yield
+// less than MinAmountOut or, when MaxPriceImpactBps is set, move the price
+// by more than that.
+type Swap struct {
+	PoolID            string
+	Trader            string
+	TokenIn           string
+	AmountIn          model.Amount
+	MinAmountOut      model.Amount
+	MaxPriceImpactBps int64
+}
+
+func (c Swap) Validate() error {
+	switch {
+	case c.PoolID == "" || c.Trader == "" || c.TokenIn == "":
+		return errors.New("pool ID, trader and input token are required")
+	case c.AmountIn.Sign() <= 0:
+		return errors.New("input amount must be positive")
+	case c.MinAmountOut.Sign() < 0 || c.MaxPriceImpactBps < 0:
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// Register adds the pool command handlers to bus.
+func Register(bus *command.Bus, store aggregate.Store) {
+	pools := aggregate.NewRepository(store, PoolType, NewPool)
+	command.Register(bus, command.Handle(pools, func(c CreatePool) string { return c.PoolID }, createPool))
+	command.Register(bus, command.Handle(pools, func(c AddLiquidity) string { return c.PoolID }, addLiquidity))
+	command.Register(bus, command.Handle(pools, func(c RemoveLiquidity) string { return c.PoolID }, removeLiquidity))
+	command.Register(bus, command.Handle(pools, func(c Swap) string { return c.PoolID }, swap))
+}
+
+func createPool(ctx context.Context, c CreatePool, root *aggregate.Root[*Pool]) error {
+	return root.Raise(EventPoolCreated, PoolCreated{
+		Token0:    c.Token0,
+		Token1:    c.Token1,
+		Decimals0: c.Decimals0,
+		Decimals1: c.Decimals1,
+		FeeBps:    c.FeeBps,
+	})
+}
+
+func addLiquidity(ctx context.Context, c AddLiquidity, root *aggregate.Root[*Pool]) error {
+	p := root.State
+	if p.Status != model.StateActive {
+		return command.Rejectf("pool %s does not exist", root.ID)
+	}
+	if c.Amount0.Decimals() != p.Reserve0.Decimals() || c.Amount1.Decimals() != p.Reserve1.Decimals() {
+		return command.Rejectf("amounts must have %d and %d decimals", p.Reserve0.Decimals(), p.Reserve1.Decimals())
+	}
+
+	a0, a1 := c.Amount0.Units(), c.Amount1.Units()
+	var shares, locked *big.Int
+	if p.TotalShares.IsZero() {
+		shares = new(big.Int).Sqrt(new(big.Int).Mul(a0, a1))
+		locked = big.NewInt(MinimumLiquidity)
+		if shares.Cmp(locked) <= 0 {
+			return command.Rejectf("first deposit must mint more than %d shares", MinimumLiquidity)
+		}
+		shares.Sub(shares, locked)
+	} else {
+		r0, r1, total := p.Reserve0.Units(), p.Reserve1.Units(), p.TotalShares.Units()
+		// Take all of one side and the matching amount of the other, rounding
+		// the matching amount up in the pool's favour.
+		if want1 := ceilDiv(new(big.Int).Mul(a0, r1), r0); want1.Cmp(a1) <= 0 {
+			a1 = want1
+		} else {
+			a0 = ceilDiv(new(big.Int).Mul(a1, r0), r1)
+		}
+		shares0 := new(big.Int).Quo(new(big.Int).Mul(a0, total), r0)
+		shares1 := new(big.Int).Quo(new(big.Int).Mul(a1, total), r1)
+		shares = shares0
+		if shares1.Cmp(shares0) < 0 {
+			shares = shares1
+		}
+		locked = new(big.Int)
+	}
+	if shares.Sign() <= 0 {
+		return command.Rejectf("deposit too small to mint shares")
+	}
+
+	e := LiquidityAdded{Provider: c.Provider}
+	var err error
+	if e.Amount0, err = model.NewAmount(a0, p.Reserve0.Decimals()); err != nil {
+		return err
+	}
+	if e.Amount1, err = model.NewAmount(a1, p.Reserve1.Decimals()); err != nil {
+		return err
+	}
+	if e.Shares, err = model.NewAmount(shares, ShareDecimals); err != nil {
+		return err
+	}
+	if e.Locked, err = model.NewAmount(locked, ShareDecimals); err != nil {
+		return err
+	}
+	if c.MinShares.Sign() > 0 && e.Shares.Cmp(c.MinShares) < 0 {
+		return command.Rejectf("would mint %s shares, below the minimum %s", e.Shares, c.MinShares)
+	}
+	return root.Raise(EventLiquidityAdded, e)
+}
+
+func removeLiquidity(ctx context.Context, c RemoveLiquidity, root *aggregate.Root[*Pool]) error {
+	p := root.State
+	if p.Status != model.StateActive {
+		return command.Rejectf("pool %s does not exist", root.ID)
+	}
+	held := p.Shares[c.Provider]
+	if c.Shares.Decimals() != ShareDecimals || held.Cmp(c.Shares) < 0 {
+		return command.Rejectf("%s holds %s shares", c.Provider, held)
+	}
+
+	e := LiquidityRemoved{Provider: c.Provider, Shares: c.Shares}
+	var err error
+	if e.Amount0, err = p.Reserve0.MulDiv(c.Shares.Units(), p.TotalShares.Units(), model.RoundDown); err != nil {
+		return err
+	}
+	if e.Amount1, err = p.Reserve1.MulDiv(c.Shares.Units(), p.TotalShares.Units(), model.RoundDown); err != nil {
+		return err
+	}
+	if e.Amount0.Cmp(c.MinAmount0) < 0 || e.Amount1.Cmp(c.MinAmount1) < 0 {
+		return command.Rejectf("would return %s and %s, below the minimums", e.Amount0, e.Amount1)
+	}
+	return root.Raise(EventLiquidityRemoved, e)
+}
+
+func swap(ctx context.Context, c Swap, root *aggregate.Root[*Pool]) error {
+	p := root.State
+	if p.Status != model.StateActive {
+		return command.Rejectf("pool %s does not exist", root.ID)
+	}
+	_, _, tokenOut, ok := p.Reserves(c.TokenIn)
+	if !ok {
+		return command.Rejectf("token %s is not in pool %s", c.TokenIn, root.ID)
+	}
+	quote, err := p.Quote(c.TokenIn, c.AmountIn)
+	if err != nil {
+		return command.Rejectf("%v", err)
+	}
+	switch {
+	case quote.AmountOut.Sign() <= 0:
+		return command.Rejectf("swap too small")
+	case quote.AmountOut.Cmp(c.MinAmountOut) < 0:
+		return command.Rejectf("would receive %s %s, below the minimum %s", quote.AmountOut, tokenOut, c.MinAmountOut)
+	case c.MaxPriceImpactBps > 0 && quote.PriceImpactBps > c.MaxPriceImpactBps:
+		return command.Rejectf("price impact of %d bps exceeds %d", quote.PriceImpactBps, c.MaxPriceImpactBps)
+	}
+	return root.Raise(EventSwapped, Swapped{
+		Trader:         c.Trader,
+		TokenIn:        c.TokenIn,
+		TokenOut:       tokenOut,
+		AmountIn:       c.AmountIn,
+		AmountOut:      quote.AmountOut,
+		Fee:            quote.Fee,
+		PriceImpactBps: quote.PriceImpactBps,
+	})
+}
+
+func ceilDiv(n, d *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if r.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return q
+}
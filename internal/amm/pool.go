@@ -0,0 +1,260 @@
+package amm
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/model"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// Aggregate and event types of liquidity pools.
+const (
+	PoolType = "pool"
+
+	EventPoolCreated      = "PoolCreated"
+	EventLiquidityAdded   = "LiquidityAdded"
+	EventLiquidityRemoved = "LiquidityRemoved"
+	EventSwapped          = "Swapped"
+)
+
+const (
+	// ShareDecimals is the precision of LP shares.
+	ShareDecimals = 18
+	// MinimumLiquidity shares are locked forever by the first deposit, so the
+	// pool can never be drained back to empty reserves.
+	MinimumLiquidity = 1000
+	bpsDenominator   = 10000
+)
+
+// FeeTiers are the allowed swap fees in basis points.
+var FeeTiers = []int64{1, 5, 30, 100}
+
+type PoolCreated struct {
+	Token0    string `json:"token0"`
+	Token1    string `json:"token1"`
+	Decimals0 uint8  `json:"decimals0"`
+	Decimals1 uint8  `json:"decimals1"`
+	FeeBps    int64  `json:"feeBps"`
+}
+
+type LiquidityAdded struct {
+	Provider string       `json:"provider"`
+	Amount0  model.Amount `json:"amount0"`
+	Amount1  model.Amount `json:"amount1"`
+	Shares   model.Amount `json:"shares"`
+	// Locked is the MinimumLiquidity minted to nobody on the first deposit.
+	Locked model.Amount `json:"locked"`
+}
+
+type LiquidityRemoved struct {
+	Provider string       `json:"provider"`
+	Amount0  model.Amount `json:"amount0"`
+	Amount1  model.Amount `json:"amount1"`
+	Shares   model.Amount `json:"shares"`
+}
+
+type Swapped struct {
+	Trader    string       `json:"trader"`
+	TokenIn   string       `json:"tokenIn"`
+	TokenOut  string       `json:"tokenOut"`
+	AmountIn  model.Amount `json:"amountIn"`
+	AmountOut model.Amount `json:"amountOut"`
+	// Fee is the part of AmountIn kept by liquidity providers.
+	Fee            model.Amount `json:"fee"`
+	PriceImpactBps int64        `json:"priceImpactBps"`
+}
+
+// Pool is the state of a constant-product pool holding Reserve0 of Token0
+// and Reserve1 of Token1.
+type Pool struct {
+	Status      model.State
+	Token0      string
+	Token1      string
+	FeeBps      int64
+	Reserve0    model.Amount
+	Reserve1    model.Amount
+	TotalShares model.Amount
+	Shares      map[string]model.Amount
+}
+
+var poolLifecycle = aggregate.NewMachine[*Pool](model.StateInitial).
+	Allow(model.StateInitial, EventPoolCreated, model.StateActive).
+	Allow(model.StateActive, EventLiquidityAdded, model.StateActive).
+	Allow(model.StateActive, EventLiquidityRemoved, model.StateActive).
+	Allow(model.StateActive, EventSwapped, model.StateActive, func(p *Pool, _ model.Event) error {
+		if p.TotalShares.IsZero() {
+			return fmt.Errorf("pool has no liquidity")
+		}
+		return nil
+	})
+
+func NewPool() *Pool {
+	return &Pool{Shares: make(map[string]model.Amount)}
+}
+
+func (p *Pool) Apply(event model.Event) error {
+	next, err := poolLifecycle.Next(p, p.Status, event)
+	if err != nil {
+		return err
+	}
+	switch event.Type {
+	case EventPoolCreated:
+		var e PoolCreated
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		p.Token0, p.Token1, p.FeeBps = e.Token0, e.Token1, e.FeeBps
+		p.Reserve0 = model.Units(0, e.Decimals0)
+		p.Reserve1 = model.Units(0, e.Decimals1)
+		p.TotalShares = model.Units(0, ShareDecimals)
+	case EventLiquidityAdded:
+		var e LiquidityAdded
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		minted, err := e.Shares.Add(e.Locked)
+		if err != nil {
+			return err
+		}
+		if err := p.move(e.Amount0, e.Amount1, minted, e.Provider, e.Shares); err != nil {
+			return err
+		}
+	case EventLiquidityRemoved:
+		var e LiquidityRemoved
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		if err := p.move(e.Amount0.Neg(), e.Amount1.Neg(), e.Shares.Neg(), e.Provider, e.Shares.Neg()); err != nil {
+			return err
+		}
+	case EventSwapped:
+		var e Swapped
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		in, out := e.AmountIn, e.AmountOut.Neg()
+		if e.TokenIn == p.Token1 {
+			in, out = out, in
+		}
+		if err := p.move(in, out, model.Units(0, ShareDecimals), "", model.Amount{}); err != nil {
+			return err
+		}
+	}
+	p.Status = next
+	return nil
+}
+
+// move adjusts reserves by d0 and d1, total shares by dTotal and the shares
+// of provider by dShares, failing without changes if any would go negative.
+func (p *Pool) move(d0, d1, dTotal model.Amount, provider string, dShares model.Amount) error {
+	r0, err := p.Reserve0.Add(d0)
+	if err != nil {
+		return err
+	}
+	r1, err := p.Reserve1.Add(d1)
+	if err != nil {
+		return err
+	}
+	total, err := p.TotalShares.Add(dTotal)
+	if err != nil {
+		return err
+	}
+	held := p.Shares[provider]
+	if provider != "" {
+		if held.Decimals() != ShareDecimals {
+			held = model.Units(0, ShareDecimals)
+		}
+		if held, err = held.Add(dShares); err != nil {
+			return err
+		}
+	}
+	if r0.Sign() < 0 || r1.Sign() < 0 || total.Sign() < 0 || held.Sign() < 0 {
+		return fmt.Errorf("pool balances would go negative")
+	}
+	p.Reserve0, p.Reserve1, p.TotalShares = r0, r1, total
+	if provider != "" {
+		p.Shares[provider] = held
+	}
+	return nil
+}
+
+func decode(event model.Event, out interface{}) error {
+	if err := json.Unmarshal([]byte(event.Data), out); err != nil {
+		return fmt.Errorf("invalid %s: %w", event.Type, err)
+	}
+	return nil
+}
+
+// Reserves returns the reserves of tokenIn and the other token, in that
+// order, and whether tokenIn belongs to the pool.
+func (p *Pool) Reserves(tokenIn string) (in, out model.Amount, tokenOut string, ok bool) {
+	switch tokenIn {
+	case p.Token0:
+		return p.Reserve0, p.Reserve1, p.Token1, true
+	case p.Token1:
+		return p.Reserve1, p.Reserve0, p.Token0, true
+	}
+	return model.Amount{}, model.Amount{}, "", false
+}
+
+// Quote is the outcome of swapping an exact input.
+type Quote struct {
+	AmountOut      model.Amount
+	Fee            model.Amount
+	PriceImpactBps int64
+}
+
+// Quote prices swapping amountIn of tokenIn with x*y=k: the fee is taken from
+// the input, and the output is rounded down so k never decreases. Price
+// impact compares the execution price to the spot price before the swap.
+func (p *Pool) Quote(tokenIn string, amountIn model.Amount) (Quote, error) {
+	reserveIn, reserveOut, _, ok := p.Reserves(tokenIn)
+	if !ok {
+		return Quote{}, fmt.Errorf("token %s is not in the pool", tokenIn)
+	}
+	if amountIn.Decimals() != reserveIn.Decimals() {
+		return Quote{}, fmt.Errorf("%w: %s has %d decimals", model.ErrDecimalsMismatch, tokenIn, reserveIn.Decimals())
+	}
+	if reserveIn.IsZero() || reserveOut.IsZero() {
+		return Quote{}, fmt.Errorf("pool has no liquidity")
+	}
+
+	afterFee, err := amountIn.MulDiv(big.NewInt(bpsDenominator-p.FeeBps), big.NewInt(bpsDenominator), model.RoundDown)
+	if err != nil {
+		return Quote{}, err
+	}
+	fee, err := amountIn.Sub(afterFee)
+	if err != nil {
+		return Quote{}, err
+	}
+	in, rIn, rOut := afterFee.Units(), reserveIn.Units(), reserveOut.Units()
+	out := new(big.Int).Mul(in, rOut)
+	out.Quo(out, new(big.Int).Add(rIn, in))
+	amountOut, err := model.NewAmount(out, reserveOut.Decimals())
+	if err != nil {
+		return Quote{}, err
+	}
+
+	impact := int64(0)
+	if in.Sign() > 0 {
+		// impact = 1 - (out/in) / (rOut/rIn) = 1 - out*rIn / (in*rOut)
+		num := new(big.Int).Mul(out, rIn)
+		num.Mul(num, big.NewInt(bpsDenominator))
+		den := new(big.Int).Mul(in, rOut)
+		impact = bpsDenominator - new(big.Int).Quo(num, den).Int64()
+	}
+	return Quote{AmountOut: amountOut, Fee: fee, PriceImpactBps: impact}, nil
+}
+
+// SpotPrice returns the price of Token0 in Token1, as an amount of Token1.
+func (p *Pool) SpotPrice() (model.Amount, error) {
+	if p.Reserve0.IsZero() {
+		return model.Amount{}, fmt.Errorf("pool has no liquidity")
+	}
+	return p.Reserve1.MulDiv(pow10(p.Reserve0.Decimals()), p.Reserve0.Units(), model.RoundDown)
+}
+
+func pow10(n uint8) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
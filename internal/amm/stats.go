@@ -0,0 +1,137 @@
+package amm
+
+import (
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"fmt"
+)
+
+// PriceFunc returns the price of one whole token in a common quote asset.
+type PriceFunc func(token string) (model.Amount, bool)
+
+// PoolStats is a read model of every pool's reserves, cumulative volume and
+// fees. Feed it pool events with Handle or CatchUp; events already seen are
+// ignored.
+type PoolStats struct {
+	*eventstore.ReadModel[*poolStats]
+}
+
+type poolStats struct {
+	pool   *Pool
+	swaps  int64
+	volume map[string]model.Amount
+	fees   map[string]model.Amount
+}
+
+// PoolSnapshot is a copy of one pool's statistics. Volume and Fees are keyed
+// by input token.
+type PoolSnapshot struct {
+	PoolID   string
+	Token0   string
+	Token1   string
+	FeeBps   int64
+	Reserve0 model.Amount
+	Reserve1 model.Amount
+	Shares   model.Amount
+	Swaps    int64
+	Volume   map[string]model.Amount
+	Fees     map[string]model.Amount
+}
+
+func NewPoolStats() *PoolStats {
+	return &PoolStats{eventstore.NewReadModel(PoolType, newPoolStats, applyPoolStats)}
+}
+
+func newPoolStats() *poolStats {
+	return &poolStats{pool: NewPool(), volume: make(map[string]model.Amount), fees: make(map[string]model.Amount)}
+}
+
+func applyPoolStats(st *poolStats, event model.Event) error {
+	if err := st.pool.Apply(event); err != nil {
+		return err
+	}
+	if event.Type == EventSwapped {
+		var e Swapped
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		st.swaps++
+		st.volume[e.TokenIn] = addTo(st.volume[e.TokenIn], e.AmountIn)
+		st.fees[e.TokenIn] = addTo(st.fees[e.TokenIn], e.Fee)
+	}
+	return nil
+}
+
+func addTo(total, amount model.Amount) model.Amount {
+	if total.Decimals() != amount.Decimals() {
+		total = model.Units(0, amount.Decimals())
+	}
+	// Sums of amounts that fit in reserves cannot realistically reach 2^256.
+	sum, err := total.Add(amount)
+	if err != nil {
+		return total
+	}
+	return sum
+}
+
+// Pool returns a snapshot of poolID.
+func (s *PoolStats) Pool(poolID string) (PoolSnapshot, bool) {
+	var snap PoolSnapshot
+	active := false
+	s.View(poolID, func(st *poolStats, _ int64) {
+		if st.pool.Status != model.StateActive {
+			return
+		}
+		active = true
+		snap = PoolSnapshot{
+			PoolID:   poolID,
+			Token0:   st.pool.Token0,
+			Token1:   st.pool.Token1,
+			FeeBps:   st.pool.FeeBps,
+			Reserve0: st.pool.Reserve0,
+			Reserve1: st.pool.Reserve1,
+			Shares:   st.pool.TotalShares,
+			Swaps:    st.swaps,
+			Volume:   make(map[string]model.Amount),
+			Fees:     make(map[string]model.Amount),
+		}
+		for token, v := range st.volume {
+			snap.Volume[token] = v
+		}
+		for token, v := range st.fees {
+			snap.Fees[token] = v
+		}
+	})
+	return snap, active
+}
+
+// TVL values the reserves of poolID in the quote asset of price. With a nil
+// price, TVL is in Token1 using the pool's own spot price, i.e. twice
+// Reserve1.
+func (s *PoolStats) TVL(poolID string, price PriceFunc) (model.Amount, error) {
+	snap, ok := s.Pool(poolID)
+	if !ok {
+		return model.Amount{}, fmt.Errorf("unknown pool %s", poolID)
+	}
+	if price == nil {
+		return snap.Reserve1.Add(snap.Reserve1)
+	}
+	v0, err := value(snap.Reserve0, snap.Token0, price)
+	if err != nil {
+		return model.Amount{}, err
+	}
+	v1, err := value(snap.Reserve1, snap.Token1, price)
+	if err != nil {
+		return model.Amount{}, err
+	}
+	return v0.Add(v1)
+}
+
+// value converts amount of token to the quote asset, rounding down.
+func value(amount model.Amount, token string, price PriceFunc) (model.Amount, error) {
+	p, ok := price(token)
+	if !ok {
+		return model.Amount{}, fmt.Errorf("no price for %s", token)
+	}
+	return p.MulDiv(amount.Units(), pow10(amount.Decimals()), model.RoundDown)
+}
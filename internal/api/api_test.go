@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/cache"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/ledger"
+	"defi/internal/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type response struct {
+	Data  json.RawMessage `json:"data"`
+	Page  *pageInfo       `json:"page"`
+	Error *Error          `json:"error"`
+}
+
+func get(t *testing.T, router http.Handler, url string) (int, response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	var body response
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: invalid body %q: %v", url, rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+func TestReadEndpoints(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	bus := command.NewBus()
+	ledger.New(store).Register(bus)
+	model.RegisterAsset("ETH", 18)
+	for _, cmd := range []interface{}{
+		ledger.OpenAccount{AccountID: "treasury", Kind: ledger.KindEquity},
+		ledger.OpenAccount{AccountID: "alice", Kind: ledger.KindAsset},
+		ledger.PostEntry{EntryID: "e1", Legs: []ledger.Leg{
+			{AccountID: "alice", Asset: "ETH", Side: ledger.Debit, Amount: model.MustParseAmount("2", 18)},
+			{AccountID: "treasury", Asset: "ETH", Side: ledger.Credit, Amount: model.MustParseAmount("2", 18)},
+		}},
+	} {
+		if err := bus.Dispatch(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveEvent(model.Event{ID: "loose", AggregateID: "x", AggregateType: "misc", Type: "Noted", Data: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	InitEventStore(store)
+	RegisterState(ledger.AccountType, func() aggregate.State { return ledger.NewAccount() })
+	router := NewRouter()
+
+	code, body := get(t, router, "/events?aggregate_type=account&limit=2")
+	var events []model.Event
+	if code != http.StatusOK || json.Unmarshal(body.Data, &events) != nil || len(events) != 2 || body.Page.NextCursor == "" {
+		t.Fatalf("first page: %d %+v", code, body)
+	}
+	code, body = get(t, router, "/events?aggregate_type=account&limit=2&cursor="+body.Page.NextCursor)
+	if code != http.StatusOK || json.Unmarshal(body.Data, &events) != nil || len(events) != 2 || body.Page.NextCursor != "" {
+		t.Fatalf("last page: %d %+v", code, body)
+	}
+
+	for url, want := range map[string]int{
+		"/events?limit=x":            http.StatusBadRequest,
+		"/events?limit=5000":         http.StatusBadRequest,
+		"/events?order=sideways":     http.StatusBadRequest,
+		"/events?cursor=!!":          http.StatusBadRequest,
+		"/events/nope":               http.StatusNotFound,
+		"/aggregates/nope/events":    http.StatusNotFound,
+		"/aggregates/nope/state":     http.StatusNotFound,
+		"/aggregates/x/state":        http.StatusNotFound,
+		"/events/" + events[1].ID:    http.StatusOK,
+		"/aggregates/alice/events":   http.StatusOK,
+		"/events?type=AccountOpened": http.StatusOK,
+	} {
+		if code, body := get(t, router, url); code != want || (code >= 400) != (body.Error != nil) {
+			t.Errorf("GET %s: got %d %+v, want %d", url, code, body.Error, want)
+		}
+	}
+	if _, body := get(t, router, "/aggregates/x/state"); body.Error.Code != CodeNoProjection {
+		t.Errorf("unregistered type: got %+v", body.Error)
+	}
+
+	code, body = get(t, router, "/aggregates/alice/state")
+	var state struct {
+		ID      string
+		Version int64
+		State   ledger.Account
+	}
+	if code != http.StatusOK || json.Unmarshal(body.Data, &state) != nil {
+		t.Fatalf("state: %d %+v", code, body)
+	}
+	if state.Version != 2 || state.State.Balances["ETH"].Cmp(model.MustParseAmount("2", 18)) != 0 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	// With the state cache, the state is cached at its version and later
+	// reads apply only newer events on top.
+	states := cache.NewStateCache(cache.NewMemoryCache(), time.Minute)
+	InitSnapshots(states)
+	defer InitSnapshots(nil)
+	for _, balance := range []string{"2", "3"} {
+		code, body = get(t, router, "/aggregates/alice/state")
+		if code != http.StatusOK || json.Unmarshal(body.Data, &state) != nil || state.State.Balances["ETH"].Cmp(model.MustParseAmount(balance, 18)) != 0 {
+			t.Fatalf("cached state: %d %+v", code, body)
+		}
+		if snap, ok := states.Get(ctx, "alice"); !ok || snap.Version != state.Version {
+			t.Fatalf("snapshot: %+v, %v", snap, ok)
+		}
+		err := bus.Dispatch(ctx, ledger.PostEntry{EntryID: "e" + balance, Legs: []ledger.Leg{
+			{AccountID: "alice", Asset: "ETH", Side: ledger.Debit, Amount: model.MustParseAmount("1", 18)},
+			{AccountID: "treasury", Asset: "ETH", Side: ledger.Credit, Amount: model.MustParseAmount("1", 18)},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/auth"
+	"fmt"
+	"net/http"
+)
+
+var authenticator *auth.Authenticator
+
+// InitAuth requires every request to be authenticated by a and authorized
+// by the caller's scopes. Without it the API is open to anyone.
+func InitAuth(a *auth.Authenticator) {
+	authenticator = a
+}
+
+// authorize authenticates the caller and lets it through if it may perform
+// action on at least one aggregate type; handlers check the types they
+// touch with allowed. The caller is recorded in the metadata of the events
+// the request causes.
+func authorize(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authenticator == nil {
+			next(w, r)
+			return
+		}
+		p, err := authenticator.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="defi"`)
+			writeError(w, r, &Error{Status: http.StatusUnauthorized, Code: CodeUnauthenticated, Message: err.Error()})
+			return
+		}
+		if !p.AllowsAny(action) {
+			writeError(w, r, forbidden(fmt.Sprintf("%s may not %s", p.ID, action)))
+			return
+		}
+		metadata := make(map[string]string)
+		for key, value := range aggregate.MetadataFrom(r.Context()) {
+			metadata[key] = value
+		}
+		metadata[auth.MetadataPrincipal] = p.ID
+		metadata[auth.MetadataMethod] = p.Method
+		ctx := aggregate.WithMetadata(auth.WithPrincipal(r.Context(), p), metadata)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// allowed returns an error unless the caller of r may perform action on
+// events of aggregateType. An empty aggregateType asks for every type.
+func allowed(r *http.Request, action, aggregateType string) error {
+	p := auth.FromContext(r.Context())
+	if p == nil || p.Allows(action, aggregateType) {
+		return nil
+	}
+	if aggregateType == "" {
+		return forbidden(fmt.Sprintf("%s may only %s some aggregate types; filter by aggregate_type", p.ID, action))
+	}
+	return forbidden(fmt.Sprintf("%s may not %s %s events", p.ID, action, aggregateType))
+}
+
+func forbidden(message string) *Error {
+	return &Error{Status: http.StatusForbidden, Code: CodeForbidden, Message: message}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"defi/internal/auth"
+	"defi/internal/config"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthorization(t *testing.T) {
+	if err := RegisterEventSchema("payment", "Paid", []byte(`{"type": "object"}`)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.AuthConfig{Roles: map[string][]string{"admin": {auth.All}}}
+	apiKeys := make(map[string]string)
+	for id, grant := range map[string]config.Grant{
+		"admin":    {Roles: []string{"admin"}},
+		"reader":   {Scopes: []string{auth.Read}},
+		"payments": {Scopes: []string{auth.Read + ":payment", auth.Write + ":payment"}},
+	} {
+		key, hash, err := auth.NewAPIKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		apiKeys[id] = key
+		cfg.APIKeys = append(cfg.APIKeys, config.APIKeyConfig{ID: id, Hash: hash, Grant: grant})
+	}
+	authenticator, err := auth.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := eventstore.NewMemoryEventStore()
+	store.SaveEvent(model.Event{ID: "acct", AggregateID: "alice", AggregateType: "account", Type: "Opened", Data: "{}"})
+	InitEventStore(store)
+	InitAuth(authenticator)
+	defer InitAuth(nil)
+	router := NewRouter()
+
+	send := func(caller, method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if caller != "" {
+			r.Header.Set(auth.APIKeyHeader, apiKeys[caller])
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+	payment := `{"AggregateID": "p1", "AggregateType": "payment", "Type": "Paid", "Data": {}}`
+
+	for _, tt := range []struct {
+		caller, method, url, body string
+		want                      int
+	}{
+		{"", http.MethodGet, "/events", "", http.StatusUnauthorized},
+		{"reader", http.MethodPost, "/events", payment, http.StatusForbidden},
+		{"payments", http.MethodPost, "/events", strings.Replace(payment, "payment", "account", 1), http.StatusForbidden},
+		{"payments", http.MethodPost, "/events", strings.Replace(payment, "{}}", `{}, "Metadata": {"principal": "admin"}}`, 1), http.StatusUnprocessableEntity},
+		{"payments", http.MethodGet, "/events", "", http.StatusForbidden},
+		{"payments", http.MethodGet, "/events?aggregate_type=payment", "", http.StatusOK},
+		{"payments", http.MethodGet, "/events/acct", "", http.StatusForbidden},
+		{"payments", http.MethodGet, "/aggregates/alice/events", "", http.StatusForbidden},
+		{"payments", http.MethodGet, "/events/stream?aggregate_type=account", "", http.StatusForbidden},
+		{"reader", http.MethodGet, "/events/acct", "", http.StatusOK},
+		{"admin", http.MethodGet, "/aggregates/alice/events", "", http.StatusOK},
+	} {
+		if rec := send(tt.caller, tt.method, tt.url, tt.body); rec.Code != tt.want {
+			t.Errorf("%s %s %s: got %d %s, want %d", tt.caller, tt.method, tt.url, rec.Code, rec.Body, tt.want)
+		}
+	}
+	if rec := send("", http.MethodGet, "/events", ""); rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("401 without WWW-Authenticate")
+	}
+
+	rec := send("payments", http.MethodPost, "/events", payment)
+	var body response
+	var event model.Event
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &body) != nil || json.Unmarshal(body.Data, &event) != nil {
+		t.Fatalf("payment: %d %s", rec.Code, rec.Body)
+	}
+	if event.Metadata[auth.MetadataPrincipal] != "apikey:payments" || event.Metadata[auth.MetadataMethod] != auth.MethodAPIKey {
+		t.Errorf("metadata %v", event.Metadata)
+	}
+}
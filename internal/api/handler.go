@@ -1,29 +1,258 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/auth"
 	"defi/internal/eventstore"
 	"defi/internal/model"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 )
 
-var es *eventstore.BaseEventStore
+// Limits on events posted to POST /events.
+const (
+	MaxEventBytes          = 256 << 10
+	MaxIDLength            = 128
+	MaxMetadataEntries     = 32
+	MaxMetadataKeyLength   = 64
+	MaxMetadataValueLength = 1024
+)
+
+// appendAttempts is how many times POST /events tries to append while
+// other writers take the aggregate's next version.
+const appendAttempts = 3
+
+// Store is what the API needs of the event store.
+type Store interface {
+	AppendEvents(ctx context.Context, aggregateID string, expectedVersion int64, events []model.Event) error
+	GetEvent(id string) (model.Event, error)
+	FindEvents(q eventstore.EventQuery) (eventstore.EventPage, error)
+	LoadEvents(ctx context.Context, aggregateID string, afterVersion int64) ([]model.Event, error)
+}
+
+var es Store
 
-func InitEventStore(store *eventstore.BaseEventStore) {
+func InitEventStore(store Store) {
 	es = store
 }
 
+// eventRequest is the body of POST /events. The server assigns ID and
+// Timestamp; the other model.Event fields are accepted only to reject them
+// with a clear message.
+type eventRequest struct {
+	ID            string
+	AggregateID   string
+	AggregateType string
+	Type          string
+	Version       int64
+	Timestamp     int64
+	Position      int64
+	// Data is the payload, or its JSON encoding as a string, the form
+	// events are returned in.
+	Data     json.RawMessage
+	Metadata map[string]string
+}
+
+// handleEvents serves POST /events. The aggregate and event types must
+// have a registered schema the data is valid against, and the aggregate type must not be one
+// written by commands. The event is appended at the aggregate's next
+// version, if the aggregate is of the same type. It responds 201 with the stored event, or 422
+// listing every violation.
 func handleEvents(w http.ResponseWriter, r *http.Request) {
-	var event model.Event
-	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req eventRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxEventBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&req)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must hold a single JSON object")
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, bodyTooLarge())
+		return
+	}
+	if err != nil {
+		writeError(w, r, badRequest(fmt.Sprintf("invalid event: %v", err)))
 		return
 	}
 
-	if err := es.SaveEvent(event); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := allowed(r, auth.Write, req.AggregateType); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	event, violations := req.event()
+	if len(violations) > 0 {
+		writeError(w, r, invalid(violations))
 		return
 	}
+	// Record who wrote the event; see authorize.
+	for key, value := range aggregate.MetadataFrom(r.Context()) {
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]string)
+		}
+		event.Metadata[key] = value
+	}
+	// Appending writes the outbox row that publishes the event to the bus
+	// and to streaming clients.
+	events := []model.Event{event}
+	err = es.AppendEvents(r.Context(), event.AggregateID, eventstore.NextVersion, events)
+	for attempt := 1; errors.Is(err, eventstore.ErrConcurrencyConflict) && attempt < appendAttempts; attempt++ {
+		err = es.AppendEvents(r.Context(), event.AggregateID, eventstore.NextVersion, events)
+	}
+	if errors.Is(err, eventstore.ErrConcurrencyConflict) {
+		err = &Error{Status: http.StatusConflict, Code: CodeConflict, Message: fmt.Sprintf("%s is being written concurrently, try again", event.AggregateID)}
+	}
+	if errors.Is(err, eventstore.ErrAggregateTypeMismatch) {
+		err = invalid([]Violation{{Field: "AggregateType", Message: fmt.Sprintf("%s is an aggregate of another type", event.AggregateID)}})
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	event = events[0]
+	w.Header().Set("Location", "/events/"+event.ID)
+	writeJSON(w, http.StatusCreated, envelope{Data: event})
+}
+
+func bodyTooLarge() *Error {
+	return &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeTooLarge, Message: fmt.Sprintf("event must be at most %d bytes", MaxEventBytes)}
+}
+
+// event validates req and returns the event to store.
+func (req eventRequest) event() (model.Event, []Violation) {
+	var violations []Violation
+	violate := func(field, format string, args ...interface{}) {
+		violations = append(violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
 
-	w.WriteHeader(http.StatusCreated)
+	for field, set := range map[string]bool{"ID": req.ID != "", "Version": req.Version != 0, "Timestamp": req.Timestamp != 0, "Position": req.Position != 0} {
+		if set {
+			violate(field, "is assigned by the server")
+		}
+	}
+	for _, f := range []struct{ field, value string }{{"AggregateID", req.AggregateID}, {"AggregateType", req.AggregateType}, {"Type", req.Type}} {
+		if message := checkIdentifier(f.value); message != "" {
+			violate(f.field, "%s", message)
+		}
+	}
+	if hasState(req.AggregateType) {
+		violate("AggregateType", "%s events are written by commands", req.AggregateType)
+	}
+
+	if len(req.Metadata) > MaxMetadataEntries {
+		violate("Metadata", "must have at most %d entries", MaxMetadataEntries)
+	}
+	for key, value := range req.Metadata {
+		switch {
+		case key == "" || len(key) > MaxMetadataKeyLength || strings.IndexFunc(key, unicode.IsControl) >= 0:
+			violate("Metadata", "key %q must be 1 to %d printable characters", key, MaxMetadataKeyLength)
+		case model.IsServerMetadata(key):
+			violate("Metadata/"+key, "is set by the server")
+		case len(value) > MaxMetadataValueLength:
+			violate("Metadata/"+key, "must be at most %d bytes", MaxMetadataValueLength)
+		}
+	}
+
+	data, payload, err := decodeData(req.Data)
+	if err != nil {
+		violate("Data", "%v", err)
+	} else if req.AggregateType != "" && req.Type != "" {
+		dataViolations, known := validateData(req.AggregateType, req.Type, data)
+		if !known {
+			violate("Type", "unknown event type %q for aggregate type %q", req.Type, req.AggregateType)
+		}
+		violations = append(violations, dataViolations...)
+		violations = append(violations, amountViolations(data, "Data")...)
+	}
+
+	// Map iteration is random; sort so responses are stable.
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	return model.Event{
+		ID:            model.NewID(),
+		AggregateID:   req.AggregateID,
+		AggregateType: req.AggregateType,
+		Type:          req.Type,
+		Data:          payload,
+		Metadata:      req.Metadata,
+		Timestamp:     time.Now().UnixMilli(),
+	}, violations
+}
+
+// pointerEscaper escapes a key for use in a JSON pointer.
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// amountViolations checks that the amount of every object in data naming a
+// registered asset, such as a ledger leg, fits the asset's decimals. field
+// is the JSON pointer of data.
+func amountViolations(data interface{}, field string) []Violation {
+	var violations []Violation
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if asset, ok := v["asset"].(string); ok {
+			if _, registered := model.AssetDecimals(asset); registered && v["amount"] != nil {
+				text, _ := v["amount"].(string)
+				if number, ok := v["amount"].(json.Number); ok {
+					text = number.String()
+				}
+				if _, err := model.ParseAssetAmount(asset, text); err != nil {
+					violations = append(violations, Violation{Field: field + "/amount", Message: err.Error()})
+				}
+			}
+		}
+		for key, child := range v {
+			violations = append(violations, amountViolations(child, field+"/"+pointerEscaper.Replace(key))...)
+		}
+	case []interface{}:
+		for i, child := range v {
+			violations = append(violations, amountViolations(child, fmt.Sprintf("%s/%d", field, i))...)
+		}
+	}
+	return violations
+}
+
+func checkIdentifier(value string) string {
+	switch {
+	case value == "":
+		return "is required"
+	case len(value) > MaxIDLength:
+		return fmt.Sprintf("must be at most %d bytes", MaxIDLength)
+	case strings.IndexFunc(value, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0:
+		return "must not contain spaces or control characters"
+	}
+	return ""
+}
+
+// decodeData returns the payload in raw decoded for validation, and
+// compacted for storage.
+func decodeData(raw json.RawMessage) (interface{}, string, error) {
+	if len(raw) > 0 && raw[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, "", err
+		}
+		raw = json.RawMessage(encoded)
+	}
+	if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, "", errors.New("is required")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var data interface{}
+	if err := dec.Decode(&data); err != nil || dec.Decode(&struct{}{}) != io.EOF {
+		return nil, "", errors.New("must be JSON")
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return nil, "", errors.New("must be JSON")
+	}
+	return data, compact.String(), nil
 }
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"defi/internal/health"
+	"net/http"
+)
+
+var checks *health.Registry
+
+// InitHealth makes GET /readyz run the checks registered with registry.
+func InitHealth(registry *health.Registry) {
+	checks = registry
+}
+
+// handleLiveness serves GET /healthz. It depends on nothing but the process
+// serving requests, so a failing dependency never gets it restarted.
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, envelope{Data: health.Report{Status: health.StatusUp, Checks: []health.Result{}}})
+}
+
+// handleReadiness serves GET /readyz with the result of every check. It
+// responds 503 only when a critical dependency is down; a degraded service
+// still takes traffic.
+func handleReadiness(w http.ResponseWriter, r *http.Request) {
+	report := health.Report{Status: health.StatusUp, Checks: []health.Result{}}
+	if checks != nil {
+		report = checks.Check(r.Context())
+	}
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, envelope{Data: report})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"defi/internal/auth"
+	"defi/internal/config"
+	"defi/internal/health"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	// Probes are answered without credentials even when auth is on.
+	authenticator, err := auth.New(config.AuthConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitAuth(authenticator)
+	defer InitAuth(nil)
+	var cacheErr, dbErr error
+	registry := health.NewRegistry()
+	registry.CacheTTL = time.Nanosecond
+	registry.Register(
+		health.Check{Name: "database", Critical: true, Run: func(context.Context) error { return dbErr }},
+		health.Check{Name: "redis", Run: func(context.Context) error { return cacheErr }},
+	)
+	InitHealth(registry)
+	defer InitHealth(nil)
+	router := NewRouter()
+
+	probe := func(path string) (int, health.Report) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var body struct{ Data health.Report }
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return rec.Code, body.Data
+	}
+
+	for _, tt := range []struct {
+		name          string
+		cache, db     error
+		status        int
+		report        string
+		failingChecks int
+	}{
+		{"up", nil, nil, http.StatusOK, health.StatusUp, 0},
+		{"degraded", errors.New("redis down"), nil, http.StatusOK, health.StatusDegraded, 1},
+		{"down", nil, errors.New("mysql down"), http.StatusServiceUnavailable, health.StatusDown, 1},
+	} {
+		cacheErr, dbErr = tt.cache, tt.db
+		status, report := probe("/readyz")
+		failing := 0
+		for _, check := range report.Checks {
+			if check.Status != health.StatusUp {
+				failing++
+			}
+		}
+		if status != tt.status || report.Status != tt.report || len(report.Checks) != 2 || failing != tt.failingChecks {
+			t.Errorf("%s: readyz = %d %+v", tt.name, status, report)
+		}
+		if status, report := probe("/healthz"); status != http.StatusOK || report.Status != health.StatusUp {
+			t.Errorf("%s: healthz = %d %+v", tt.name, status, report)
+		}
+	}
+}
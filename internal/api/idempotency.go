@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"defi/internal/auth"
+	"defi/internal/idempotency"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// IdempotencyHeader carries the client's key for a write.
+	IdempotencyHeader = "Idempotency-Key"
+	// MaxIdempotencyKeyLength bounds the keys clients may send.
+	MaxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are the response headers kept to replay a response.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+var (
+	idempotencyKeys      idempotency.Store
+	idempotencyRetention time.Duration
+)
+
+// InitIdempotency makes writes with an Idempotency-Key header idempotent:
+// for retain, or idempotency.DefaultRetention if zero, a repeated request
+// gets the original response instead of being handled again.
+func InitIdempotency(store idempotency.Store, retain time.Duration) {
+	if retain <= 0 {
+		retain = idempotency.DefaultRetention
+	}
+	idempotencyKeys, idempotencyRetention = store, retain
+}
+
+// recorder captures a response while writing it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent wraps a write handler. The first request with a key is handled
+// and its response is stored; repeats of it get that response with
+// Idempotent-Replayed set. Responses that invite a retry, a 5xx, 408, 409 or
+// 429, or a panic release the key instead. A repeat while the first is
+// still running answers 409, and reusing a key for a different body 422.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if idempotencyKeys == nil || key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > MaxIdempotencyKeyLength {
+			writeError(w, r, badRequest(fmt.Sprintf("%s must be at most %d characters", IdempotencyHeader, MaxIdempotencyKeyLength)))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxEventBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, bodyTooLarge())
+			return
+		}
+		if err != nil {
+			writeError(w, r, badRequest("failed to read the request body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are scoped to the caller, so callers cannot see each other's
+		// responses by guessing keys.
+		caller := ""
+		if p := auth.FromContext(r.Context()); p != nil {
+			caller = p.ID
+		}
+		scope := sha256.Sum256([]byte(caller + "\n" + r.Method + " " + r.URL.Path + "\n" + key))
+		id := hex.EncodeToString(scope[:])
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+		claim, locked, err := idempotencyKeys.Lock(r.Context(), id, fingerprint, idempotency.DefaultLockTimeout)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !locked {
+			replay(w, r, claim, fingerprint)
+			return
+		}
+
+		handled := false
+		defer func() {
+			if handled {
+				return
+			}
+			// Let the client retry a request that failed or panicked.
+			if err := idempotencyKeys.Unlock(context.Background(), id, claim.Token); err != nil {
+				log.Printf("Failed to release %s %q: %v", IdempotencyHeader, key, err)
+			}
+		}()
+		rec := &recorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 || retryable(rec.status) {
+			return
+		}
+		// From here the request took effect, so the key must not be released
+		// for a retry to repeat it, even if its response cannot be saved.
+		handled = true
+		record := idempotency.Record{Fingerprint: fingerprint, Token: claim.Token, Status: rec.status, Header: make(http.Header), Body: rec.body.Bytes()}
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				record.Header.Set(name, value)
+			}
+		}
+		if err := idempotencyKeys.Save(context.Background(), id, record, idempotencyRetention); err != nil {
+			log.Printf("Failed to save the response to %s %q, which stays locked until it expires: %v", IdempotencyHeader, key, err)
+		}
+	}
+}
+
+// retryable reports whether a response with status tells the client to try
+// again, so the key must not pin it.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= http.StatusInternalServerError
+}
+
+func replay(w http.ResponseWriter, r *http.Request, record idempotency.Record, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		writeError(w, r, &Error{Status: http.StatusUnprocessableEntity, Code: CodeKeyReused, Message: fmt.Sprintf("%s was already used for a different request", IdempotencyHeader)})
+	case !record.Done():
+		w.Header().Set("Retry-After", "1")
+		writeError(w, r, &Error{Status: http.StatusConflict, Code: CodeConflict, Message: fmt.Sprintf("a request with this %s is in progress", IdempotencyHeader)})
+	default:
+		for name, values := range record.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Status)
+		w.Write(record.Body)
+	}
+}
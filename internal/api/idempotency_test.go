@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"defi/internal/eventstore"
+	"defi/internal/idempotency"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsaved is a key store that fails to save responses.
+type unsaved struct{ *idempotency.MemoryStore }
+
+func (unsaved) Save(ctx context.Context, key string, record idempotency.Record, retain time.Duration) error {
+	return errors.New("store unavailable")
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	if err := RegisterEventSchema("payment", "Paid", []byte(`{"type": "object"}`)); err != nil {
+		t.Fatal(err)
+	}
+	store := eventstore.NewMemoryEventStore()
+	InitEventStore(store)
+	keys := idempotency.NewMemoryStore()
+	InitIdempotency(keys, 0)
+	defer InitIdempotency(nil, 0)
+	router := NewRouter()
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		req.Header.Set(IdempotencyHeader, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	payment := `{"AggregateID": "p1", "AggregateType": "payment", "Type": "Paid", "Data": {}}`
+
+	first := send("k1", payment)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request: %d %s", first.Code, first.Body)
+	}
+	again := send("k1", payment)
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() ||
+		again.Header().Get("Location") != first.Header().Get("Location") || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("repeat: %d %s %v", again.Code, again.Body, again.Header())
+	}
+	if page, _ := store.FindEvents(eventstore.EventQuery{}); len(page.Events) != 1 {
+		t.Errorf("stored %d events, want 1", len(page.Events))
+	}
+
+	if rec := send("k1", strings.Replace(payment, "p1", "p2", 1)); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), CodeKeyReused) {
+		t.Errorf("reused key: %d %s", rec.Code, rec.Body)
+	}
+	// Rejected requests are replayed too, rather than handled again.
+	if rec := send("k2", `{}`); rec.Code != http.StatusUnprocessableEntity || send("k2", `{}`).Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("invalid request: %d %s", rec.Code, rec.Body)
+	}
+
+	// A request holding the key blocks its repeats, and releases it when it
+	// fails.
+	busy := "k3"
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(payment))
+	req.Header.Set(IdempotencyHeader, busy)
+	var status int
+	blocked := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		rec := send(busy, payment)
+		status = rec.Code
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	blocked(httptest.NewRecorder(), req)
+	if status != http.StatusConflict {
+		t.Errorf("concurrent repeat: got %d, want 409", status)
+	}
+	if rec := send(busy, payment); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry after failure: %d %v", rec.Code, rec.Header())
+	}
+	// So do responses that ask the client to retry, such as 409 and 429.
+	for _, code := range []int{http.StatusConflict, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(payment))
+		req.Header.Set(IdempotencyHeader, "k5")
+		idempotent(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(code) })(httptest.NewRecorder(), req)
+	}
+	if rec := send("k5", payment); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry after 409 and 429: %d %v", rec.Code, rec.Header())
+	}
+
+	// A request that took effect keeps its key locked even if its response
+	// cannot be saved, so a retry cannot repeat it.
+	InitIdempotency(unsaved{keys}, 0)
+	if rec := send("k4", payment); rec.Code != http.StatusCreated {
+		t.Fatalf("first request: %d %s", rec.Code, rec.Body)
+	}
+	if rec := send("k4", payment); rec.Code != http.StatusConflict {
+		t.Errorf("retry after a lost response: got %d, want 409", rec.Code)
+	}
+	if page, _ := store.FindEvents(eventstore.EventQuery{}); len(page.Events) != 4 {
+		t.Errorf("stored %d events, want 4", len(page.Events))
+	}
+}
+
+func TestUnlockNeedsTheClaim(t *testing.T) {
+	ctx := context.Background()
+	keys := idempotency.NewMemoryStore()
+	claim, locked, err := keys.Lock(ctx, "k", "f", time.Minute)
+	if err != nil || !locked {
+		t.Fatalf("lock: %v %v", locked, err)
+	}
+	keys.Unlock(ctx, "k", "stale")
+	if _, locked, _ := keys.Lock(ctx, "k", "f", time.Minute); locked {
+		t.Fatal("a stale token released the claim")
+	}
+	if err := keys.Save(ctx, "k", idempotency.Record{Fingerprint: "f", Token: "stale", Status: http.StatusCreated}, time.Minute); !errors.Is(err, idempotency.ErrClaimLost) {
+		t.Errorf("saving with a stale token: got %v", err)
+	}
+	if err := keys.Save(ctx, "k", idempotency.Record{Fingerprint: "f", Token: claim.Token, Status: http.StatusCreated}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	keys.Unlock(ctx, "k", claim.Token)
+	if record, locked, _ := keys.Lock(ctx, "k", "f", time.Minute); locked || !record.Done() {
+		t.Error("unlocking released a completed key")
+	}
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/auth"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metadataParam prefixes query parameters that filter on event metadata,
+// e.g. metadata.saga_id=s1.
+const metadataParam = "metadata."
+
+var (
+	snapshots aggregate.Snapshots
+	statesMu  sync.RWMutex
+	states    = make(map[string]func() aggregate.State)
+)
+
+// RegisterState makes GET /aggregates/{id}/state replay aggregates of
+// aggregateType into the state returned by newState. Their events are
+// written by commands, so POST /events rejects them.
+func RegisterState(aggregateType string, newState func() aggregate.State) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	states[aggregateType] = newState
+}
+
+// hasState reports whether aggregateType has a registered state.
+func hasState(aggregateType string) bool {
+	statesMu.RLock()
+	defer statesMu.RUnlock()
+	return states[aggregateType] != nil
+}
+
+// InitSnapshots makes GET /aggregates/{id}/state start from, and fill, the
+// state cache. Replays then read the primary rather than a replica.
+func InitSnapshots(s aggregate.Snapshots) {
+	snapshots = s
+}
+
+// AggregateState is the data of GET /aggregates/{id}/state.
+type AggregateState struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Version int64           `json:"version"`
+	State   aggregate.State `json:"state"`
+}
+
+// handleFindEvents serves GET /events. Filters: aggregate_id,
+// aggregate_type, type (repeatable or comma separated), since and until in
+// Unix milliseconds, metadata.<key>; paging: cursor, limit and order.
+func handleFindEvents(w http.ResponseWriter, r *http.Request) {
+	q, err := parseEventQuery(r.URL.Query())
+	if err == nil {
+		err = allowed(r, auth.Read, q.AggregateType)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page, err := findEvents(q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, envelope{Data: events(page.Events), Page: &pageInfo{NextCursor: page.NextCursor}})
+}
+
+// handleGetEvent serves GET /events/{id}.
+func handleGetEvent(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	event, err := es.GetEvent(id)
+	if errors.Is(err, eventstore.ErrEventNotFound) {
+		writeError(w, r, notFound(fmt.Sprintf("event %s not found", id)))
+		return
+	}
+	if err == nil {
+		err = allowed(r, auth.Read, event.AggregateType)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, envelope{Data: event})
+}
+
+// handleAggregateEvents serves GET /aggregates/{id}/events in version
+// order, paged like GET /events.
+func handleAggregateEvents(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	params := r.URL.Query()
+	q, err := parsePage(params)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q.AggregateID = id
+	page, err := findEvents(q)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(page.Events) == 0 && q.Cursor == "" {
+		writeError(w, r, notFound(fmt.Sprintf("aggregate %s not found", id)))
+		return
+	}
+	for _, event := range page.Events {
+		if err := allowed(r, auth.Read, event.AggregateType); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, envelope{Data: events(page.Events), Page: &pageInfo{NextCursor: page.NextCursor}})
+}
+
+// handleAggregateState serves GET /aggregates/{id}/state, replaying the
+// aggregate's events into the state registered for its type. With a state
+// cache, replay starts from the cached state and reads the primary.
+func handleAggregateState(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	result := AggregateState{ID: id}
+	apply := func(event model.Event) error {
+		if result.State == nil {
+			if err := result.init(r, event.AggregateType); err != nil {
+				return err
+			}
+		}
+		if err := result.State.Apply(event); err != nil {
+			return fmt.Errorf("failed to replay %s event %d of %s: %w", event.Type, event.Version, id, err)
+		}
+		result.Version = event.Version
+		return nil
+	}
+
+	var err error
+	if snapshots == nil {
+		_, err = eventstore.ForEach(r.Context(), es, eventstore.EventQuery{AggregateID: id}, apply)
+	} else {
+		err = result.loadCached(r, apply)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if result.State == nil {
+		writeError(w, r, notFound(fmt.Sprintf("aggregate %s not found", id)))
+		return
+	}
+	writeJSON(w, http.StatusOK, envelope{Data: result})
+}
+
+// init checks the caller may read aggregates of aggregateType and sets State
+// to the initial state registered for it.
+func (a *AggregateState) init(r *http.Request, aggregateType string) error {
+	if err := allowed(r, auth.Read, aggregateType); err != nil {
+		return err
+	}
+	statesMu.RLock()
+	newState, ok := states[aggregateType]
+	statesMu.RUnlock()
+	if !ok {
+		return &Error{Status: http.StatusNotFound, Code: CodeNoProjection, Message: fmt.Sprintf("no state projection for aggregate type %q", aggregateType)}
+	}
+	a.Type, a.State = aggregateType, newState()
+	return nil
+}
+
+// loadCached restores the cached state of the aggregate, applies the events
+// after it from the primary, and caches the result.
+func (a *AggregateState) loadCached(r *http.Request, apply func(model.Event) error) error {
+	ctx := r.Context()
+	if snap, ok := snapshots.Get(ctx, a.ID); ok {
+		if err := a.init(r, snap.Type); err != nil {
+			return err
+		}
+		if err := aggregate.Restore(snap, a.State); err != nil {
+			log.Printf("Discarding cached state of %s: %v", a.ID, err)
+			a.Type, a.State = "", nil
+		} else {
+			a.Version = snap.Version
+		}
+	}
+	events, err := es.LoadEvents(ctx, a.ID, a.Version)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := apply(event); err != nil {
+			return err
+		}
+	}
+	if len(events) > 0 {
+		aggregate.TakeSnapshot(ctx, snapshots, a.ID, a.Type, a.Version, a.State)
+	}
+	return nil
+}
+
+func findEvents(q eventstore.EventQuery) (eventstore.EventPage, error) {
+	page, err := es.FindEvents(q)
+	if errors.Is(err, eventstore.ErrInvalidCursor) {
+		return page, badRequest("invalid cursor")
+	}
+	return page, err
+}
+
+func parseEventQuery(params url.Values) (eventstore.EventQuery, error) {
+	q, err := parsePage(params)
+	if err != nil {
+		return q, err
+	}
+	q.AggregateID = params.Get("aggregate_id")
+	q.AggregateType = params.Get("aggregate_type")
+	q.Types = parseTypes(params)
+	if q.Since, err = parseInt(params, "since"); err != nil {
+		return q, err
+	}
+	if q.Until, err = parseInt(params, "until"); err != nil {
+		return q, err
+	}
+	for key, values := range params {
+		if !strings.HasPrefix(key, metadataParam) {
+			continue
+		}
+		name := strings.TrimPrefix(key, metadataParam)
+		if name == "" || len(values) != 1 {
+			return q, badRequest(fmt.Sprintf("%s must name one key and have one value", key))
+		}
+		if q.Metadata == nil {
+			q.Metadata = make(map[string]string)
+		}
+		q.Metadata[name] = values[0]
+	}
+	return q, nil
+}
+
+// parseTypes reads the type parameter, which may be repeated or comma
+// separated.
+func parseTypes(params url.Values) []string {
+	var types []string
+	for _, value := range params["type"] {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+	}
+	return types
+}
+
+func parsePage(params url.Values) (eventstore.EventQuery, error) {
+	q := eventstore.EventQuery{Cursor: params.Get("cursor")}
+	limit, err := parseInt(params, "limit")
+	if err != nil {
+		return q, err
+	}
+	if limit < 0 || limit > eventstore.MaxQueryLimit {
+		return q, badRequest(fmt.Sprintf("limit must be at most %d", eventstore.MaxQueryLimit))
+	}
+	q.Limit = int(limit)
+	switch order := eventstore.Order(params.Get("order")); order {
+	case "", eventstore.OrderAsc, eventstore.OrderDesc:
+		q.Order = order
+	default:
+		return q, badRequest("order must be asc or desc")
+	}
+	return q, nil
+}
+
+func parseInt(params url.Values, name string) (int64, error) {
+	value := params.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, badRequest(fmt.Sprintf("%s must be a non-negative integer", name))
+	}
+	return n, nil
+}
+
+// events keeps empty lists as [] rather than null in responses.
+func events(list []model.Event) []model.Event {
+	if list == nil {
+		return []model.Event{}
+	}
+	return list
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"defi/internal/auth"
+	"defi/internal/config"
+	"defi/internal/ratelimit"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	limiter        ratelimit.Limiter
+	limits         config.RateLimitConfig
+	trustedProxies []*net.IPNet
+)
+
+// InitRateLimit limits how often each caller and each client IP may call
+// the API, as cfg says, counting with l.
+func InitRateLimit(l ratelimit.Limiter, cfg config.RateLimitConfig) error {
+	var proxies []*net.IPNet
+	for _, cidr := range cfg.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		proxies = append(proxies, network)
+	}
+	limiter, limits, trustedProxies = l, cfg, proxies
+	return nil
+}
+
+func limitsFor(action string) config.RateLimits {
+	if action == auth.Write {
+		return limits.Write
+	}
+	return limits.Read
+}
+
+// limitByIP applies the per-IP limit of action. It runs before
+// authentication, so floods are turned away before they cost anything.
+func limitByIP(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter != nil && !takeToken(w, r, action+":ip:"+clientIP(r), limitsFor(action).PerIP) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limitByCaller applies the per-caller limit of action to authenticated
+// requests.
+func limitByCaller(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := auth.FromContext(r.Context())
+		if limiter != nil && p != nil && !takeToken(w, r, action+":caller:"+p.ID, limitsFor(action).PerCaller) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// takeToken takes a token from the bucket of key, sets the RateLimit
+// headers of the most restrictive bucket seen so far, and answers 429 if
+// the bucket is empty. If the limiter fails the request is let through.
+func takeToken(w http.ResponseWriter, r *http.Request, key string, rate config.Rate) bool {
+	res, err := limiter.Allow(r.Context(), key, ratelimit.Limit{PerMinute: rate.PerMinute, Burst: rate.Burst})
+	if err != nil {
+		log.Printf("Rate limiting %s failed: %v", key, err)
+		return true
+	}
+	if res.Limit == 0 {
+		return true
+	}
+	header := w.Header()
+	if remaining, err := strconv.Atoi(header.Get("RateLimit-Remaining")); err != nil || res.Remaining < remaining {
+		header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		header.Set("RateLimit-Reset", seconds(res.Reset))
+	}
+	if res.Allowed {
+		return true
+	}
+	header.Set("Retry-After", seconds(res.RetryAfter))
+	writeError(w, r, &Error{Status: http.StatusTooManyRequests, Code: CodeRateLimited, Message: fmt.Sprintf("rate limit exceeded, retry in %s seconds", seconds(res.RetryAfter))})
+	return false
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// clientIP returns the IP of the client of r: the peer, or if the peer is a
+// trusted proxy, the last address in X-Forwarded-For it did not add itself.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !trusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func trusted(host string) bool {
+	ip := net.ParseIP(host)
+	for _, network := range trustedProxies {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"defi/internal/config"
+	"defi/internal/eventstore"
+	"defi/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	InitEventStore(eventstore.NewMemoryEventStore())
+	err := InitRateLimit(ratelimit.NewMemoryLimiter(), config.RateLimitConfig{
+		Read:           config.RateLimits{PerIP: config.Rate{PerMinute: 60, Burst: 2}},
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer InitRateLimit(nil, config.RateLimitConfig{})
+	router := NewRouter()
+
+	get := func(remote, forwarded string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/events", nil)
+		r.RemoteAddr = remote + ":4000"
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+
+	for i, want := range []string{"1", "0"} {
+		rec := get("10.0.0.1", "203.0.113.9, 10.0.0.2")
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "2" || rec.Header().Get("RateLimit-Remaining") != want {
+			t.Fatalf("request %d: %d %v", i, rec.Code, rec.Header())
+		}
+	}
+	rec := get("10.0.0.3", "203.0.113.9")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("over the limit: %d %v", rec.Code, rec.Header())
+	}
+	// An untrusted peer cannot pick its IP with X-Forwarded-For.
+	if rec := get("198.51.100.7", "203.0.113.9"); rec.Code != http.StatusOK {
+		t.Errorf("other client: %d", rec.Code)
+	}
+	if rec := get("203.0.113.9", ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("same client without proxy: %d", rec.Code)
+	}
+}
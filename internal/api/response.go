@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Error codes of failed responses.
+const (
+	CodeBadRequest      = "bad_request"
+	CodeUnauthenticated = "unauthenticated"
+	CodeForbidden       = "forbidden"
+	CodeInvalid         = "invalid"
+	CodeTooLarge        = "too_large"
+	CodeConflict        = "conflict"
+	CodeRateLimited     = "rate_limited"
+	CodeKeyReused       = "idempotency_key_reused"
+	CodeNotFound        = "not_found"
+	CodeNoProjection    = "no_projection"
+	CodeUnavailable     = "unavailable"
+	CodeInternal        = "internal"
+)
+
+// envelope is the body of every response: data, plus page details for
+// lists, on success and error otherwise.
+type envelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Page  *pageInfo   `json:"page,omitempty"`
+	Error *Error      `json:"error,omitempty"`
+}
+
+type pageInfo struct {
+	// NextCursor fetches the following page; it is empty on the last one.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Error describes why a request failed.
+type Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Violations lists every problem with an invalid request.
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// Violation is one problem with a field of a request. Fields inside event
+// data are named by JSON pointer, e.g. Data/amount.
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func badRequest(message string) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeBadRequest, Message: message}
+}
+
+func invalid(violations []Violation) *Error {
+	return &Error{Status: http.StatusUnprocessableEntity, Code: CodeInvalid, Message: "request is invalid", Violations: violations}
+}
+
+func notFound(message string) *Error {
+	return &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: message}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body envelope) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// writeError responds with err if it is an *Error, and otherwise logs it
+// and responds with a generic internal error, so store details stay out of
+// responses.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		log.Printf("%s %s failed: %v", r.Method, r.URL.Path, err)
+		apiErr = &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "internal error"}
+	}
+	writeJSON(w, apiErr.Status, envelope{Error: apiErr})
+}
@@ -1,11 +1,29 @@
 package api
 
 import (
+	"defi/internal/auth"
 	"github.com/gorilla/mux"
+	"net/http"
 )
 
 func NewRouter() *mux.Router {
 	router := mux.NewRouter()
-	router.HandleFunc("/events", handleEvents).Methods("POST")
+	router.HandleFunc("/events", endpoint(auth.Write, idempotent(handleEvents))).Methods("POST")
+	router.HandleFunc("/events", endpoint(auth.Read, handleFindEvents)).Methods("GET")
+	// Register the streams before /events/{id}, which would match them.
+	router.HandleFunc("/events/stream", endpoint(auth.Read, handleSSE)).Methods("GET")
+	router.HandleFunc("/events/ws", endpoint(auth.Read, handleWebSocket)).Methods("GET")
+	router.HandleFunc("/events/{id}", endpoint(auth.Read, handleGetEvent)).Methods("GET")
+	router.HandleFunc("/aggregates/{id}/events", endpoint(auth.Read, handleAggregateEvents)).Methods("GET")
+	router.HandleFunc("/aggregates/{id}/state", endpoint(auth.Read, handleAggregateState)).Methods("GET")
+	// Probes come from the orchestrator, which has no credentials.
+	router.HandleFunc("/healthz", handleLiveness).Methods("GET")
+	router.HandleFunc("/readyz", handleReadiness).Methods("GET")
 	return router
 }
+
+// endpoint wraps h in the middleware of endpoints that perform action: the
+// per-IP rate limit, authorization, then the per-caller rate limit.
+func endpoint(action string, h http.HandlerFunc) http.HandlerFunc {
+	return limitByIP(action, authorize(action, limitByCaller(action, h)))
+}
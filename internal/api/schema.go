@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	schemasMu sync.RWMutex
+	schemas   = make(map[schemaKey]*jsonschema.Schema)
+)
+
+// schemaKey names the events a schema applies to. The same event type may
+// carry different data in different aggregate types.
+type schemaKey struct {
+	aggregateType string
+	eventType     string
+}
+
+// RegisterEventSchema allows events of eventType on aggregates of
+// aggregateType to be posted to POST /events, with data valid against the
+// JSON Schema schema. Events without a schema are rejected.
+func RegisterEventSchema(aggregateType, eventType string, schema []byte) error {
+	if aggregateType == "" || eventType == "" {
+		return errors.New("aggregate type and event type are required")
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+	// Schemas must be self-contained; nothing is fetched while compiling.
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("schema references %s, which is not loaded", url)
+	}
+	url := "events/" + aggregateType + "/" + eventType + ".json"
+	if err := compiler.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return fmt.Errorf("invalid schema for %s %s: %w", aggregateType, eventType, err)
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return fmt.Errorf("invalid schema for %s %s: %w", aggregateType, eventType, err)
+	}
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+	schemas[schemaKey{aggregateType, eventType}] = compiled
+	return nil
+}
+
+// LoadEventSchemas registers every <aggregate type>/<event type>.json file
+// in dir.
+func LoadEventSchemas(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		schema, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read event schema: %w", err)
+		}
+		aggregateType := filepath.Base(filepath.Dir(file))
+		if err := RegisterEventSchema(aggregateType, strings.TrimSuffix(filepath.Base(file), ".json"), schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateData checks data against the schema of eventType on
+// aggregateType. It returns false if no schema is registered for them.
+func validateData(aggregateType, eventType string, data interface{}) ([]Violation, bool) {
+	schemasMu.RLock()
+	schema, ok := schemas[schemaKey{aggregateType, eventType}]
+	schemasMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	err := schema.Validate(data)
+	if err == nil {
+		return nil, true
+	}
+	var invalid *jsonschema.ValidationError
+	if !errors.As(err, &invalid) {
+		// The schema could not be applied, e.g. it recurses without end;
+		// data it cannot vouch for is rejected.
+		return []Violation{{Field: "Data", Message: fmt.Sprintf("cannot be validated: %v", err)}}, true
+	}
+	var violations []Violation
+	seen := make(map[Violation]bool)
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			v := Violation{Field: "Data" + e.InstanceLocation, Message: e.Message}
+			if !seen[v] {
+				seen[v] = true
+				violations = append(violations, v)
+			}
+			return
+		}
+		for _, cause := range e.Causes {
+			walk(cause)
+		}
+	}
+	walk(invalid)
+	return violations, true
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const depositedSchema = `{
+	"type": "object",
+	"required": ["account", "amount"],
+	"properties": {
+		"account": {"type": "string", "minLength": 1},
+		"amount": {"type": "string", "pattern": "^[0-9]+$"}
+	},
+	"additionalProperties": false
+}`
+
+func post(t *testing.T, router http.Handler, body string) (int, response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	var resp response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("POST /events: invalid body %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestPostEvents(t *testing.T) {
+	dir := t.TempDir()
+	for _, aggregateType := range []string{"deposit", "refund"} {
+		if err := os.MkdirAll(filepath.Join(dir, aggregateType), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, aggregateType, "Deposited.json"), []byte(depositedSchema), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := LoadEventSchemas(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterEventSchema("deposit", "Broken", []byte(`{"type": "nope"}`)); err == nil {
+		t.Error("expected an invalid schema to be rejected")
+	}
+	store := eventstore.NewMemoryEventStore()
+	InitEventStore(store)
+	router := NewRouter()
+
+	// Data may be the payload itself or its encoding as a string.
+	for _, data := range []string{`{"account": "alice", "amount": "5"}`, `"{\"account\":\"alice\",\"amount\":\"5\"}"`} {
+		code, body := post(t, router, `{"AggregateID": "alice", "AggregateType": "deposit", "Type": "Deposited", "Data": `+data+`}`)
+		var event model.Event
+		if code != http.StatusCreated || json.Unmarshal(body.Data, &event) != nil {
+			t.Fatalf("valid event: %d %+v", code, body)
+		}
+		stored, err := store.GetEvent(event.ID)
+		if err != nil || event.ID == "" || stored.Timestamp == 0 || stored.Data != `{"account":"alice","amount":"5"}` {
+			t.Errorf("stored %+v: %v", stored, err)
+		}
+	}
+
+	code, body := post(t, router, `{"ID": "mine", "AggregateType": "deposit", "Type": "Deposited", "Data": {"amount": "-5", "memo": "x"}}`)
+	if code != http.StatusUnprocessableEntity || body.Error == nil || body.Error.Code != CodeInvalid {
+		t.Fatalf("invalid event: %d %+v", code, body)
+	}
+	var fields []string
+	for _, v := range body.Error.Violations {
+		fields = append(fields, v.Field)
+	}
+	if got, want := strings.Join(fields, " "), "AggregateID Data Data Data/amount ID"; got != want {
+		t.Errorf("violations of %v, want %s", body.Error.Violations, want)
+	}
+
+	for request, want := range map[string]int{
+		`{"AggregateID": "a", "AggregateType": "t", "Type": "Withdrawn", "Data": {}}`:             http.StatusUnprocessableEntity,
+		`{"AggregateID": "a", "AggregateType": "t", "Type": "Deposited", "Data": {}}`:             http.StatusUnprocessableEntity,
+		`{"AggregateID": "a", "AggregateType": "t", "Type": "Deposited", "Data": "not json"}`:     http.StatusUnprocessableEntity,
+		`{"AggregateID": "a", "AggregateType": "t", "Type": "Deposited", "Data": {}, "Extra": 1}`: http.StatusBadRequest,
+		`{"AggregateID": "a"} {}`: http.StatusBadRequest,
+		`{"AggregateID": "` + strings.Repeat("a", MaxEventBytes) + `"}`: http.StatusRequestEntityTooLarge,
+	} {
+		if code, body := post(t, router, request); code != want || body.Error == nil {
+			t.Errorf("%.80s: got %d %+v, want %d", request, code, body.Error, want)
+		}
+	}
+
+	// Domain aggregates are written by commands, and a stream keeps its type.
+	RegisterState("vault", func() aggregate.State { return nil })
+	for _, request := range []string{
+		`{"AggregateID": "v1", "AggregateType": "vault", "Type": "Deposited", "Data": {"account": "a", "amount": "1"}}`,
+		`{"AggregateID": "alice", "AggregateType": "refund", "Type": "Deposited", "Data": {"account": "a", "amount": "1"}}`,
+	} {
+		code, body := post(t, router, request)
+		if code != http.StatusUnprocessableEntity || body.Error == nil || len(body.Error.Violations) == 0 || body.Error.Violations[0].Field != "AggregateType" {
+			t.Errorf("%s: got %d %+v", request, code, body.Error)
+		}
+	}
+
+	// Sagas and the scheduler trust their metadata, so clients cannot forge it.
+	for _, key := range []string{model.MetadataPrincipal, model.MetadataSagaID, model.MetadataSagaStep, model.MetadataScheduleOccurrence} {
+		code, body := post(t, router, `{"AggregateID": "a", "AggregateType": "deposit", "Type": "Deposited", "Data": {"account": "a", "amount": "1"}, "Metadata": {"`+key+`": "x"}}`)
+		if code != http.StatusUnprocessableEntity || body.Error == nil || len(body.Error.Violations) != 1 || body.Error.Violations[0].Field != "Metadata/"+key {
+			t.Errorf("metadata %s: got %d %+v", key, code, body.Error)
+		}
+	}
+}
+
+func TestAmountViolations(t *testing.T) {
+	model.RegisterAsset("ETH", 18)
+	data, _, err := decodeData(json.RawMessage(`{"legs": [{"asset": "ETH", "amount": "1.5"}, {"asset": "ETH", "amount": 0.0000000000000000001}], "asset": "XYZ", "amount": "1.234"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations := amountViolations(data, "Data")
+	if len(violations) != 1 || violations[0].Field != "Data/legs/1/amount" {
+		t.Errorf("violations = %+v, want one at Data/legs/1/amount", violations)
+	}
+}
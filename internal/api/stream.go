@@ -0,0 +1,379 @@
+package api
+
+import (
+	"context"
+	"defi/internal/auth"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultStreamBuffer is how many events a client may fall behind the
+	// event bus before it is disconnected.
+	DefaultStreamBuffer = 256
+	heartbeatInterval   = 15 * time.Second
+	writeWait           = 10 * time.Second
+)
+
+// errLagged ends the stream of a client that fell too far behind. It
+// reconnects with the last position it saw and catches up from the store.
+var errLagged = errors.New("client fell behind the event stream")
+
+// errSettled stops the scan of recent events at or below a resume position.
+var errSettled = errors.New("past the resume position")
+
+// Consumer is the subset of eventbus.EventBus the hub needs.
+type Consumer interface {
+	ConsumerEvent(topic string, handler func(event model.Event)) error
+}
+
+// Hub fans events published on the event bus out to streaming clients.
+// Each client has a bounded queue; one that lets it fill up is dropped
+// rather than slowing down the others or growing without bound.
+type Hub struct {
+	// Buffer is the queue length per client; zero means DefaultStreamBuffer.
+	Buffer int
+	// TopicOf returns the topic an event is published on, to filter events
+	// replayed from the store by topic.
+	TopicOf func(model.Event) string
+	// Log is where resuming clients catch up from. It should read the
+	// primary, since replicas may lag behind events the bus has delivered;
+	// nil means the API's store.
+	Log     eventstore.Finder
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+}
+
+type client struct {
+	filter streamFilter
+	events chan model.Event
+	lagged chan struct{}
+	once   sync.Once
+}
+
+type streamFilter struct {
+	AggregateID   string
+	AggregateType string
+	Types         map[string]bool
+	Topics        map[string]bool
+}
+
+func (f streamFilter) match(topic string, event model.Event) bool {
+	switch {
+	case f.AggregateID != "" && event.AggregateID != f.AggregateID:
+		return false
+	case f.AggregateType != "" && event.AggregateType != f.AggregateType:
+		return false
+	case len(f.Types) > 0 && !f.Types[event.Type]:
+		return false
+	case len(f.Topics) > 0 && !f.Topics[topic]:
+		return false
+	}
+	return true
+}
+
+// NewHub subscribes to topics on bus. The bus delivers the outbox payload,
+// an encoded model.Event, as the Data of the event it hands over.
+func NewHub(bus Consumer, topicOf func(model.Event) string, topics ...string) (*Hub, error) {
+	h := &Hub{TopicOf: topicOf, clients: make(map[*client]struct{})}
+	for _, topic := range topics {
+		topic := topic
+		err := bus.ConsumerEvent(topic, func(message model.Event) {
+			var event model.Event
+			if err := json.Unmarshal([]byte(message.Data), &event); err != nil {
+				log.Printf("Dropping undecodable event on %s: %v", topic, err)
+				return
+			}
+			h.Publish(topic, event)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+		}
+	}
+	return h, nil
+}
+
+// Publish delivers event to every client whose filter matches it.
+func (h *Hub) Publish(topic string, event model.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if !c.filter.match(topic, event) {
+			continue
+		}
+		select {
+		case c.events <- event:
+		default:
+			c.once.Do(func() { close(c.lagged) })
+		}
+	}
+}
+
+func (h *Hub) subscribe(filter streamFilter) *client {
+	size := h.Buffer
+	if size <= 0 {
+		size = DefaultStreamBuffer
+	}
+	c := &client{filter: filter, events: make(chan model.Event, size), lagged: make(chan struct{})}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+func (h *Hub) unsubscribe(c *client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+}
+
+var hub *Hub
+
+// InitStream enables the streaming endpoints.
+func InitStream(h *Hub) {
+	hub = h
+}
+
+// stream sends the events matching filter: first those stored after
+// position after, if resuming, then live ones. Positions commit out of
+// order, so a client that saw position after may have missed lower ones
+// that committed later; a resume therefore also sends the events at or
+// below after from the last SettleTime, and clients may see those twice.
+// Events already sent from the store are skipped by ID when they arrive
+// live.
+func (h *Hub) stream(ctx context.Context, filter streamFilter, after int64, resume bool, send func(model.Event) error, heartbeat func() error) error {
+	c := h.subscribe(filter)
+	defer h.unsubscribe(c)
+
+	sent := make(map[string]bool)
+	if resume {
+		q := eventstore.EventQuery{AggregateID: filter.AggregateID, AggregateType: filter.AggregateType}
+		for t := range filter.Types {
+			q.Types = append(q.Types, t)
+		}
+		var source eventstore.Finder = es
+		if h.Log != nil {
+			source = h.Log
+		}
+		replay := func(event model.Event) error {
+			if sent[event.ID] || len(filter.Topics) > 0 && (h.TopicOf == nil || !filter.Topics[h.TopicOf(event)]) {
+				return nil
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+			sent[event.ID] = true
+			return nil
+		}
+		unsettled := q
+		unsettled.Since = time.Now().Add(-eventstore.SettleTime).UnixMilli()
+		_, err := eventstore.ForEach(ctx, source, unsettled, func(event model.Event) error {
+			if event.Position > after {
+				return errSettled
+			}
+			return replay(event)
+		})
+		if err != nil && !errors.Is(err, errSettled) {
+			return err
+		}
+		q.Cursor = eventstore.EncodeCursor(after)
+		if _, err := eventstore.ForEach(ctx, source, q, replay); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.lagged:
+			return errLagged
+		case event := <-c.events:
+			if sent[event.ID] {
+				// The bus delivers each stored event once, barring retries.
+				delete(sent, event.ID)
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parseStream reads the filters of a stream request and the position to
+// resume after, from the after parameter or an SSE Last-Event-ID header.
+func parseStream(r *http.Request) (streamFilter, int64, bool, error) {
+	params := r.URL.Query()
+	filter := streamFilter{AggregateID: params.Get("aggregate_id"), AggregateType: params.Get("aggregate_type")}
+	for _, t := range parseTypes(params) {
+		if filter.Types == nil {
+			filter.Types = make(map[string]bool)
+		}
+		filter.Types[t] = true
+	}
+	for _, topic := range params["topic"] {
+		if filter.Topics == nil {
+			filter.Topics = make(map[string]bool)
+		}
+		filter.Topics[topic] = true
+	}
+
+	resume := params.Get("after")
+	if resume == "" {
+		resume = r.Header.Get("Last-Event-ID")
+	}
+	if resume == "" {
+		return filter, 0, false, nil
+	}
+	after, err := strconv.ParseInt(resume, 10, 64)
+	if err != nil || after < 0 {
+		return filter, 0, false, badRequest("after must be a non-negative event position")
+	}
+	return filter, after, true, nil
+}
+
+func streamUnavailable() *Error {
+	return &Error{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Message: "event streaming is not enabled"}
+}
+
+// handleSSE serves GET /events/stream as server-sent events. The SSE id is
+// the highest position sent so far, so browsers resume after it on
+// reconnect. A client that falls behind gets an overflow event and
+// is disconnected.
+func handleSSE(w http.ResponseWriter, r *http.Request) {
+	filter, after, resume, err := parseStream(r)
+	if err == nil {
+		err = allowed(r, auth.Read, filter.AggregateType)
+	}
+	if err == nil && hub == nil {
+		err = streamUnavailable()
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, errors.New("response writer does not support flushing"))
+		return
+	}
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	write := func(format string, args ...interface{}) error {
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	highest := after
+	send := func(event model.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		// Events below the highest position keep the previous id.
+		if event.Position > highest {
+			highest = event.Position
+			return write("id: %d\nevent: %s\ndata: %s\n\n", event.Position, event.Type, data)
+		}
+		return write("event: %s\ndata: %s\n\n", event.Type, data)
+	}
+	heartbeat := func() error { return write(": ping\n\n") }
+
+	err = hub.stream(r.Context(), filter, after, resume, send, heartbeat)
+	if errors.Is(err, errLagged) {
+		write("event: overflow\ndata: {}\n\n")
+		return
+	}
+	if err != nil {
+		log.Printf("Event stream to %s ended: %v", r.RemoteAddr, err)
+	}
+}
+
+// wsMessage is a WebSocket frame sent to clients.
+type wsMessage struct {
+	Type  string       `json:"type"`
+	Event *model.Event `json:"event,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{}
+
+// handleWebSocket serves GET /events/ws. Events are sent as text frames
+// {"type": "event", "event": {...}}; a client that falls behind gets
+// {"type": "overflow"} and is disconnected, and resumes with ?after= the
+// highest position it received.
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter, after, resume, err := parseStream(r)
+	if err == nil {
+		err = allowed(r, auth.Read, filter.AggregateType)
+	}
+	if err == nil && hub == nil {
+		err = streamUnavailable()
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already responded.
+		return
+	}
+	defer conn.Close()
+
+	// Clients only send control frames; reading handles them and notices
+	// when the connection goes away.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(msg wsMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteJSON(msg)
+	}
+	send := func(event model.Event) error { return write(wsMessage{Type: "event", Event: &event}) }
+	heartbeat := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+	}
+
+	err = hub.stream(ctx, filter, after, resume, send, heartbeat)
+	if errors.Is(err, errLagged) {
+		write(wsMessage{Type: "overflow"})
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "fell behind"), time.Now().Add(writeWait))
+		return
+	}
+	if err != nil {
+		log.Printf("Event stream to %s ended: %v", r.RemoteAddr, err)
+	}
+}
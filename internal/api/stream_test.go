@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeConsumer map[string]func(model.Event)
+
+func (f fakeConsumer) ConsumerEvent(topic string, handler func(model.Event)) error {
+	f[topic] = handler
+	return nil
+}
+
+// deliver hands event to the hub the way the event bus does, encoded as
+// the Data of the message.
+func (f fakeConsumer) deliver(topic string, event model.Event) {
+	payload, _ := json.Marshal(event)
+	f[topic](model.Event{Data: string(payload)})
+}
+
+func waitForClients(t *testing.T, h *Hub, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		h.mu.RLock()
+		got := len(h.clients)
+		h.mu.RUnlock()
+		if got == n {
+			return
+		}
+	}
+	t.Fatalf("expected %d stream clients", n)
+}
+
+func TestStreams(t *testing.T) {
+	store := eventstore.NewMemoryEventStore()
+	for _, id := range []string{"e1", "e2", "e3"} {
+		store.SaveEvent(model.Event{ID: id, AggregateID: "alice", AggregateType: "account", Type: "Posted", Data: "{}"})
+	}
+	bus := fakeConsumer{}
+	h, err := NewHub(bus, eventstore.TopicByAggregateType("events"), "events.account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitEventStore(store)
+	InitStream(h)
+	defer InitStream(nil)
+	server := httptest.NewServer(NewRouter())
+	defer server.Close()
+
+	// SSE resumes from the store, then skips live events it already sent.
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events/stream?aggregate_id=alice", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+	nextID := func() string {
+		t.Helper()
+		for lines.Scan() {
+			if id, ok := strings.CutPrefix(lines.Text(), "id: "); ok {
+				return id
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return ""
+	}
+	if a, b := nextID(), nextID(); a != "2" || b != "3" {
+		t.Fatalf("replayed ids %s, %s", a, b)
+	}
+	bus.deliver("events.account", model.Event{ID: "e3", AggregateID: "alice", AggregateType: "account", Type: "Posted", Position: 3})
+	bus.deliver("events.account", model.Event{ID: "e4", AggregateID: "bob", AggregateType: "account", Type: "Posted", Position: 4})
+	bus.deliver("events.account", model.Event{ID: "e5", AggregateID: "alice", AggregateType: "account", Type: "Posted", Position: 5})
+	if id := nextID(); id != "5" {
+		t.Errorf("live id %s, want 5", id)
+	}
+
+	// WebSocket clients get live events matching their filter.
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/events/ws?type=Closed"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitForClients(t, h, 2)
+	bus.deliver("events.account", model.Event{ID: "e6", AggregateType: "account", Type: "Posted", Position: 6})
+	bus.deliver("events.account", model.Event{ID: "e7", AggregateType: "account", Type: "Closed", Position: 7})
+	var msg wsMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "event" || msg.Event == nil || msg.Event.ID != "e7" {
+		t.Errorf("got %+v", msg)
+	}
+}
+
+// relayedStore publishes appended events on bus, as the outbox relay does.
+type relayedStore struct {
+	*eventstore.MemoryEventStore
+	bus     fakeConsumer
+	topicOf func(model.Event) string
+}
+
+func (s relayedStore) AppendEvents(ctx context.Context, aggregateID string, expectedVersion int64, events []model.Event) error {
+	if err := s.MemoryEventStore.AppendEvents(ctx, aggregateID, expectedVersion, events); err != nil {
+		return err
+	}
+	for _, event := range events {
+		s.bus.deliver(s.topicOf(event), event)
+	}
+	return nil
+}
+
+func TestPostedEventsStreamLive(t *testing.T) {
+	if err := RegisterEventSchema("invoice", "Paid", []byte(`{"type": "object"}`)); err != nil {
+		t.Fatal(err)
+	}
+	bus := fakeConsumer{}
+	topicOf := eventstore.TopicByAggregateType("events")
+	h, err := NewHub(bus, topicOf, "events.invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := relayedStore{eventstore.NewMemoryEventStore(), bus, topicOf}
+	for _, id := range []string{"old1", "old2"} {
+		store.SaveEvent(model.Event{ID: id, AggregateID: "i1", AggregateType: "invoice", Type: "Paid", Data: "{}"})
+	}
+	InitEventStore(store)
+	InitStream(h)
+	defer InitStream(nil)
+	server := httptest.NewServer(NewRouter())
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/events/ws?aggregate_type=invoice&after=0"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	next := func() string {
+		t.Helper()
+		var msg wsMessage
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Event == nil {
+			t.Fatalf("got %+v", msg)
+		}
+		return msg.Event.ID
+	}
+	if a, b := next(), next(); a != "old1" || b != "old2" {
+		t.Fatalf("caught up with %s, %s", a, b)
+	}
+
+	// A live event below the last position caught up from the store may
+	// have committed late; only IDs already sent are skipped.
+	bus.deliver("events.invoice", model.Event{ID: "old2", AggregateType: "invoice", Type: "Paid", Position: 2})
+	bus.deliver("events.invoice", model.Event{ID: "late", AggregateType: "invoice", Type: "Paid", Position: 1})
+	if id := next(); id != "late" {
+		t.Fatalf("got %s, want the late event", id)
+	}
+
+	resp, err := http.Post(server.URL+"/events", "application/json", strings.NewReader(`{"AggregateID": "i2", "AggregateType": "invoice", "Type": "Paid", "Data": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct{ Data model.Event }
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || body.Data.Version != 1 {
+		t.Fatalf("POST /events: %d %+v", resp.StatusCode, body.Data)
+	}
+	if id := next(); id != body.Data.ID {
+		t.Errorf("streamed %s, want the posted %s", id, body.Data.ID)
+	}
+}
+
+func TestResumeResendsUnsettledEvents(t *testing.T) {
+	store := eventstore.NewMemoryEventStore()
+	now := time.Now().UnixMilli()
+	for _, event := range []model.Event{{ID: "settled", Timestamp: 1}, {ID: "late", Timestamp: now}, {ID: "seen", Timestamp: 1}, {ID: "new", Timestamp: now}} {
+		store.SaveEvent(event)
+	}
+	h := &Hub{Log: store, clients: make(map[*client]struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The client saw position 3; position 2 may have committed after it.
+	var sent []string
+	send := func(event model.Event) error {
+		sent = append(sent, event.ID)
+		if len(sent) == 2 {
+			cancel()
+		}
+		return nil
+	}
+	if err := h.stream(ctx, streamFilter{}, 3, true, send, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sent, " "); got != "late new" {
+		t.Errorf("resumed with %s, want late new", got)
+	}
+}
+
+func TestSlowClientIsDropped(t *testing.T) {
+	h := &Hub{Buffer: 1, clients: make(map[*client]struct{})}
+	c := h.subscribe(streamFilter{})
+	h.Publish("events.account", model.Event{Position: 1})
+	select {
+	case <-c.lagged:
+		t.Fatal("dropped with room in its queue")
+	default:
+	}
+	h.Publish("events.account", model.Event{Position: 2})
+	select {
+	case <-c.lagged:
+	default:
+		t.Fatal("expected a full queue to drop the client")
+	}
+}
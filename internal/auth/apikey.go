@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"defi/internal/config"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// APIKeyHeader carries API keys.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys authenticates keys of the form <id>.<secret> against their
+// configured SHA-256 hashes; the keys themselves are never stored.
+type APIKeys struct {
+	keys map[string]config.APIKeyConfig
+}
+
+func NewAPIKeys(keys []config.APIKeyConfig) *APIKeys {
+	k := &APIKeys{keys: make(map[string]config.APIKeyConfig)}
+	for _, key := range keys {
+		k.keys[key.ID] = key
+	}
+	return k
+}
+
+func (k *APIKeys) Authenticate(key string) (*Principal, error) {
+	id, _, ok := strings.Cut(key, ".")
+	cfg, known := k.keys[id]
+	if !ok || !known {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+	}
+	want, err := hex.DecodeString(cfg.Hash)
+	sum := sha256.Sum256([]byte(key))
+	if err != nil || subtle.ConstantTimeCompare(sum[:], want) != 1 {
+		return nil, fmt.Errorf("%w: invalid API key %s", ErrUnauthenticated, id)
+	}
+	principal := cfg.Principal
+	if principal == "" {
+		principal = "apikey:" + cfg.ID
+	}
+	return &Principal{ID: principal, Method: MethodAPIKey, Roles: cfg.Roles, Scopes: append([]string(nil), cfg.Scopes...)}, nil
+}
+
+// NewAPIKey returns a random key with id and the hash to configure for it.
+func NewAPIKey(id string) (key, hash string, err error) {
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", "", err
+	}
+	key = id + "." + hex.EncodeToString(secret[:])
+	sum := sha256.Sum256([]byte(key))
+	return key, hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"defi/internal/config"
+	"defi/internal/model"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Actions scopes grant, alone for every aggregate type or as
+// <action>:<aggregate type> for one.
+const (
+	Read  = "events:read"
+	Write = "events:write"
+	// All grants every scope.
+	All = "*"
+)
+
+// Metadata keys recording who wrote an event.
+const (
+	MetadataPrincipal = model.MetadataPrincipal
+	MetadataMethod    = model.MetadataAuthMethod
+)
+
+// Authentication methods.
+const (
+	MethodAPIKey     = "api_key"
+	MethodJWT        = "jwt"
+	MethodClientCert = "client_cert"
+)
+
+// ErrUnauthenticated is returned for requests without valid credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal is an authenticated caller.
+type Principal struct {
+	ID     string
+	Method string
+	Roles  []string
+	// Scopes are those granted directly and by Roles.
+	Scopes []string
+}
+
+// Allows reports whether p may perform action on events of aggregateType.
+// An empty aggregateType asks for every type.
+func (p *Principal) Allows(action, aggregateType string) bool {
+	for _, scope := range p.Scopes {
+		if scope == All || scope == action || (aggregateType != "" && scope == action+":"+aggregateType) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAny reports whether p may perform action on some aggregate type.
+func (p *Principal) AllowsAny(action string) bool {
+	for _, scope := range p.Scopes {
+		if scope == All || scope == action || strings.HasPrefix(scope, action+":") {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Authenticator identifies callers by API key, JWT or TLS client
+// certificate, whichever the request carries, in that order.
+type Authenticator struct {
+	roles   map[string][]string
+	apiKeys *APIKeys
+	jwt     *JWTVerifier
+	certs   map[string]config.ClientCertConfig
+}
+
+// New returns an Authenticator for cfg.
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{roles: cfg.Roles, apiKeys: NewAPIKeys(cfg.APIKeys), certs: make(map[string]config.ClientCertConfig)}
+	if len(cfg.JWT.Keys) > 0 {
+		verifier, err := NewJWTVerifier(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = verifier
+	}
+	for _, cert := range cfg.ClientCerts {
+		a.certs[cert.Subject] = cert
+	}
+	return a, nil
+}
+
+// Authenticate returns the caller of r, or an error wrapping
+// ErrUnauthenticated.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	var (
+		p   *Principal
+		err error
+	)
+	switch {
+	case r.Header.Get(APIKeyHeader) != "":
+		p, err = a.apiKeys.Authenticate(r.Header.Get(APIKeyHeader))
+	case r.Header.Get("Authorization") != "":
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		switch {
+		case !ok:
+			err = fmt.Errorf("%w: unsupported authorization scheme", ErrUnauthenticated)
+		case a.jwt == nil:
+			err = fmt.Errorf("%w: bearer tokens are not accepted", ErrUnauthenticated)
+		default:
+			p, err = a.jwt.Verify(token)
+		}
+	case r.TLS != nil && len(r.TLS.VerifiedChains) > 0:
+		p, err = a.clientCert(r.TLS.VerifiedChains[0][0])
+	default:
+		err = fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.expand(p)
+	return p, nil
+}
+
+func (a *Authenticator) clientCert(cert *x509.Certificate) (*Principal, error) {
+	cfg, ok := a.certs[cert.Subject.CommonName]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown client certificate %q", ErrUnauthenticated, cert.Subject.CommonName)
+	}
+	id := cfg.Principal
+	if id == "" {
+		id = "cert:" + cfg.Subject
+	}
+	return &Principal{ID: id, Method: MethodClientCert, Roles: cfg.Roles, Scopes: append([]string(nil), cfg.Scopes...)}, nil
+}
+
+// expand adds the scopes of p's roles to its own.
+func (a *Authenticator) expand(p *Principal) {
+	for _, role := range p.Roles {
+		p.Scopes = append(p.Scopes, a.roles[role]...)
+	}
+}
+
+// ServerTLS returns the TLS config of the API server, or nil if cfg does
+// not enable TLS. Client certificates are requested but optional, so other
+// callers can still use API keys or tokens.
+func ServerTLS(cfg config.TLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsCfg, nil
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"defi/internal/config"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	key, hash, err := NewAPIKey("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	a, err := New(config.AuthConfig{
+		Roles:   map[string][]string{"writer": {Read, Write}, "payments": {Write + ":payment"}},
+		APIKeys: []config.APIKeyConfig{{ID: "ci", Hash: hash, Grant: config.Grant{Roles: []string{"writer"}}}},
+		JWT: config.JWTConfig{Issuer: "idp", Keys: []config.JWTKeyConfig{
+			{Algorithm: "HS256", Secret: "hmac-secret"},
+			{ID: "ec1", Algorithm: "ES256", PublicKey: string(publicPEM)},
+		}},
+		ClientCerts: []config.ClientCertConfig{{Subject: "settlement", Principal: "svc-settlement", Grant: config.Grant{Scopes: []string{Read}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(method jwt.SigningMethod, kid string, signingKey interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	request := func(header, value string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/events", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		return r
+	}
+	certRequest := httptest.NewRequest(http.MethodGet, "/events", nil)
+	certRequest.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "settlement"}}}}}
+
+	for name, tt := range map[string]struct {
+		r          *http.Request
+		id, method string
+		write      bool
+	}{
+		"api key":     {r: request(APIKeyHeader, key), id: "apikey:ci", method: MethodAPIKey, write: true},
+		"hmac token":  {r: request("Authorization", "Bearer "+sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), jwt.MapClaims{"sub": "alice", "iss": "idp", "exp": exp, "roles": []string{"payments"}})), id: "alice", method: MethodJWT},
+		"ec token":    {r: request("Authorization", "Bearer "+sign(jwt.SigningMethodES256, "ec1", ecKey, jwt.MapClaims{"sub": "bob", "iss": "idp", "exp": exp, "scope": "events:read events:write"})), id: "bob", method: MethodJWT, write: true},
+		"client cert": {r: certRequest, id: "svc-settlement", method: MethodClientCert},
+	} {
+		p, err := a.Authenticate(tt.r)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if p.ID != tt.id || p.Method != tt.method || p.Allows(Write, "") != tt.write {
+			t.Errorf("%s: got %+v", name, p)
+		}
+	}
+
+	p, _ := a.Authenticate(request("Authorization", "Bearer "+sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), jwt.MapClaims{"sub": "alice", "iss": "idp", "exp": exp, "roles": []string{"payments"}})))
+	if !p.Allows(Write, "payment") || p.Allows(Write, "account") || !p.AllowsAny(Write) || p.AllowsAny(Read) {
+		t.Errorf("typed scopes of %+v", p)
+	}
+
+	for name, r := range map[string]*http.Request{
+		"no credentials": request("", ""),
+		"wrong secret":   request(APIKeyHeader, "ci.0000"),
+		"unknown key":    request(APIKeyHeader, "other.0000"),
+		"basic auth":     request("Authorization", "Basic Y2k6eA=="),
+		"expired token":  request("Authorization", "Bearer "+sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), jwt.MapClaims{"sub": "alice", "iss": "idp", "exp": time.Now().Add(-time.Minute).Unix()})),
+		"no expiry":      request("Authorization", "Bearer "+sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), jwt.MapClaims{"sub": "alice", "iss": "idp"})),
+		"wrong issuer":   request("Authorization", "Bearer "+sign(jwt.SigningMethodHS256, "", []byte("hmac-secret"), jwt.MapClaims{"sub": "alice", "iss": "evil", "exp": exp})),
+		// The EC key's ID with an HMAC token must not verify with the
+		// public key as the secret.
+		"algorithm swap": request("Authorization", "Bearer "+sign(jwt.SigningMethodHS256, "ec1", publicPEM, jwt.MapClaims{"sub": "alice", "iss": "idp", "exp": exp})),
+	} {
+		if _, err := a.Authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: got %v, want ErrUnauthenticated", name, err)
+		}
+	}
+}
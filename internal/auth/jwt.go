@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"defi/internal/config"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"strings"
+)
+
+// JWTVerifier authenticates tokens signed with locally configured keys.
+type JWTVerifier struct {
+	cfg  config.JWTConfig
+	keys map[string][]jwtKey
+}
+
+type jwtKey struct {
+	algorithm string
+	key       interface{}
+}
+
+func NewJWTVerifier(cfg config.JWTConfig) (*JWTVerifier, error) {
+	v := &JWTVerifier{cfg: cfg, keys: make(map[string][]jwtKey)}
+	for _, k := range cfg.Keys {
+		var (
+			key interface{}
+			err error
+		)
+		switch {
+		case strings.HasPrefix(k.Algorithm, "HS"):
+			key = []byte(k.Secret)
+		case strings.HasPrefix(k.Algorithm, "RS"):
+			key, err = jwt.ParseRSAPublicKeyFromPEM([]byte(k.PublicKey))
+		case strings.HasPrefix(k.Algorithm, "ES"):
+			key, err = jwt.ParseECPublicKeyFromPEM([]byte(k.PublicKey))
+		case k.Algorithm == "EdDSA":
+			key, err = jwt.ParseEdPublicKeyFromPEM([]byte(k.PublicKey))
+		default:
+			err = fmt.Errorf("unsupported algorithm %q", k.Algorithm)
+		}
+		if err == nil && jwt.GetSigningMethod(k.Algorithm) == nil {
+			err = fmt.Errorf("unsupported algorithm %q", k.Algorithm)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT key %q: %w", k.ID, err)
+		}
+		v.keys[k.ID] = append(v.keys[k.ID], jwtKey{algorithm: k.Algorithm, key: key})
+	}
+	return v, nil
+}
+
+// Verify checks token's signature, expiry, issuer and audience and returns
+// its subject as the principal.
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if v.cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.cfg.Issuer))
+	}
+	if v.cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.cfg.Audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, v.key, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+	p := &Principal{ID: subject, Method: MethodJWT}
+	rolesClaim := v.cfg.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	if roles, ok := claims[rolesClaim].([]interface{}); ok {
+		for _, role := range roles {
+			if name, ok := role.(string); ok {
+				p.Roles = append(p.Roles, name)
+			}
+		}
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	}
+	return p, nil
+}
+
+// key returns the key the token names by kid, for its algorithm only, so
+// a token cannot pick how its signature is checked.
+func (v *JWTVerifier) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, k := range v.keys[kid] {
+		if k.algorithm == token.Method.Alg() {
+			return k.key, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s key %q", token.Method.Alg(), kid)
+}
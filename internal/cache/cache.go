@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"context"
+	"defi/internal/db"
+	"errors"
+	"time"
+)
+
+var ErrMiss = errors.New("cache miss")
+
+// Cache is the common interface over the configured cache backends. Get
+// returns ErrMiss when the key is absent or expired.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns a Cache over whichever backend db.InitDB connected, falling
+// back to an in-process cache when neither Redis nor Memcached is configured.
+// cacheType "none" disables caching.
+func New(database *db.DB, cacheType string) Cache {
+	switch {
+	case cacheType == "none":
+		return NoopCache{}
+	case database.Redis() != nil:
+		return NewRedisCache(database.Redis)
+	case database.Memcached != nil:
+		return NewMemcachedCache(database.Memcached)
+	default:
+		return NewMemoryCache()
+	}
+}
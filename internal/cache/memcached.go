@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rainycape/memcache"
+	"time"
+)
+
+type MemcachedCache struct {
+	client *memcache.Client
+}
+
+func NewMemcachedCache(client *memcache.Client) *MemcachedCache {
+	return &MemcachedCache{client: client}
+}
+
+func (c *MemcachedCache) Get(_ context.Context, key string) ([]byte, error) {
+	item, err := c.client.Get(key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("memcached get %s: %w", key, err)
+	}
+	return item.Value, nil
+}
+
+func (c *MemcachedCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	item := &memcache.Item{Key: key, Value: value, Expiration: int32(ttl / time.Second)}
+	if err := c.client.Set(item); err != nil {
+		return fmt.Errorf("memcached set %s: %w", key, err)
+	}
+	return nil
+}
+
+func (c *MemcachedCache) Delete(_ context.Context, key string) error {
+	if err := c.client.Delete(key); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return fmt.Errorf("memcached delete %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// RedisCache looks its client up on every call so it follows
+// db.DB.ReconfigureRedis.
+type RedisCache struct {
+	client func() *redis.ClusterClient
+}
+
+func NewRedisCache(client func() *redis.ClusterClient) *RedisCache {
+	return &RedisCache{client: client}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.client().Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis get %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.client().Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("redis set %s: %w", key, err)
+	}
+	return nil
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	if err := c.client().Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("redis delete %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const DefaultStateTTL = 10 * time.Minute
+
+// StateCache stores rehydrated aggregate state so hot aggregates need not be
+// replayed from the database on every load. Each entry is the state at its
+// version; readers replay the events after it, so entries are never stale,
+// only behind. It implements aggregate.Snapshots.
+type StateCache struct {
+	cache Cache
+	ttl   time.Duration
+}
+
+func NewStateCache(c Cache, ttl time.Duration) *StateCache {
+	return &StateCache{cache: c, ttl: ttl}
+}
+
+func stateKey(aggregateID string) string {
+	return "defi:aggregate:" + aggregateID
+}
+
+// Get returns the cached state of aggregateID. Backend errors are logged and
+// reported as a miss so a cache outage degrades to replaying from the store.
+func (s *StateCache) Get(ctx context.Context, aggregateID string) (aggregate.Snapshot, bool) {
+	raw, err := s.cache.Get(ctx, stateKey(aggregateID))
+	if err != nil {
+		if !errors.Is(err, ErrMiss) {
+			log.Printf("Failed to read cached state of %s: %v", aggregateID, err)
+		}
+		return aggregate.Snapshot{}, false
+	}
+	var snap aggregate.Snapshot
+	if err := json.Unmarshal(raw, &snap); err != nil || snap.AggregateID != aggregateID || snap.Version <= 0 {
+		log.Printf("Discarding corrupt cached state of %s: %v", aggregateID, err)
+		return aggregate.Snapshot{}, false
+	}
+	return snap, true
+}
+
+// Put caches snap. Concurrent loaders may leave an older snapshot in place
+// of a newer one; the next load then replays a few more events.
+func (s *StateCache) Put(ctx context.Context, snap aggregate.Snapshot) error {
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode state of %s: %w", snap.AggregateID, err)
+	}
+	return s.cache.Set(ctx, stateKey(snap.AggregateID), raw, s.ttl)
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache()
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, ErrMiss) {
+		t.Fatalf("empty cache: got %v", err)
+	}
+	value := []byte("v1")
+	c.Set(ctx, "k", value, 0)
+	value[1] = '2'
+	if got, err := c.Get(ctx, "k"); err != nil || string(got) != "v1" {
+		t.Errorf("get: got %q, %v", got, err)
+	}
+	c.Set(ctx, "short", []byte("x"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.Get(ctx, "short"); !errors.Is(err, ErrMiss) {
+		t.Errorf("expired entry: got %v", err)
+	}
+	c.Delete(ctx, "k")
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, ErrMiss) {
+		t.Errorf("deleted entry: got %v", err)
+	}
+}
+
+func TestStateCache(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryCache()
+	states := NewStateCache(backend, time.Minute)
+	if _, ok := states.Get(ctx, "alice"); ok {
+		t.Fatal("empty cache hit")
+	}
+	snap := aggregate.Snapshot{AggregateID: "alice", Type: "account", Version: 3, State: []byte(`{"Owner":"alice"}`)}
+	if err := states.Put(ctx, snap); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := states.Get(ctx, "alice")
+	if !ok || got.Type != "account" || got.Version != 3 || string(got.State) != string(snap.State) {
+		t.Errorf("get: got %+v, %v", got, ok)
+	}
+
+	// Corrupt entries and entries of another aggregate are misses.
+	backend.Set(ctx, stateKey("bob"), []byte("{"), 0)
+	raw, _ := backend.Get(ctx, stateKey("alice"))
+	backend.Set(ctx, stateKey("carol"), raw, 0)
+	for _, id := range []string{"bob", "carol"} {
+		if _, ok := states.Get(ctx, id); ok {
+			t.Errorf("%s: expected a miss", id)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package command
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/eventstore"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// DefaultMaxAttempts is how often Dispatch runs a handler that keeps failing
+// with a concurrency conflict.
+const DefaultMaxAttempts = 3
+
+var (
+	// ErrNoHandler is returned by Dispatch for command types without a handler.
+	ErrNoHandler = errors.New("no handler for command")
+	// ErrRejected marks commands refused by business rules. See Rejectf.
+	ErrRejected = errors.New("command rejected")
+)
+
+// Rejectf returns an error wrapping ErrRejected, for handlers refusing a
+// command that is valid on its own but not in the aggregate's current state.
+func Rejectf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrRejected, fmt.Sprintf(format, args...))
+}
+
+// Validator is implemented by commands that can check their own fields.
+type Validator interface {
+	Validate() error
+}
+
+// ValidationError is returned by Dispatch when a command fails Validate.
+type ValidationError struct {
+	Command string
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", e.Command, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Bus routes commands to the handler registered for their type.
+type Bus struct {
+	// MaxAttempts bounds retries on eventstore.ErrConcurrencyConflict.
+	MaxAttempts int
+	mu          sync.RWMutex
+	handlers    map[reflect.Type]func(context.Context, interface{}) error
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		MaxAttempts: DefaultMaxAttempts,
+		handlers:    make(map[reflect.Type]func(context.Context, interface{}) error),
+	}
+}
+
+// Register sets the handler for commands of type C. It panics if C already
+// has one.
+func Register[C any](b *Bus, handler func(ctx context.Context, cmd C) error) {
+	t := reflect.TypeOf(*new(C))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.handlers[t]; ok {
+		panic(fmt.Sprintf("command: handler for %s already registered", t))
+	}
+	b.handlers[t] = func(ctx context.Context, cmd interface{}) error {
+		return handler(ctx, cmd.(C))
+	}
+}
+
+// Dispatch validates cmd and runs its handler, retrying with a fresh load
+// when another writer changed the aggregate concurrently.
+func (b *Bus) Dispatch(ctx context.Context, cmd interface{}) error {
+	t := reflect.TypeOf(cmd)
+	b.mu.RLock()
+	handler, ok := b.handlers[t]
+	b.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrNoHandler, t)
+	}
+
+	if v, ok := cmd.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return &ValidationError{Command: t.Name(), Err: err}
+		}
+	}
+
+	attempts := b.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = handler(ctx, cmd)
+		if errors.Is(err, aggregate.ErrIllegalTransition) && !errors.Is(err, ErrRejected) {
+			// Events refused by a lifecycle are business rejections too.
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+		if !errors.Is(err, eventstore.ErrConcurrencyConflict) || ctx.Err() != nil {
+			return err
+		}
+		if attempt < attempts {
+			log.Printf("Retrying %s after concurrency conflict (attempt %d/%d)", t.Name(), attempt, attempts)
+		}
+	}
+	return err
+}
+
+// Handle builds a handler that loads the aggregate named by id(cmd), lets
+// decide raise events on it and saves them with the loaded version as the
+// expected version.
+func Handle[C any, S aggregate.State](repo *aggregate.Repository[S], id func(C) string, decide func(ctx context.Context, cmd C, root *aggregate.Root[S]) error) func(context.Context, C) error {
+	return func(ctx context.Context, cmd C) error {
+		root, err := repo.Load(ctx, id(cmd))
+		if err != nil {
+			return err
+		}
+		if err := decide(ctx, cmd, root); err != nil {
+			return err
+		}
+		return repo.Save(ctx, root)
+	}
+}
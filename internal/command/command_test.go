@@ -0,0 +1,87 @@
+package command
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type counter struct{ Value int }
+
+func (c *counter) Apply(event model.Event) error {
+	var delta int
+	if err := json.Unmarshal([]byte(event.Data), &delta); err != nil {
+		return err
+	}
+	c.Value += delta
+	return nil
+}
+
+type add struct {
+	ID    string
+	Delta int
+}
+
+func (c add) Validate() error {
+	if c.Delta == 0 {
+		return errors.New("delta must not be zero")
+	}
+	return nil
+}
+
+// conflictOnce fails the first append as if another writer got there first.
+type conflictOnce struct {
+	*eventstore.MemoryEventStore
+	failed bool
+}
+
+func (s *conflictOnce) AppendEvents(ctx context.Context, id string, expected int64, events []model.Event) error {
+	if !s.failed {
+		s.failed = true
+		return eventstore.ErrConcurrencyConflict
+	}
+	return s.MemoryEventStore.AppendEvents(ctx, id, expected, events)
+}
+
+func TestDispatch(t *testing.T) {
+	ctx := context.Background()
+	store := &conflictOnce{MemoryEventStore: eventstore.NewMemoryEventStore()}
+	repo := aggregate.NewRepository(store, "counter", func() *counter { return &counter{} })
+
+	bus := NewBus()
+	Register(bus, Handle(repo, func(c add) string { return c.ID }, func(ctx context.Context, c add, root *aggregate.Root[*counter]) error {
+		if root.State.Value+c.Delta < 0 {
+			return Rejectf("counter %s would go negative", c.ID)
+		}
+		return root.Raise("Added", c.Delta)
+	}))
+
+	if err := bus.Dispatch(ctx, add{ID: "c1", Delta: 5}); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if err := bus.Dispatch(ctx, add{ID: "c1", Delta: -2}); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if err := bus.Dispatch(ctx, add{ID: "c1", Delta: -4}); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected rejection, got %v", err)
+	}
+	var invalid *ValidationError
+	if err := bus.Dispatch(ctx, add{ID: "c1"}); !errors.As(err, &invalid) {
+		t.Errorf("expected validation error, got %v", err)
+	}
+	if err := bus.Dispatch(ctx, struct{}{}); !errors.Is(err, ErrNoHandler) {
+		t.Errorf("expected ErrNoHandler, got %v", err)
+	}
+
+	root, err := repo.Load(ctx, "c1")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if root.Version != 2 || root.State.Value != 3 {
+		t.Errorf("got version %d value %d, want 2 and 3", root.Version, root.State.Value)
+	}
+}
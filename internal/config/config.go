@@ -1,30 +1,43 @@
 package config
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/joho/godotenv"
-	"github.com/nacos-group/nacos-sdk-go/clients"
-	"github.com/nacos-group/nacos-sdk-go/clients/config_client"
-	"github.com/nacos-group/nacos-sdk-go/common/constant"
-	"github.com/nacos-group/nacos-sdk-go/vo"
 	"log"
 	"os"
-	"strconv"
+	"reflect"
 	"sync"
 	"time"
 )
 
 const (
-	DefaultGroup = "DEFAULT_GROUP"
-	CacheTTL     = 5 * time.Minute // Set TTL for cached configurations
+	DefaultGroup         = "DEFAULT_GROUP"
+	CacheTTL             = 5 * time.Minute // How often LoadConfig re-reads sources
+	DefaultMaxReplicaLag = 5 * time.Second
 )
 
+// Config is the complete application configuration. Backends selects which
+// of the MQ, DB and cache sections are in use; only those are validated.
+type Config struct {
+	Backends  BackendsConfig
+	Kafka     MQConfig
+	Nats      MQConfig
+	MySQL     DBConfig
+	Postgres  DBConfig
+	Cache     CacheConfig
+	Auth      AuthConfig
+	RateLimit RateLimitConfig
+}
+
+type BackendsConfig struct {
+	MQ string // "kafka" or "nats"
+	DB string // "mysql" or "postgres"
+}
+
 type MQConfig struct {
 	Type    string
-	Brokers []string
-	URL     string
+	Brokers []string // Kafka only
+	URL     string   // NATS only
 }
 
 type DBConfig struct {
@@ -32,48 +45,178 @@ type DBConfig struct {
 	Host     string
 	Port     int
 	User     string
-	Password string
+	Password string `secret:"true"`
 	Database string
+	// SSLMode is passed to Postgres as sslmode; empty uses the driver default.
+	SSLMode string
+	// Replicas serve read-only queries. User and Password default to the
+	// primary's when empty.
+	Replicas []DBReplicaConfig
+	// MaxReplicaLagSeconds is how far behind the primary a replica may fall
+	// before reads stop being routed to it. Zero means DefaultMaxReplicaLag.
+	MaxReplicaLagSeconds int
+	// Pool limits; zero keeps the database/sql defaults.
+	MaxOpenConns           int
+	MaxIdleConns           int
+	ConnMaxLifetimeSeconds int
+}
+
+type DBReplicaConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string `secret:"true"`
 }
 
 type RedisClusterConfig struct {
 	Addrs    []string
-	Password string
+	Password string `secret:"true"`
 }
 type MemcachedClusterConfig struct {
 	Addrs    []string
 	Username string
-	Password string
+	Password string `secret:"true"`
 }
 type CacheConfig struct {
-	Type             string
+	Type             string // "redis-cluster", "memcached", "memory" or "none"
 	RedisCluster     RedisClusterConfig
 	MemcachedCluster MemcachedClusterConfig
 }
-type MQConfigs struct {
-	Kafka MQConfig
-	Nats  MQConfig
+
+// AuthConfig configures who may call the HTTP API. With no credentials
+// configured every request is refused, unless Disabled is set.
+type AuthConfig struct {
+	// Disabled lets anyone call the API. Use it only on trusted networks.
+	Disabled bool
+	// APIKeys are sent as X-API-Key: <id>.<secret>.
+	APIKeys []APIKeyConfig
+	// JWT verifies Authorization: Bearer tokens.
+	JWT JWTConfig
+	// ClientCerts map verified TLS client certificates to principals.
+	ClientCerts []ClientCertConfig
+	// Roles maps role names to the scopes they grant.
+	Roles map[string][]string
+	TLS   TLSConfig
 }
 
-type DBConfigs struct {
-	MySQL    DBConfig
-	Postgres DBConfig
+// Grant is what an identity may do: its roles and any scopes beyond them.
+type Grant struct {
+	Roles  []string
+	Scopes []string
 }
 
-type CacheConfigs struct {
-	Cache CacheConfig
+type APIKeyConfig struct {
+	ID string
+	// Hash is the hex SHA-256 of the whole key; `main new-api-key` prints
+	// a key and its hash.
+	Hash string
+	// Principal names the caller in event metadata; it defaults to
+	// apikey:<ID>.
+	Principal string
+	Grant
+}
+
+type JWTConfig struct {
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// RolesClaim names the claim listing the caller's roles; it defaults
+	// to "roles". Scopes are read from the space-separated "scope" claim.
+	RolesClaim string
+	Keys       []JWTKeyConfig
+}
+
+// JWTKeyConfig is a key tokens may be signed with. Tokens name it by kid;
+// keys with no ID verify tokens without one.
+type JWTKeyConfig struct {
+	ID        string
+	Algorithm string // HS256/384/512, RS256/384/512, ES256/384/512 or EdDSA
+	// Secret is the HMAC key; PublicKey the PEM-encoded key of the others.
+	Secret    string `secret:"true"`
+	PublicKey string
+}
+
+type ClientCertConfig struct {
+	// Subject is the common name of the certificate.
+	Subject   string
+	Principal string
+	Grant
+}
+
+// TLSConfig serves the API over TLS when CertFile is set. ClientCAFile
+// lists the CAs client certificates are verified against.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// RateLimitConfig limits how often each caller and each client IP may call
+// the HTTP API, separately for reads and writes.
+type RateLimitConfig struct {
+	Disabled bool
+	Read     RateLimits
+	Write    RateLimits
+	// TrustedProxies are the CIDRs of proxies whose X-Forwarded-For names
+	// the client IP.
+	TrustedProxies []string
+}
+
+// RateLimits apply per authenticated caller and per client IP.
+type RateLimits struct {
+	PerCaller Rate
+	PerIP     Rate
+}
+
+// Rate allows Burst requests at once, refilled at PerMinute. A zero
+// PerMinute means no limit.
+type Rate struct {
+	PerMinute int
+	Burst     int
+}
+
+// MQ returns the config of the active message queue.
+func (c Config) MQ() MQConfig {
+	if c.Backends.MQ == "nats" {
+		return c.Nats
+	}
+	return c.Kafka
+}
+
+// DB returns the config of the active database.
+func (c Config) DB() DBConfig {
+	if c.Backends.DB == "postgres" {
+		return c.Postgres
+	}
+	return c.MySQL
+}
+
+// Defaults returns the configuration used for anything no provider sets.
+func Defaults() Config {
+	return Config{
+		Backends: BackendsConfig{MQ: "kafka", DB: "mysql"},
+		Kafka:    MQConfig{Type: "kafka"},
+		Nats:     MQConfig{Type: "nats", URL: "nats://127.0.0.1:4222"},
+		MySQL:    DBConfig{Type: "mysql", Port: 3306},
+		Postgres: DBConfig{Type: "postgres", Port: 5432},
+		Cache:    CacheConfig{Type: "memory"},
+		RateLimit: RateLimitConfig{
+			Read:  RateLimits{PerCaller: Rate{PerMinute: 600, Burst: 100}, PerIP: Rate{PerMinute: 300, Burst: 50}},
+			Write: RateLimits{PerCaller: Rate{PerMinute: 120, Burst: 20}, PerIP: Rate{PerMinute: 60, Burst: 10}},
+		},
+	}
 }
 
 var (
-	mqConfigs    MQConfigs
-	dbConfigs    DBConfigs
-	cacheConfigs CacheConfigs
+	appConfig    Config
 	configLoaded bool
 	cacheMutex   sync.RWMutex
 	cacheExpiry  time.Time
 )
 
-func LoadConfig() (MQConfigs, DBConfigs, CacheConfigs, error) {
+// LoadConfig assembles the configuration from every provider and validates
+// it, reporting all problems at once.
+func LoadConfig() (Config, error) {
 	// Determine the environment and load the corresponding .env.local file
 	env := os.Getenv("APP_ENV")
 	if env == "" {
@@ -84,169 +227,87 @@ func LoadConfig() (MQConfigs, DBConfigs, CacheConfigs, error) {
 	}
 
 	cacheMutex.RLock()
-	if configLoaded && time.Now().Before(cacheExpiry) {
-		cacheMutex.RUnlock()
-		return mqConfigs, dbConfigs, cacheConfigs, nil
-	}
+	loaded, expired := configLoaded, time.Now().After(cacheExpiry)
 	cacheMutex.RUnlock()
-
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-
-	if configLoaded && time.Now().Before(cacheExpiry) {
-		return mqConfigs, dbConfigs, cacheConfigs, nil
-	}
-
-	serverIP := os.Getenv("NACOS_SERVER_IP")
-	serverPortStr := os.Getenv("NACOS_SERVER_PORT")
-	if serverIP == "" {
-		serverIP = "127.0.0.1" // Default IP
-	}
-	if serverPortStr == "" {
-		serverPortStr = "8848" // Default port
-	}
-	serverPort, convErr := strconv.Atoi(serverPortStr)
-	if convErr != nil {
-		return MQConfigs{}, DBConfigs{}, CacheConfigs{}, fmt.Errorf("invalid NACOS_SERVER_PORT (%s): %w", serverPortStr, convErr)
-	}
-
-	serverConfigs := []constant.ServerConfig{
-		{
-			IpAddr: serverIP,
-			Port:   uint64(serverPort),
-		},
-	}
-
-	clientConfig := constant.ClientConfig{
-		NamespaceId:         "de1eff7d-6809-4b59-a33e-25a2d6ae6e6c",
-		TimeoutMs:           10000, // Increase timeout to 10 seconds
-		NotLoadCacheAtStart: true,
-		LogDir:              "nacos/log",
-		CacheDir:            "nacos/cache",
-		LogLevel:            "debug",                     // Enable detailed logging
-		Username:            os.Getenv("NACOS_USERNAME"), // Set Nacos username
-		Password:            os.Getenv("NACOS_PASSWORD"), // Set Nacos password
+	if loaded {
+		// Past the TTL, re-read sources without change notifications.
+		// Subscribers see the changes; on failure the old config stays.
+		if expired {
+			if reloadErr := Reload(); reloadErr != nil {
+				log.Printf("Warning: %v", reloadErr)
+			}
+			cacheMutex.Lock()
+			cacheExpiry = time.Now().Add(CacheTTL)
+			cacheMutex.Unlock()
+		}
+		cacheMutex.RLock()
+		defer cacheMutex.RUnlock()
+		return appConfig, nil
 	}
 
-	configClient, createErr := clients.CreateConfigClient(map[string]interface{}{
-		"serverConfigs": serverConfigs,
-		"clientConfig":  clientConfig,
-	})
-	if createErr != nil {
-		return MQConfigs{}, DBConfigs{}, CacheConfigs{}, fmt.Errorf("create config client failed: %w", createErr)
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if providers == nil {
+		chain, err := defaultProviders(onProviderChange)
+		if err != nil {
+			return Config{}, err
+		}
+		providers = chain
 	}
 
-	if loadErr := loadConfigFromNacos(configClient, "kafka-config", &mqConfigs.Kafka); loadErr != nil {
-		return MQConfigs{}, DBConfigs{}, CacheConfigs{}, fmt.Errorf("failed to load kafka-config: %w", loadErr)
-	}
-	if loadErr := loadConfigFromNacos(configClient, "mysql-config", &dbConfigs.MySQL); loadErr != nil {
-		return MQConfigs{}, DBConfigs{}, CacheConfigs{}, fmt.Errorf("failed to load mysql-config: %w", loadErr)
-	}
-	if loadErr := loadConfigFromNacos(configClient, "nats-config", &mqConfigs.Nats); loadErr != nil {
-		return MQConfigs{}, DBConfigs{}, CacheConfigs{}, fmt.Errorf("failed to load nats-config: %w", loadErr)
+	cfg := Defaults()
+	problems := &ValidationError{}
+	for _, s := range sections {
+		if _, loadErr := loadSection(providers, s.name, s.field(&cfg)); loadErr != nil {
+			problems.add("%s: %v", s.name, loadErr)
+		}
 	}
-	if loadErr := loadConfigFromNacos(configClient, "postgres-config", &dbConfigs.Postgres); loadErr != nil {
-		return MQConfigs{}, DBConfigs{}, CacheConfigs{}, fmt.Errorf("failed to load postgres-config: %w", loadErr)
+	resolveSecrets(&cfg, problems)
+	if len(problems.Problems) > 0 {
+		return Config{}, problems
 	}
-	if loadErr := loadConfigFromNacos(configClient, "redis-cluster-config", &cacheConfigs.Cache.RedisCluster); loadErr != nil {
-		return MQConfigs{}, DBConfigs{}, CacheConfigs{}, fmt.Errorf("failed to load redis-cluster-config: %w", loadErr)
+	if validateErr := cfg.Validate(); validateErr != nil {
+		return Config{}, validateErr
 	}
 
+	current = cfg
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	appConfig = cfg
 	configLoaded = true
 	cacheExpiry = time.Now().Add(CacheTTL)
-	return mqConfigs, dbConfigs, cacheConfigs, nil
+	return appConfig, nil
 }
 
-func loadConfigFromNacos(client config_client.IConfigClient, dataId string, config interface{}) error {
-	log.Printf("Fetching config for DataId: %s, Group: %s", dataId, DefaultGroup)
-	content, fetchErr := client.GetConfig(vo.ConfigParam{
-		DataId: dataId,
-		Group:  DefaultGroup,
-	})
-	if fetchErr != nil {
-		return fmt.Errorf("get config failed for %s: %w", dataId, fetchErr)
-	}
-
-	log.Printf("Fetched content for %s: %s", dataId, content)
-	if content == "" {
-		return fmt.Errorf("config content for %s is empty", dataId)
-	}
-
-	log.Printf("Parsing content for %s", dataId)
-	if parseErr := json.Unmarshal([]byte(content), config); parseErr != nil {
-		log.Printf("Failed to parse config for %s: %v", dataId, parseErr)
-		return fmt.Errorf("failed to parse config for %s: %w", dataId, parseErr)
-	}
-
-	log.Printf("Validating config for %s", dataId)
-	if validateErr := validateConfig(config); validateErr != nil {
-		log.Printf("Invalid configuration for %s: %v", dataId, validateErr)
-		return fmt.Errorf("invalid configuration for %s: %w", dataId, validateErr)
-	}
-
-	// Listen for configuration changes
-	listenErr := client.ListenConfig(vo.ConfigParam{
-		DataId: dataId,
-		Group:  DefaultGroup,
-		OnChange: func(namespace, group, dataId, data string) {
-			log.Printf("Config changed for %s: %s\n", dataId, data)
-			cacheMutex.Lock()
-			defer cacheMutex.Unlock()
-			if parseErr := json.Unmarshal([]byte(data), config); parseErr != nil {
-				log.Printf("Failed to parse updated config for %s: %v", dataId, parseErr)
-				return
-			}
-			if validateErr := validateConfig(config); validateErr != nil {
-				log.Printf("Invalid updated configuration for %s: %v", dataId, validateErr)
-				return
-			}
-			configLoaded = false // Invalidate cache
-		},
-	})
-	if listenErr != nil {
-		return fmt.Errorf("listen config failed for %s: %w", dataId, listenErr)
-	}
+// section binds a configuration section name to the field of Config it
+// decodes into.
+type section struct {
+	name  string
+	field func(c *Config) interface{}
+}
 
-	return nil
+var sections = []section{
+	{SectionBackends, func(c *Config) interface{} { return &c.Backends }},
+	{SectionKafka, func(c *Config) interface{} { return &c.Kafka }},
+	{SectionNats, func(c *Config) interface{} { return &c.Nats }},
+	{SectionMySQL, func(c *Config) interface{} { return &c.MySQL }},
+	{SectionPostgres, func(c *Config) interface{} { return &c.Postgres }},
+	{SectionCache, func(c *Config) interface{} { return &c.Cache }},
+	{SectionRedisCluster, func(c *Config) interface{} { return &c.Cache.RedisCluster }},
+	{SectionMemcachedCluster, func(c *Config) interface{} { return &c.Cache.MemcachedCluster }},
+	{SectionAuth, func(c *Config) interface{} { return &c.Auth }},
+	{SectionRateLimit, func(c *Config) interface{} { return &c.RateLimit }},
 }
 
-func validateConfig(config interface{}) error {
-	switch cfg := config.(type) {
-	case *MQConfig:
-		if cfg.Type == "" {
-			return errors.New("type is required")
-		}
-		if len(cfg.Brokers) == 0 {
-			return errors.New("at least one broker is required")
+func findSection(name string) (section, bool) {
+	for _, s := range sections {
+		if s.name == name {
+			return s, true
 		}
-		if cfg.URL == "" {
-			return errors.New("URL is required")
-		}
-	case *DBConfig:
-		if cfg.Type == "" {
-			return errors.New("type is required")
-		}
-		if cfg.Host == "" {
-			return errors.New("host is required")
-		}
-		if cfg.Port == 0 {
-			return errors.New("port is required")
-		}
-		if cfg.User == "" {
-			return errors.New("user is required")
-		}
-		if cfg.Password == "" {
-			return errors.New("password is required")
-		}
-		if cfg.Database == "" {
-			return errors.New("database is required")
-		}
-	case *RedisClusterConfig:
-		if len(cfg.Addrs) == 0 {
-			return errors.New("at least one address is required")
-		}
-	default:
-		return errors.New("unknown config type")
 	}
-	return nil
+	return section{}, false
+}
+
+func sectionType(s section) reflect.Type {
+	return reflect.TypeOf(s.field(&Config{})).Elem()
 }
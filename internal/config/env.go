@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const EnvPrefix = "DEFI"
+
+// EnvProvider reads scalar fields from environment variables named
+// <prefix>_<SECTION>_<FIELD>, e.g. DEFI_MYSQL_HOST or DEFI_KAFKA_BROKERS.
+// Field names are converted to upper snake case; string slices are
+// comma-separated. Nested structs and slices of structs are not supported.
+type EnvProvider struct {
+	prefix string
+	lookup func(string) (string, bool)
+}
+
+func NewEnvProvider(prefix string) *EnvProvider {
+	return &EnvProvider{prefix: prefix, lookup: os.LookupEnv}
+}
+
+func (p *EnvProvider) Name() string {
+	return "environment"
+}
+
+func (p *EnvProvider) Load(section string, out interface{}) (bool, error) {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return false, fmt.Errorf("config target for %s must be a pointer to a struct", section)
+	}
+	return p.loadStruct(p.prefix+"_"+envName(section), v.Elem())
+}
+
+func (p *EnvProvider) loadStruct(prefix string, v reflect.Value) (bool, error) {
+	found := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + "_" + envName(field.Name)
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			ok, err := p.loadStruct(name, fv)
+			if err != nil {
+				return false, err
+			}
+			found = found || ok
+			continue
+		}
+
+		value, ok := p.lookup(name)
+		if !ok {
+			continue
+		}
+		switch {
+		case fv.Kind() == reflect.String:
+			fv.SetString(value)
+		case fv.Kind() == reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return false, fmt.Errorf("invalid %s (%s): %w", name, value, err)
+			}
+			fv.SetInt(int64(n))
+		case fv.Kind() == reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, fmt.Errorf("invalid %s (%s): %w", name, value, err)
+			}
+			fv.SetBool(b)
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			fv.Set(reflect.ValueOf(items))
+		default:
+			continue
+		}
+		found = true
+	}
+	return found, nil
+}
+
+// envName converts a section or field name such as "redis-cluster" or
+// "MaxReplicaLagSeconds" to REDIS_CLUSTER or MAX_REPLICA_LAG_SECONDS.
+func envName(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == '.':
+			b.WriteByte('_')
+			continue
+		case unicode.IsUpper(r) && i > 0 && runes[i-1] != '-' &&
+			(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))):
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileProvider reads every section from a single JSON or YAML file whose
+// top-level keys are section names.
+type FileProvider struct {
+	path     string
+	sections map[string]json.RawMessage
+}
+
+func NewFileProvider(path string) (*FileProvider, error) {
+	p := &FileProvider{path: path}
+	if err := p.Refresh(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Refresh re-reads the file.
+func (p *FileProvider) Refresh() error {
+	sections, err := readSections(p.path)
+	if err != nil {
+		return err
+	}
+	p.sections = sections
+	return nil
+}
+
+func readSections(path string) (map[string]json.RawMessage, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	sections := make(map[string]json.RawMessage)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		for section, value := range doc {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse section %s of %s: %w", section, path, err)
+			}
+			sections[section] = raw
+		}
+	case ".json":
+		if err := json.Unmarshal(content, &sections); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file format: %s", path)
+	}
+	return sections, nil
+}
+
+func (p *FileProvider) Name() string {
+	return "file " + p.path
+}
+
+func (p *FileProvider) Load(section string, out interface{}) (bool, error) {
+	raw, ok := p.sections[section]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return false, fmt.Errorf("failed to parse config for %s: %w", section, err)
+	}
+	return true, nil
+}
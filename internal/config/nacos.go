@@ -0,0 +1,214 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nacos-group/nacos-sdk-go/clients"
+	"github.com/nacos-group/nacos-sdk-go/clients/config_client"
+	"github.com/nacos-group/nacos-sdk-go/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+type NacosOptions struct {
+	ServerIP    string
+	ServerPort  uint64
+	NamespaceID string
+	Username    string
+	Password    string `secret:"true"`
+	// FallbackDir holds the last content fetched for each data ID, used when
+	// the server is unreachable.
+	FallbackDir string
+}
+
+// NacosOptionsFromEnv reads NACOS_SERVER_IP, NACOS_SERVER_PORT,
+// NACOS_NAMESPACE_ID, NACOS_USERNAME, NACOS_PASSWORD and NACOS_FALLBACK_DIR.
+func NacosOptionsFromEnv() NacosOptions {
+	opts := NacosOptions{
+		ServerIP:    os.Getenv("NACOS_SERVER_IP"),
+		ServerPort:  8848,
+		NamespaceID: os.Getenv("NACOS_NAMESPACE_ID"),
+		Username:    os.Getenv("NACOS_USERNAME"),
+		Password:    os.Getenv("NACOS_PASSWORD"),
+		FallbackDir: os.Getenv("NACOS_FALLBACK_DIR"),
+	}
+	if opts.ServerIP == "" {
+		opts.ServerIP = "127.0.0.1" // Default IP
+	}
+	if port, err := strconv.ParseUint(os.Getenv("NACOS_SERVER_PORT"), 10, 64); err == nil {
+		opts.ServerPort = port
+	} else if os.Getenv("NACOS_SERVER_PORT") != "" {
+		log.Printf("Warning: invalid NACOS_SERVER_PORT (%s), using %d", os.Getenv("NACOS_SERVER_PORT"), opts.ServerPort)
+	}
+	if opts.FallbackDir == "" {
+		opts.FallbackDir = "nacos/fallback"
+	}
+	return opts
+}
+
+// NacosProvider loads each section from the Nacos data ID section+"-config".
+// Content is mirrored to a local fallback cache so the service can still
+// start with the last known config while Nacos is down.
+type NacosProvider struct {
+	opts      NacosOptions
+	client    config_client.IConfigClient
+	onChange  func(section string)
+	mu        sync.Mutex
+	listening map[string]bool
+}
+
+func NewNacosProvider(opts NacosOptions, onChange func(section string)) (*NacosProvider, error) {
+	serverConfigs := []constant.ServerConfig{
+		{
+			IpAddr: opts.ServerIP,
+			Port:   opts.ServerPort,
+		},
+	}
+
+	clientConfig := constant.ClientConfig{
+		NamespaceId:         opts.NamespaceID,
+		TimeoutMs:           10000, // Increase timeout to 10 seconds
+		NotLoadCacheAtStart: true,
+		LogDir:              "nacos/log",
+		CacheDir:            "nacos/cache",
+		LogLevel:            "warn", // The SDK logs config content at debug level
+		Username:            opts.Username,
+		Password:            opts.Password,
+	}
+
+	configClient, createErr := clients.CreateConfigClient(map[string]interface{}{
+		"serverConfigs": serverConfigs,
+		"clientConfig":  clientConfig,
+	})
+	if createErr != nil {
+		return nil, fmt.Errorf("create config client failed: %w", createErr)
+	}
+	return &NacosProvider{
+		opts:      opts,
+		client:    configClient,
+		onChange:  onChange,
+		listening: make(map[string]bool),
+	}, nil
+}
+
+func (o NacosOptions) String() string { return redactedString(o) }
+
+func (p *NacosProvider) Name() string {
+	return fmt.Sprintf("nacos %s:%d", p.opts.ServerIP, p.opts.ServerPort)
+}
+
+// Ping asks the Nacos server whether it is ready. The SDK answers reads
+// from its local cache when the server is down, so it cannot tell.
+func (p *NacosProvider) Ping(ctx context.Context) error {
+	url := fmt.Sprintf("http://%s:%d/nacos/v1/console/health/readiness", p.opts.ServerIP, p.opts.ServerPort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("nacos is not ready: %s", resp.Status)
+	}
+	return nil
+}
+
+func DataID(section string) string {
+	return section + "-config"
+}
+
+func (p *NacosProvider) Load(section string, out interface{}) (bool, error) {
+	dataId := DataID(section)
+	log.Printf("Fetching config for DataId: %s, Group: %s", dataId, DefaultGroup)
+	content, fetchErr := p.client.GetConfig(vo.ConfigParam{
+		DataId: dataId,
+		Group:  DefaultGroup,
+	})
+	if fetchErr != nil {
+		cached, cacheErr := os.ReadFile(p.fallbackPath(dataId))
+		if cacheErr != nil {
+			return false, fmt.Errorf("%w: get config failed for %s: %v", ErrUnavailable, dataId, fetchErr)
+		}
+		log.Printf("Warning: using cached config for %s: %v", dataId, fetchErr)
+		content = string(cached)
+	} else {
+		log.Printf("Fetched content for %s: %s", dataId, RedactJSON(content))
+	}
+
+	// Listen whether the section was fetched, read from the cache or is not
+	// published yet, so that a later change or first publish is picked up.
+	if listenErr := p.listen(section); listenErr != nil {
+		if fetchErr == nil {
+			return false, listenErr
+		}
+		log.Printf("Warning: %v", listenErr)
+	}
+	if content == "" {
+		return false, nil
+	}
+	if fetchErr == nil {
+		p.writeFallback(dataId, content)
+	}
+
+	log.Printf("Parsing content for %s", dataId)
+	if parseErr := json.Unmarshal([]byte(content), out); parseErr != nil {
+		log.Printf("Failed to parse config for %s: %v", dataId, parseErr)
+		return false, fmt.Errorf("failed to parse config for %s: %w", dataId, parseErr)
+	}
+	return true, nil
+}
+
+// listen registers a change listener for section once. Changes are mirrored
+// to the fallback cache and reported to onChange.
+func (p *NacosProvider) listen(section string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listening[section] {
+		return nil
+	}
+
+	listenErr := p.client.ListenConfig(vo.ConfigParam{
+		DataId: DataID(section),
+		Group:  DefaultGroup,
+		OnChange: func(namespace, group, dataId, data string) {
+			log.Printf("Config changed for %s: %s\n", dataId, RedactJSON(data))
+			p.writeFallback(dataId, data)
+			if p.onChange != nil {
+				p.onChange(section)
+			}
+		},
+	})
+	if listenErr != nil {
+		return fmt.Errorf("listen config failed for %s: %w", DataID(section), listenErr)
+	}
+	p.listening[section] = true
+	return nil
+}
+
+func (p *NacosProvider) fallbackPath(dataId string) string {
+	namespace := p.opts.NamespaceID
+	if namespace == "" {
+		namespace = "public"
+	}
+	return filepath.Join(p.opts.FallbackDir, namespace, dataId+".json")
+}
+
+func (p *NacosProvider) writeFallback(dataId, content string) {
+	path := p.fallbackPath(dataId)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		log.Printf("Warning: failed to create config fallback dir: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		log.Printf("Warning: failed to write config fallback for %s: %v", dataId, err)
+	}
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"context"
+	"defi/internal/health"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Configuration sections. Each is a JSON object in a config file, an
+// environment variable prefix and a Nacos data ID (section + "-config").
+const (
+	SectionBackends         = "backends"
+	SectionKafka            = "kafka"
+	SectionNats             = "nats"
+	SectionMySQL            = "mysql"
+	SectionPostgres         = "postgres"
+	SectionCache            = "cache"
+	SectionRedisCluster     = "redis-cluster"
+	SectionMemcachedCluster = "memcached-cluster"
+	SectionAuth             = "auth"
+	SectionRateLimit        = "rate-limit"
+)
+
+// ErrUnavailable is returned by providers whose backing source cannot be
+// reached. Layered loading skips such providers instead of failing.
+var ErrUnavailable = errors.New("config source unavailable")
+
+// Provider is one source of configuration. Load decodes section into out,
+// overwriting only the fields the source sets, and reports whether the source
+// had anything for the section.
+type Provider interface {
+	Name() string
+	Load(section string, out interface{}) (bool, error)
+}
+
+// pinger is implemented by providers backed by a remote source.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthChecks returns a check for each remote config source. None is
+// critical: the loaded config stays in use while its source is down.
+func HealthChecks() []health.Check {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	var checks []health.Check
+	for _, p := range providers {
+		if remote, ok := p.(pinger); ok {
+			checks = append(checks, health.Check{Name: "config " + p.Name(), Run: remote.Ping})
+		}
+	}
+	return checks
+}
+
+// loadSection applies providers in order of increasing precedence, so later
+// providers override fields set by earlier ones. It reports whether any
+// provider had the section; fields none of them set keep their value in out.
+func loadSection(providers []Provider, section string, out interface{}) (bool, error) {
+	found := false
+	for _, p := range providers {
+		ok, err := p.Load(section, out)
+		if errors.Is(err, ErrUnavailable) {
+			log.Printf("Warning: skipping %s for %s: %v", p.Name(), section, err)
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+		found = found || ok
+	}
+	return found, nil
+}
+
+// defaultProviders builds the provider chain from the environment, lowest
+// precedence first: the config file, then Nacos, then environment variables.
+//
+// The file is CONFIG_FILE, or config.yaml, config.yml or config.json in the
+// working directory. Nacos is used when NACOS_ENABLED=true or NACOS_SERVER_IP
+// is set, and otherwise only when there is no config file; NACOS_ENABLED=false
+// turns it off.
+func defaultProviders(onChange func(section string)) ([]Provider, error) {
+	var providers []Provider
+
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		for _, candidate := range []string{"config.yaml", "config.yml", "config.json"} {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path != "" {
+		fp, err := NewFileProvider(path)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, fp)
+	}
+
+	useNacos := path == "" || os.Getenv("NACOS_SERVER_IP") != ""
+	switch strings.ToLower(os.Getenv("NACOS_ENABLED")) {
+	case "true", "1":
+		useNacos = true
+	case "false", "0":
+		useNacos = false
+	}
+	if useNacos {
+		np, err := NewNacosProvider(NacosOptionsFromEnv(), onChange)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, np)
+	}
+
+	providers = append(providers, NewEnvProvider(EnvPrefix))
+	return providers, nil
+}
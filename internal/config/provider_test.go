@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSectionLayersProviders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "mysql:\n  type: mysql\n  host: db.local\n  port: 3306\n  user: app\n  password: secret\n  database: defi\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	file, err := NewFileProvider(path)
+	if err != nil {
+		t.Fatalf("Failed to create file provider: %v", err)
+	}
+	env := &EnvProvider{prefix: EnvPrefix, lookup: func(name string) (string, bool) {
+		values := map[string]string{
+			"DEFI_MYSQL_HOST":                    "db.prod",
+			"DEFI_MYSQL_MAX_REPLICA_LAG_SECONDS": "9",
+		}
+		v, ok := values[name]
+		return v, ok
+	}}
+
+	cfg := Defaults().MySQL
+	if found, err := loadSection([]Provider{file, env}, SectionMySQL, &cfg); err != nil || !found {
+		t.Fatalf("Failed to load section: found=%v err=%v", found, err)
+	}
+	if cfg.Host != "db.prod" || cfg.User != "app" || cfg.MaxReplicaLagSeconds != 9 {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+
+	if found, _ := loadSection([]Provider{file, env}, SectionPostgres, &cfg); found {
+		t.Fatal("Expected no provider to have the postgres section")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]string{
+		"redis-cluster":        "REDIS_CLUSTER",
+		"MaxReplicaLagSeconds": "MAX_REPLICA_LAG_SECONDS",
+		"URL":                  "URL",
+		"Addrs":                "ADDRS",
+	}
+	for in, expected := range cases {
+		if got := envName(in); got != expected {
+			t.Fatalf("envName(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const redactedValue = "******"
+
+// secretKeyNames are substrings of keys treated as secret in raw documents
+// whose target type is unknown, such as content fetched from Nacos.
+var secretKeyNames = []string{"password", "secret", "token", "apikey", "privatekey"}
+
+// Redacted returns a deep copy of c with every field tagged
+// `secret:"true"` masked. Use it, or String, whenever config is logged.
+func (c Config) Redacted() Config {
+	return redact(c)
+}
+
+func (c Config) String() string                 { return redactedString(c) }
+func (c DBConfig) String() string               { return redactedString(c) }
+func (c DBReplicaConfig) String() string        { return redactedString(c) }
+func (c RedisClusterConfig) String() string     { return redactedString(c) }
+func (c MemcachedClusterConfig) String() string { return redactedString(c) }
+func (c CacheConfig) String() string            { return redactedString(c) }
+
+// redact returns a masked deep copy of v, which must be a config struct.
+func redact[T any](v T) T {
+	var clone T
+	raw, err := json.Marshal(v)
+	if err != nil || json.Unmarshal(raw, &clone) != nil {
+		return clone
+	}
+	walkStrings(reflect.ValueOf(&clone).Elem(), "", false, func(_ string, field reflect.Value, secret bool) {
+		if secret && field.String() != "" {
+			field.SetString(redactedValue)
+		}
+	})
+	return clone
+}
+
+func redactedString[T any](v T) string {
+	raw, err := json.Marshal(redact(v))
+	if err != nil {
+		return "<unprintable config>"
+	}
+	return string(raw)
+}
+
+// RedactJSON masks values of secret-looking keys in a JSON document. Content
+// that is not JSON is fully masked, since it cannot be inspected.
+func RedactJSON(content string) string {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(content), &doc); err != nil {
+		return redactedValue
+	}
+	raw, err := json.Marshal(redactDocument(doc))
+	if err != nil {
+		return redactedValue
+	}
+	return string(raw)
+}
+
+func redactDocument(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSecretKey(key) {
+				if s, ok := value.(string); ok && s == "" {
+					continue
+				}
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactDocument(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactDocument(v[i])
+		}
+	}
+	return doc
+}
+
+func isSecretKey(key string) bool {
+	normalized := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	for _, name := range secretKeyNames {
+		if strings.Contains(normalized, name) {
+			return true
+		}
+	}
+	return false
+}
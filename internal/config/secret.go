@@ -0,0 +1,157 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Secret references may be used as the whole value of any string field:
+//
+//	${env:NAME}    the value of environment variable NAME
+//	${file:/path}  the contents of a file, without the trailing newline
+//	${enc:BASE64}  AES-256-GCM ciphertext, decrypted with the local key
+//
+// The local key is 32 bytes, base64 encoded, read from CONFIG_SECRET_KEY or
+// from the file named by CONFIG_SECRET_KEY_FILE. Use EncryptSecret (or
+// `main encrypt-secret`) to produce ${enc:...} values.
+var secretRef = regexp.MustCompile(`^\$\{(env|file|enc):(.+)\}$`)
+
+var errNoSecretKey = errors.New("no secret key: set CONFIG_SECRET_KEY or CONFIG_SECRET_KEY_FILE")
+
+// resolveSecrets replaces every secret reference in the string fields of v,
+// which must be a pointer, and reports all that could not be resolved.
+func resolveSecrets(v interface{}, problems *ValidationError) {
+	walkStrings(reflect.ValueOf(v).Elem(), "", false, func(path string, field reflect.Value, _ bool) {
+		m := secretRef.FindStringSubmatch(field.String())
+		if m == nil {
+			return
+		}
+		value, err := resolveSecret(m[1], m[2])
+		if err != nil {
+			problems.add("%s: %v", path, err)
+			return
+		}
+		field.SetString(value)
+	})
+}
+
+func resolveSecret(kind, ref string) (string, error) {
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref)
+		}
+		return value, nil
+	case "file":
+		content, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case "enc":
+		key, err := LocalSecretKey()
+		if err != nil {
+			return "", err
+		}
+		return DecryptSecret(key, ref)
+	}
+	return "", fmt.Errorf("unknown secret reference %s", kind)
+}
+
+// LocalSecretKey returns the key configured by CONFIG_SECRET_KEY or
+// CONFIG_SECRET_KEY_FILE.
+func LocalSecretKey() ([]byte, error) {
+	encoded := os.Getenv("CONFIG_SECRET_KEY")
+	if path := os.Getenv("CONFIG_SECRET_KEY_FILE"); encoded == "" && path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret key file: %w", err)
+		}
+		encoded = strings.TrimSpace(string(content))
+	}
+	if encoded == "" {
+		return nil, errNoSecretKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("secret key must be 32 bytes, base64 encoded")
+	}
+	return key, nil
+}
+
+// EncryptSecret encrypts plaintext with key and returns a ${enc:...}
+// reference that resolves back to it.
+func EncryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "${enc:" + base64.StdEncoding.EncodeToString(sealed) + "}", nil
+}
+
+// DecryptSecret decrypts the base64 payload of an ${enc:...} reference.
+func DecryptSecret(key []byte, payload string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", errors.New("malformed encrypted secret")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt secret: wrong key or corrupted value")
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// walkStrings calls fn for every settable string reachable from v through
+// struct fields and slices, with a dotted path for error messages and
+// whether the field is tagged `secret:"true"`.
+func walkStrings(v reflect.Value, path string, secret bool, fn func(path string, field reflect.Value, secret bool)) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			fn(path, v, secret)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			name := t.Field(i).Name
+			if path != "" {
+				name = path + "." + name
+			}
+			walkStrings(v.Field(i), name, t.Field(i).Tag.Get("secret") == "true", fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), fmt.Sprintf("%s[%d]", path, i), secret, fn)
+		}
+	}
+}
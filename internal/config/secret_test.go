@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	key := make([]byte, 32)
+	t.Setenv("CONFIG_SECRET_KEY", base64.StdEncoding.EncodeToString(key))
+	t.Setenv("TEST_DB_PASSWORD", "from-env")
+	path := filepath.Join(t.TempDir(), "redis-password")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	encrypted, err := EncryptSecret(key, "from-enc")
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+
+	cfg := validConfig()
+	cfg.MySQL.Password = "${env:TEST_DB_PASSWORD}"
+	cfg.Cache.RedisCluster.Password = "${file:" + path + "}"
+	cfg.Postgres.Password = encrypted
+	cfg.MySQL.Replicas = []DBReplicaConfig{{Host: "r1", Port: 3306, Password: "${env:MISSING_SECRET}"}}
+
+	problems := &ValidationError{}
+	resolveSecrets(&cfg, problems)
+	if cfg.MySQL.Password != "from-env" || cfg.Cache.RedisCluster.Password != "from-file" || cfg.Postgres.Password != "from-enc" {
+		t.Fatalf("Secrets not resolved: %+v", cfg.Redacted())
+	}
+	if len(problems.Problems) != 1 || !strings.Contains(problems.Problems[0], "MySQL.Replicas[0].Password") {
+		t.Fatalf("Expected one problem for the missing variable, got %v", problems.Problems)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	cfg := validConfig()
+	cfg.MySQL.Replicas = []DBReplicaConfig{{Host: "r1", Port: 3306, Password: "replica-secret"}}
+
+	dump := cfg.String()
+	if strings.Contains(dump, "secret") {
+		t.Fatalf("Secret leaked in %s", dump)
+	}
+	if cfg.MySQL.Replicas[0].Password != "replica-secret" {
+		t.Fatal("Redaction modified the original config")
+	}
+
+	raw := RedactJSON(`{"Host":"db","Password":"p","nested":{"api_key":"k","token":"t"}}`)
+	if strings.Contains(raw, `"p"`) || strings.Contains(raw, `"t"`) || !strings.Contains(raw, `"db"`) {
+		t.Fatalf("Unexpected redaction: %s", raw)
+	}
+}
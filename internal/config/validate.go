@@ -0,0 +1,205 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the active backends and the cache. Sections for inactive
+// backends are not checked.
+func (c Config) Validate() error {
+	v := &ValidationError{}
+
+	switch c.Backends.MQ {
+	case "kafka":
+		validateKafka(v, c.Kafka)
+	case "nats":
+		validateNats(v, c.Nats)
+	default:
+		v.add("%s.MQ: unsupported message queue %q, expected kafka or nats", SectionBackends, c.Backends.MQ)
+	}
+
+	switch c.Backends.DB {
+	case "mysql":
+		validateDB(v, SectionMySQL, c.MySQL)
+	case "postgres":
+		validateDB(v, SectionPostgres, c.Postgres)
+	default:
+		v.add("%s.DB: unsupported database %q, expected mysql or postgres", SectionBackends, c.Backends.DB)
+	}
+
+	switch c.Cache.Type {
+	case "redis-cluster":
+		if len(c.Cache.RedisCluster.Addrs) == 0 {
+			v.add("%s.Addrs: at least one address is required", SectionRedisCluster)
+		}
+	case "memcached":
+		if len(c.Cache.MemcachedCluster.Addrs) == 0 {
+			v.add("%s.Addrs: at least one address is required", SectionMemcachedCluster)
+		}
+	case "memory", "none":
+	default:
+		v.add("%s.Type: unsupported cache %q, expected redis-cluster, memcached, memory or none", SectionCache, c.Cache.Type)
+	}
+
+	validateAuth(v, c.Auth)
+	validateRateLimit(v, c.RateLimit)
+
+	if len(v.Problems) > 0 {
+		return v
+	}
+	return nil
+}
+
+func validateKafka(v *ValidationError, cfg MQConfig) {
+	if cfg.Type != "kafka" {
+		v.add("%s.Type: must be kafka, got %q", SectionKafka, cfg.Type)
+	}
+	if len(cfg.Brokers) == 0 {
+		v.add("%s.Brokers: at least one broker is required", SectionKafka)
+	}
+	for i, broker := range cfg.Brokers {
+		if !strings.Contains(broker, ":") {
+			v.add("%s.Brokers[%d]: %q must be host:port", SectionKafka, i, broker)
+		}
+	}
+}
+
+func validateNats(v *ValidationError, cfg MQConfig) {
+	if cfg.Type != "nats" {
+		v.add("%s.Type: must be nats, got %q", SectionNats, cfg.Type)
+	}
+	if cfg.URL == "" {
+		v.add("%s.URL: is required", SectionNats)
+	}
+}
+
+func validateDB(v *ValidationError, name string, cfg DBConfig) {
+	if cfg.Type != name {
+		v.add("%s.Type: must be %s, got %q", name, name, cfg.Type)
+	}
+	if cfg.Host == "" {
+		v.add("%s.Host: is required", name)
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		v.add("%s.Port: %d is not a valid port", name, cfg.Port)
+	}
+	if cfg.User == "" {
+		v.add("%s.User: is required", name)
+	}
+	if cfg.Password == "" {
+		v.add("%s.Password: is required", name)
+	}
+	if cfg.Database == "" {
+		v.add("%s.Database: is required", name)
+	}
+	for i, replica := range cfg.Replicas {
+		if replica.Host == "" {
+			v.add("%s.Replicas[%d].Host: is required", name, i)
+		}
+		if replica.Port <= 0 || replica.Port > 65535 {
+			v.add("%s.Replicas[%d].Port: %d is not a valid port", name, i, replica.Port)
+		}
+	}
+	if cfg.MaxReplicaLagSeconds < 0 {
+		v.add("%s.MaxReplicaLagSeconds: must not be negative", name)
+	}
+	if cfg.MaxOpenConns < 0 || cfg.MaxIdleConns < 0 || cfg.ConnMaxLifetimeSeconds < 0 {
+		v.add("%s: pool limits must not be negative", name)
+	}
+}
+
+func validateAuth(v *ValidationError, cfg AuthConfig) {
+	grant := func(field string, g Grant) {
+		for _, role := range g.Roles {
+			if _, ok := cfg.Roles[role]; !ok {
+				v.add("%s.%s.Roles: unknown role %q", SectionAuth, field, role)
+			}
+		}
+	}
+	ids := make(map[string]bool)
+	for i, key := range cfg.APIKeys {
+		field := fmt.Sprintf("APIKeys[%d]", i)
+		if key.ID == "" || strings.Contains(key.ID, ".") {
+			v.add("%s.%s.ID: is required and must not contain dots", SectionAuth, field)
+		} else if ids[key.ID] {
+			v.add("%s.%s.ID: %q is used twice", SectionAuth, field, key.ID)
+		}
+		ids[key.ID] = true
+		if decoded, err := hex.DecodeString(key.Hash); err != nil || len(decoded) != sha256.Size {
+			v.add("%s.%s.Hash: must be a hex SHA-256 digest", SectionAuth, field)
+		}
+		grant(field, key.Grant)
+	}
+	for i, key := range cfg.JWT.Keys {
+		field := fmt.Sprintf("%s.JWT.Keys[%d]", SectionAuth, i)
+		switch {
+		case strings.HasPrefix(key.Algorithm, "HS"):
+			if key.Secret == "" {
+				v.add("%s.Secret: is required for %s", field, key.Algorithm)
+			}
+		case strings.HasPrefix(key.Algorithm, "RS"), strings.HasPrefix(key.Algorithm, "ES"), key.Algorithm == "EdDSA":
+			if key.PublicKey == "" {
+				v.add("%s.PublicKey: is required for %s", field, key.Algorithm)
+			}
+		default:
+			v.add("%s.Algorithm: unsupported algorithm %q", field, key.Algorithm)
+		}
+	}
+	for i, cert := range cfg.ClientCerts {
+		field := fmt.Sprintf("ClientCerts[%d]", i)
+		if cert.Subject == "" {
+			v.add("%s.%s.Subject: is required", SectionAuth, field)
+		}
+		grant(field, cert.Grant)
+	}
+	if len(cfg.ClientCerts) > 0 && cfg.TLS.ClientCAFile == "" {
+		v.add("%s.TLS.ClientCAFile: is required to verify client certificates", SectionAuth)
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		v.add("%s.TLS: CertFile and KeyFile must be set together", SectionAuth)
+	}
+	if cfg.TLS.ClientCAFile != "" && cfg.TLS.CertFile == "" {
+		v.add("%s.TLS.CertFile: is required to verify client certificates", SectionAuth)
+	}
+}
+
+func validateRateLimit(v *ValidationError, cfg RateLimitConfig) {
+	for _, r := range []struct {
+		name string
+		rate Rate
+	}{
+		{"Read.PerCaller", cfg.Read.PerCaller},
+		{"Read.PerIP", cfg.Read.PerIP},
+		{"Write.PerCaller", cfg.Write.PerCaller},
+		{"Write.PerIP", cfg.Write.PerIP},
+	} {
+		if r.rate.PerMinute < 0 {
+			v.add("%s.%s.PerMinute: must not be negative", SectionRateLimit, r.name)
+		}
+		if r.rate.PerMinute > 0 && r.rate.Burst <= 0 {
+			v.add("%s.%s.Burst: must be positive", SectionRateLimit, r.name)
+		}
+	}
+	for i, cidr := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			v.add("%s.TrustedProxies[%d]: %q is not a CIDR", SectionRateLimit, i, cidr)
+		}
+	}
+}
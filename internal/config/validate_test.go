@@ -0,0 +1,48 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func validConfig() Config {
+	cfg := Defaults()
+	cfg.Kafka.Brokers = []string{"localhost:9092"}
+	cfg.MySQL.Host = "localhost"
+	cfg.MySQL.User = "root"
+	cfg.MySQL.Password = "secret"
+	cfg.MySQL.Database = "defi"
+	return cfg
+}
+
+func TestValidateOnlyChecksActiveBackends(t *testing.T) {
+	cfg := validConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	// Kafka needs no URL and inactive NATS/Postgres sections are ignored.
+	cfg.Nats = MQConfig{}
+	cfg.Postgres = DBConfig{}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected inactive sections to be ignored, got %v", err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := Defaults()
+	cfg.Backends.MQ = "nats"
+	cfg.Nats.URL = ""
+	cfg.Cache.Type = "redis-cluster"
+
+	err := cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	for _, expected := range []string{"nats.URL", "mysql.Host", "mysql.User", "mysql.Password", "mysql.Database", "redis-cluster.Addrs"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected a problem for %s in %v", expected, verr.Problems)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// Refresher is implemented by providers that cache their source and must
+// re-read it before a reload, such as FileProvider.
+type Refresher interface {
+	Refresh() error
+}
+
+type subscriber struct {
+	name  string
+	apply func(old, next interface{}) error
+}
+
+var (
+	// reloadMu serializes reloads so subscribers see changes in order;
+	// watchMu guards the state below and is never held while they run.
+	reloadMu    sync.Mutex
+	watchMu     sync.Mutex
+	providers   []Provider
+	current     Config
+	subscribers = make(map[string][]subscriber)
+)
+
+// Subscribe registers apply to be called with the previous and the new value
+// of section whenever it changes. apply should build and health-check
+// whatever depends on the config and swap it in only on success, returning
+// an error otherwise. If any subscriber fails, those already applied are
+// called again with the arguments reversed and the old value stays current.
+//
+// T must be the section's config type, e.g. DBConfig for SectionMySQL.
+func Subscribe[T any](section, name string, apply func(old, next T) error) {
+	if s, ok := findSection(section); !ok || sectionType(s) != reflect.TypeOf(*new(T)) {
+		panic(fmt.Sprintf("config: cannot subscribe to %s with %T", section, *new(T)))
+	}
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	subscribers[section] = append(subscribers[section], subscriber{
+		name: name,
+		apply: func(old, next interface{}) error {
+			return apply(old.(T), next.(T))
+		},
+	})
+}
+
+// Reload re-reads every section and notifies subscribers of those that
+// changed. Use it for sources without change notifications, e.g. on SIGHUP
+// after editing the config file.
+func Reload() error {
+	watchMu.Lock()
+	for _, p := range providers {
+		if r, ok := p.(Refresher); ok {
+			if err := r.Refresh(); err != nil {
+				watchMu.Unlock()
+				return fmt.Errorf("%s: %w", p.Name(), err)
+			}
+		}
+	}
+	watchMu.Unlock()
+
+	var failed []error
+	for _, s := range sections {
+		if err := reloadSection(s.name); err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("config reload failed: %v", failed)
+	}
+	return nil
+}
+
+func reloadSection(name string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	s, ok := findSection(name)
+	if !ok {
+		return nil
+	}
+	old, next, subs, err := prepareReload(s)
+	if err != nil || subs == nil {
+		return err
+	}
+
+	for i, sub := range subs {
+		if err := sub.apply(old, next); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if rollbackErr := subs[j].apply(next, old); rollbackErr != nil {
+					log.Printf("Failed to roll back %s config in %s: %v", name, subs[j].name, rollbackErr)
+				}
+			}
+			return fmt.Errorf("%s rejected new %s config, rolled back: %w", sub.name, name, err)
+		}
+	}
+
+	watchMu.Lock()
+	reflect.ValueOf(s.field(&current)).Elem().Set(reflect.ValueOf(next))
+	applied := current
+	watchMu.Unlock()
+	cacheMutex.Lock()
+	appConfig = applied
+	cacheMutex.Unlock()
+	log.Printf("Applied new %s config to %d subscriber(s)", name, len(subs))
+	return nil
+}
+
+// prepareReload loads and validates section s against the current config.
+// It returns the old and new values and the subscribers to notify, or nil
+// subscribers when nothing changed.
+func prepareReload(s section) (old, next interface{}, subs []subscriber, err error) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if providers == nil {
+		return nil, nil, nil, nil
+	}
+	name := s.name
+
+	// Start the section from its defaults so removed fields revert to them,
+	// then validate the whole config since rules depend on other sections.
+	candidate := current
+	defaults := Defaults()
+	target := reflect.ValueOf(s.field(&candidate))
+	target.Elem().Set(reflect.ValueOf(s.field(&defaults)).Elem())
+	if _, err := loadSection(providers, name, target.Interface()); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to reload %s config: %w", name, err)
+	}
+	problems := &ValidationError{}
+	resolveSecrets(target.Interface(), problems)
+	if len(problems.Problems) > 0 {
+		return nil, nil, nil, fmt.Errorf("keeping the current %s config: %w", name, problems)
+	}
+	if err := candidate.Validate(); err != nil {
+		return nil, nil, nil, fmt.Errorf("keeping the current %s config: %w", name, err)
+	}
+	old = reflect.ValueOf(s.field(&current)).Elem().Interface()
+	next = target.Elem().Interface()
+	if reflect.DeepEqual(old, next) {
+		return nil, nil, nil, nil
+	}
+	return old, next, append([]subscriber{}, subscribers[name]...), nil
+}
+
+// onProviderChange is the change callback handed to providers that push
+// updates, such as Nacos.
+func onProviderChange(section string) {
+	if err := reloadSection(section); err != nil {
+		log.Printf("Config change for %s not applied: %v", section, err)
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type staticProvider map[string]string
+
+func (p staticProvider) Name() string { return "static" }
+
+func (p staticProvider) Load(section string, out interface{}) (bool, error) {
+	raw, ok := p[section]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal([]byte(raw), out)
+}
+
+func TestReloadSectionRollsBackOnFailure(t *testing.T) {
+	source := staticProvider{SectionRedisCluster: `{"Addrs": ["b:7000"]}`}
+	providers = []Provider{source}
+	current = validConfig()
+	current.Cache.RedisCluster.Addrs = []string{"a:7000"}
+	defer func() {
+		providers = nil
+		current = Config{}
+		delete(subscribers, SectionRedisCluster)
+	}()
+
+	var applied []string
+	Subscribe(SectionRedisCluster, "first", func(_, next RedisClusterConfig) error {
+		applied = append(applied, next.Addrs[0])
+		return nil
+	})
+	Subscribe(SectionRedisCluster, "second", func(_, next RedisClusterConfig) error {
+		return errors.New("unhealthy")
+	})
+
+	if err := reloadSection(SectionRedisCluster); err == nil {
+		t.Fatal("Expected reload to fail")
+	}
+	if len(applied) != 2 || applied[0] != "b:7000" || applied[1] != "a:7000" {
+		t.Fatalf("Expected apply then rollback, got %v", applied)
+	}
+	if got := current.Cache.RedisCluster; got.Addrs[0] != "a:7000" {
+		t.Fatalf("Expected old config to stay current, got %v", got)
+	}
+}
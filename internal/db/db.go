@@ -4,27 +4,46 @@ import (
 	"context"
 	"database/sql"
 	"defi/internal/config"
-	"fmt"
 	//"github.com/bradfitz/gomemcache/memcache"
 	"github.com/go-redis/redis/v8"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/rainycape/memcache"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// defaultMaxIdleConns matches database/sql's own default.
+const defaultMaxIdleConns = 2
+
 type DB struct {
+	// Driver is the database/sql driver name of SQL: "mysql" or "postgres".
+	Driver string
+	// SQL is the primary. Writes, and reads that must see them, go here.
+	// The pointer stays valid across config reloads; only the connections
+	// behind it are replaced.
 	SQL       *sql.DB
-	Redis     *redis.ClusterClient
 	Memcached *memcache.Client
+
+	mu       sync.RWMutex
+	primary  *swapConnector
+	sqlCfg   config.DBConfig
+	replicas []*Replica
+	redis    *redis.ClusterClient
+	maxLag   time.Duration
+	next     atomic.Uint32
+	done     chan struct{}
 }
 
 func InitDB(sqlCfg config.DBConfig, cacheCfg config.CacheConfig) *DB {
-	// Initialize MySQL with multiple nodes for load balancing and failover
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", sqlCfg.User, sqlCfg.Password, sqlCfg.Host, sqlCfg.Port, sqlCfg.Database)
-	sqlDB, err := sql.Open("mysql", dsn)
+	// Initialize the primary; replicas are added below for read scaling
+	connector, err := newConnector(sqlCfg, sqlCfg.User, sqlCfg.Password, sqlCfg.Host, sqlCfg.Port)
 	if err != nil {
 		log.Fatalf("Failed to open database connection: %v", err)
 	}
+	primary := &swapConnector{connector: connector}
+	sqlDB := sql.OpenDB(primary)
+	applyPool(sqlDB, sqlCfg)
 
 	if err = sqlDB.Ping(); err != nil {
 		log.Fatalf("Failed to ping database: %v", err)
@@ -36,27 +55,95 @@ func InitDB(sqlCfg config.DBConfig, cacheCfg config.CacheConfig) *DB {
 	// Initialize cache based on type
 	switch cacheCfg.Type {
 	case "redis-cluster":
-		rdb = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:    cacheCfg.RedisCluster.Addrs,
-			Password: cacheCfg.RedisCluster.Password,
-		})
-
-		_, err = rdb.Ping(context.Background()).Result()
+		rdb, err = connectRedis(context.Background(), cacheCfg.RedisCluster)
 		if err != nil {
-			log.Fatalf("Failed to connect to Redis cluster: %v", err)
+			// Redis is optional: start degraded, and let the client reconnect
+			// on its own. The redis health check reports it until then.
+			log.Printf("Warning: Redis cluster is unreachable, starting degraded: %v", err)
+			rdb = newRedis(cacheCfg.RedisCluster)
 		}
 	case "memcached":
 		memcached, err = memcache.New(cacheCfg.MemcachedCluster.Addrs...)
 		if err != nil {
 			log.Fatalf("Failed to connect to Memcached: %v", err)
 		}
+	case "", "memory", "none":
+		// No shared cache; callers fall back to an in-process one or none.
 	default:
 		log.Fatalf("Unsupported cache type: %s", cacheCfg.Type)
 	}
 
-	return &DB{
+	database := &DB{
+		Driver:    sqlCfg.Type,
 		SQL:       sqlDB,
-		Redis:     rdb,
 		Memcached: memcached,
+		primary:   primary,
+		sqlCfg:    sqlCfg,
+		replicas:  openReplicas(sqlCfg),
+		redis:     rdb,
+		maxLag:    maxReplicaLag(sqlCfg),
+		done:      make(chan struct{}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+	database.checkReplicas(ctx)
+	cancel()
+	go database.monitorReplicas()
+	return database
+}
+
+// Redis returns the Redis cluster client, or nil when Redis is not the
+// configured cache. The client may be replaced by ReconfigureRedis, so
+// callers should not hold on to it.
+func (d *DB) Redis() *redis.ClusterClient {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.redis
+}
+
+func (d *DB) Close() {
+	close(d.done)
+	if d.SQL != nil {
+		d.SQL.Close()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range d.replicas {
+		r.SQL.Close()
+	}
+	if d.redis != nil {
+		d.redis.Close()
+	}
+}
+
+func connectRedis(ctx context.Context, cfg config.RedisClusterConfig) (*redis.ClusterClient, error) {
+	rdb := newRedis(cfg)
+	if _, err := rdb.Ping(ctx).Result(); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+	return rdb, nil
+}
+
+func newRedis(cfg config.RedisClusterConfig) *redis.ClusterClient {
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:    cfg.Addrs,
+		Password: cfg.Password,
+	})
+}
+
+func applyPool(sqlDB *sql.DB, cfg config.DBConfig) {
+	idle := cfg.MaxIdleConns
+	if idle == 0 {
+		idle = defaultMaxIdleConns
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(idle)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetimeSeconds) * time.Second)
+}
+
+func maxReplicaLag(cfg config.DBConfig) time.Duration {
+	if cfg.MaxReplicaLagSeconds == 0 {
+		return config.DefaultMaxReplicaLag
 	}
+	return time.Duration(cfg.MaxReplicaLagSeconds) * time.Second
 }
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"defi/internal/health"
+	"errors"
+	"fmt"
+	"github.com/rainycape/memcache"
+	"strings"
+)
+
+// healthKey is read from Memcached to check it is reachable; a miss is
+// expected.
+const healthKey = "health:ping"
+
+// HealthChecks returns the checks of every configured backend. Only the
+// primary is critical: replicas fall back to it, and the caches are
+// optional.
+func (d *DB) HealthChecks() []health.Check {
+	checks := []health.Check{{
+		Name:     "database",
+		Critical: true,
+		Run:      d.SQL.PingContext,
+	}}
+	if len(d.Replicas()) > 0 {
+		checks = append(checks, health.Check{Name: "replicas", Run: d.checkReplicaHealth})
+	}
+	if d.Redis() != nil {
+		checks = append(checks, health.Check{
+			Name: "redis",
+			Run: func(ctx context.Context) error {
+				rdb := d.Redis()
+				if rdb == nil {
+					return errors.New("not configured")
+				}
+				return rdb.Ping(ctx).Err()
+			},
+		})
+	}
+	if d.Memcached != nil {
+		checks = append(checks, health.Check{
+			Name: "memcached",
+			Run: func(context.Context) error {
+				_, err := d.Memcached.Get(healthKey)
+				if errors.Is(err, memcache.ErrCacheMiss) {
+					return nil
+				}
+				return err
+			},
+		})
+	}
+	return checks
+}
+
+// checkReplicaHealth reports the replicas that are not serving reads. They
+// are probed in the background, so this only reads the last results, and
+// follows replicas replaced by ReconfigureSQL.
+func (d *DB) checkReplicaHealth(context.Context) error {
+	var problems []string
+	for _, r := range d.Replicas() {
+		switch {
+		case r.Healthy():
+		case r.Lag() > 0:
+			problems = append(problems, fmt.Sprintf("%s lags %s behind primary", r.Name, r.Lag()))
+		default:
+			problems = append(problems, r.Name+" is unreachable")
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
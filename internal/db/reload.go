@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"defi/internal/config"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"log"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	reconfigureTimeout = 10 * time.Second
+	// replicaDrainDelay is how long replaced replicas stay open, so reads
+	// that picked one just before a reconfiguration can finish.
+	replicaDrainDelay = time.Minute
+)
+
+// swapConnector lets a *sql.DB switch endpoints or credentials without the
+// pointer changing: new connections use the current connector. Connections
+// are tagged with the generation of the connector that made them, and those
+// of an older one are discarded, whether idle or in use, once they are back
+// in the pool.
+type swapConnector struct {
+	mu         sync.RWMutex
+	connector  driver.Connector
+	generation uint64
+}
+
+func (c *swapConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.RLock()
+	connector, generation := c.connector, c.generation
+	c.mu.RUnlock()
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &generationConn{Conn: conn, generation: generation, owner: c}, nil
+}
+
+func (c *swapConnector) Driver() driver.Driver {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connector.Driver()
+}
+
+func (c *swapConnector) swap(connector driver.Connector) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connector = connector
+	c.generation++
+}
+
+func (c *swapConnector) stale(generation uint64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return generation != c.generation
+}
+
+// generationConn forwards to the driver's connection, including the optional
+// interfaces database/sql looks for, and reports itself invalid once its
+// connector has been swapped out.
+type generationConn struct {
+	driver.Conn
+	generation uint64
+	owner      *swapConnector
+}
+
+func (c *generationConn) IsValid() bool {
+	if c.owner.stale(c.generation) {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *generationConn) ResetSession(ctx context.Context) error {
+	if c.owner.stale(c.generation) {
+		return driver.ErrBadConn
+	}
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *generationConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *generationConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	// Drivers without BeginTx only support default options.
+	return c.Conn.Begin()
+}
+
+func (c *generationConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *generationConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *generationConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *generationConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// newConnector builds a connector for the driver named by sqlCfg.Type using
+// the given endpoint and credentials, so it serves primaries and replicas.
+func newConnector(sqlCfg config.DBConfig, user, password, host string, port int) (driver.Connector, error) {
+	switch sqlCfg.Type {
+	case "mysql":
+		cfg := mysql.NewConfig()
+		cfg.User = user
+		cfg.Passwd = password
+		cfg.Net = "tcp"
+		cfg.Addr = fmt.Sprintf("%s:%d", host, port)
+		cfg.DBName = sqlCfg.Database
+		return mysql.NewConnector(cfg)
+	case "postgres":
+		dsn := url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(user, password),
+			Host:   fmt.Sprintf("%s:%d", host, port),
+			Path:   "/" + sqlCfg.Database,
+		}
+		if sqlCfg.SSLMode != "" {
+			dsn.RawQuery = url.Values{"sslmode": {sqlCfg.SSLMode}}.Encode()
+		}
+		return pq.NewConnector(dsn.String())
+	default:
+		return nil, fmt.Errorf("unsupported database type: %s", sqlCfg.Type)
+	}
+}
+
+// ReconfigureSQL applies a changed database config. Pool limits are applied in
+// place. A new primary endpoint or credentials are pinged first and only
+// swapped in when reachable; replicas are rebuilt when their list changes.
+// The ping runs before d.mu is taken, so reads are not blocked by it.
+func (d *DB) ReconfigureSQL(_, next config.DBConfig) error {
+	d.mu.RLock()
+	prev := d.sqlCfg
+	d.mu.RUnlock()
+
+	if next.Type != prev.Type {
+		return fmt.Errorf("switching database type from %s to %s requires a restart", prev.Type, next.Type)
+	}
+	var connector driver.Connector
+	if next.Host != prev.Host || next.Port != prev.Port || next.User != prev.User ||
+		next.Password != prev.Password || next.Database != prev.Database {
+		var err error
+		connector, err = newConnector(next, next.User, next.Password, next.Host, next.Port)
+		if err != nil {
+			return err
+		}
+		probe := sql.OpenDB(connector)
+		ctx, cancel := context.WithTimeout(context.Background(), reconfigureTimeout)
+		err = probe.PingContext(ctx)
+		cancel()
+		probe.Close()
+		if err != nil {
+			return fmt.Errorf("new primary %s:%d failed health check: %w", next.Host, next.Port, err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if connector != nil {
+		// Connections to the old primary are closed as they come back to the
+		// pool; dropping the idle ones now closes most of them right away.
+		d.primary.swap(connector)
+		d.SQL.SetMaxIdleConns(-1)
+		log.Printf("Switched primary database to %s:%d", next.Host, next.Port)
+	}
+	applyPool(d.SQL, next)
+
+	if !reflect.DeepEqual(next.Replicas, prev.Replicas) || next.Database != prev.Database {
+		old := d.replicas
+		d.replicas = openReplicas(next)
+		time.AfterFunc(replicaDrainDelay, func() {
+			for _, r := range old {
+				r.SQL.Close()
+			}
+		})
+		log.Printf("Reconfigured %d read replica(s)", len(d.replicas))
+	}
+	d.maxLag = maxReplicaLag(next)
+	d.sqlCfg = next
+	return nil
+}
+
+// ReconfigureRedis connects to the new cluster and swaps it in only if it
+// answers a ping.
+func (d *DB) ReconfigureRedis(_, next config.RedisClusterConfig) error {
+	d.mu.RLock()
+	active := d.redis != nil
+	d.mu.RUnlock()
+	if !active {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reconfigureTimeout)
+	defer cancel()
+	rdb, err := connectRedis(ctx, next)
+	if err != nil {
+		return fmt.Errorf("new Redis cluster failed health check: %w", err)
+	}
+
+	d.mu.Lock()
+	old := d.redis
+	d.redis = rdb
+	d.mu.Unlock()
+	old.Close()
+	log.Printf("Switched Redis cluster to %v", next.Addrs)
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+// fakeConnector makes connections that only know which connector made them.
+type fakeConnector struct {
+	name string
+}
+
+type fakeConn struct {
+	from string
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{from: c.name}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver { return nil }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func TestSwapConnectorRetiresOldConnections(t *testing.T) {
+	ctx := context.Background()
+	primary := &swapConnector{connector: &fakeConnector{name: "old"}}
+	sqlDB := sql.OpenDB(primary)
+	defer sqlDB.Close()
+	from := func(conn *sql.Conn) string {
+		var name string
+		conn.Raw(func(dc interface{}) error {
+			name = dc.(*generationConn).Conn.(*fakeConn).from
+			return nil
+		})
+		return name
+	}
+
+	// A connection checked out across the swap must not be reused after it.
+	inUse, err := sqlDB.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.swap(&fakeConnector{name: "new"})
+	if got := from(inUse); got != "old" {
+		t.Fatalf("checked out connection: got %s", got)
+	}
+	inUse.Close()
+
+	for i := 0; i < 3; i++ {
+		conn, err := sqlDB.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := from(conn); got != "new" {
+			t.Errorf("connection %d is to the %s primary", i, got)
+		}
+		conn.Close()
+	}
+}
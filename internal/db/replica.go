@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"defi/internal/config"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const replicaCheckInterval = 5 * time.Second
+
+// Replica is a read-only database node whose health and replication lag are
+// tracked in the background.
+type Replica struct {
+	Name    string
+	SQL     *sql.DB
+	healthy atomic.Bool
+	lag     atomic.Int64
+}
+
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+func (r *Replica) Lag() time.Duration {
+	return time.Duration(r.lag.Load())
+}
+
+func openReplicas(sqlCfg config.DBConfig) []*Replica {
+	var replicas []*Replica
+	for _, rc := range sqlCfg.Replicas {
+		user, password := rc.User, rc.Password
+		if user == "" {
+			user, password = sqlCfg.User, sqlCfg.Password
+		}
+		connector, err := newConnector(sqlCfg, user, password, rc.Host, rc.Port)
+		if err != nil {
+			log.Printf("Skipping replica %s:%d: %v", rc.Host, rc.Port, err)
+			continue
+		}
+		sqlDB := sql.OpenDB(connector)
+		applyPool(sqlDB, sqlCfg)
+		replicas = append(replicas, &Replica{
+			Name: fmt.Sprintf("%s:%d", rc.Host, rc.Port),
+			SQL:  sqlDB,
+		})
+	}
+	return replicas
+}
+
+// ReadSQL returns the connection read-only queries should use: a healthy
+// replica, chosen round-robin, or the primary when none is available.
+func (d *DB) ReadSQL() *sql.DB {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	n := len(d.replicas)
+	for i := 0; i < n; i++ {
+		r := d.replicas[int(d.next.Add(1)-1)%n]
+		if r.Healthy() {
+			return r.SQL
+		}
+	}
+	return d.SQL
+}
+
+// Replicas returns the configured read replicas.
+func (d *DB) Replicas() []*Replica {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.replicas
+}
+
+func (d *DB) checkReplicas(ctx context.Context) {
+	d.mu.RLock()
+	replicas, maxLag := d.replicas, d.maxLag
+	d.mu.RUnlock()
+	for _, r := range replicas {
+		lag, err := replicaLag(ctx, d.Driver, r.SQL)
+		wasHealthy := r.Healthy()
+		healthy := err == nil && lag <= maxLag
+		r.lag.Store(int64(lag))
+		r.healthy.Store(healthy)
+
+		switch {
+		case err != nil && wasHealthy:
+			log.Printf("Replica %s is unhealthy, reads fail over: %v", r.Name, err)
+		case err == nil && !healthy && wasHealthy:
+			log.Printf("Replica %s lags %s behind primary, reads fail over", r.Name, lag)
+		case healthy && !wasHealthy:
+			log.Printf("Replica %s is serving reads (lag %s)", r.Name, lag)
+		}
+	}
+}
+
+func (d *DB) monitorReplicas() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+			d.checkReplicas(ctx)
+			cancel()
+		}
+	}
+}
+
+// errNotReplica marks a configured replica that is not replicating from
+// anything, so its data may be arbitrarily stale.
+var errNotReplica = errors.New("node is not a replica")
+
+// replicaLag reports how far a replica is behind its source.
+func replicaLag(ctx context.Context, driver string, sqlDB *sql.DB) (time.Duration, error) {
+	if driver == "postgres" {
+		return postgresReplicaLag(ctx, sqlDB)
+	}
+	rows, err := sqlDB.QueryContext(ctx, "SHOW REPLICA STATUS")
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1064 {
+		// MySQL before 8.0.22 only knows the old name.
+		rows, err = sqlDB.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read replica status: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errNotReplica
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, fmt.Errorf("failed to scan replica status: %w", err)
+	}
+
+	for i, col := range columns {
+		if !strings.EqualFold(col, "Seconds_Behind_Source") && !strings.EqualFold(col, "Seconds_Behind_Master") {
+			continue
+		}
+		if values[i] == nil {
+			return 0, fmt.Errorf("replication is not running")
+		}
+		var seconds int64
+		if _, err := fmt.Sscan(string(values[i]), &seconds); err != nil {
+			return 0, fmt.Errorf("invalid replica lag %q: %w", values[i], err)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("replica status has no lag column")
+}
+
+func postgresReplicaLag(ctx context.Context, sqlDB *sql.DB) (time.Duration, error) {
+	// A standby that has replayed everything reports the time since the last
+	// transaction as lag, so treat a caught-up standby as current.
+	query := `SELECT pg_is_in_recovery(), CASE
+    WHEN NOT pg_is_in_recovery() THEN 0
+    WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+    ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+	var (
+		standby bool
+		seconds float64
+	)
+	if err := sqlDB.QueryRowContext(ctx, query).Scan(&standby, &seconds); err != nil {
+		return 0, fmt.Errorf("failed to read replica status: %w", err)
+	}
+	if !standby {
+		return 0, errNotReplica
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -1,6 +1,7 @@
 package eventbus
 
 import (
+	"context"
 	"defi/internal/config"
 	"defi/internal/model"
 )
@@ -8,10 +9,13 @@ import (
 type EventBus interface {
 	PublishEvent(topic string, event []byte) error
 	ConsumerEvent(topic string, handler func(event model.Event)) error
+	// Ping checks the broker is reachable.
+	Ping(ctx context.Context) error
+	Close() error
 }
 
-func InitEventBus(cfg config.MQConfig) EventBus {
-	mqEventBus, err := NewEventBus(cfg)
+func InitEventBus(cfg config.MQConfig) *ReloadableEventBus {
+	mqEventBus, err := NewReloadableEventBus(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create EventBus: %v", err)
 	}
@@ -1,16 +1,18 @@
 package eventbus
 
 import (
+	"context"
 	"defi/internal/model"
 	"fmt"
 	"github.com/Shopify/sarama"
-	stdlog "log"
 	"time"
 )
 
 type KafkaEventBus struct {
 	producer sarama.AsyncProducer
 	consumer sarama.Consumer
+	// client backs the consumer, and is used to check the brokers.
+	client sarama.Client
 }
 
 func NewKafkaEventBus(brokers []string) (EventBus, error) {
@@ -19,24 +21,32 @@ func NewKafkaEventBus(brokers []string) (EventBus, error) {
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Retry.Max = 5
 	config.Producer.Idempotent = true
+	config.Net.MaxOpenRequests = 1 // Required by the idempotent producer
 	config.Producer.Flush.Frequency = 500 * time.Millisecond
 	config.Producer.Flush.Messages = 100
 	config.Producer.Flush.MaxMessages = 1000
 
 	producer, err := sarama.NewAsyncProducer(brokers, config)
 	if err != nil {
-		stdlog.Fatalf("Failed to start Sarama producer: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to start Sarama producer: %w", err)
 	}
 
-	consumer, err := sarama.NewConsumer(brokers, config)
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		producer.AsyncClose()
+		return nil, err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
 	if err != nil {
+		producer.AsyncClose()
+		client.Close()
 		return nil, err
 	}
 
 	return &KafkaEventBus{
 		producer: producer,
 		consumer: consumer,
+		client:   client,
 	}, nil
 }
 
@@ -83,3 +93,24 @@ func (eb *KafkaEventBus) ConsumerEvent(topic string, handler func(event model.Ev
 
 	return nil
 }
+
+// Ping fetches cluster metadata, which fails when no broker is reachable.
+func (eb *KafkaEventBus) Ping(ctx context.Context) error {
+	if err := eb.client.RefreshMetadata(); err != nil {
+		return fmt.Errorf("kafka metadata error: %w", err)
+	}
+	return nil
+}
+
+func (eb *KafkaEventBus) Close() error {
+	producerErr := eb.producer.Close()
+	consumerErr := eb.consumer.Close()
+	eb.client.Close()
+	if producerErr != nil {
+		return fmt.Errorf("kafka producer close error: %w", producerErr)
+	}
+	if consumerErr != nil {
+		return fmt.Errorf("kafka consumer close error: %w", consumerErr)
+	}
+	return nil
+}
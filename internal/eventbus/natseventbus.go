@@ -1,6 +1,7 @@
 package eventbus
 
 import (
+	"context"
 	"defi/internal/model"
 	"fmt"
 	"github.com/nats-io/nats.go"
@@ -41,3 +42,16 @@ func (eb *NatsEventBus) ConsumerEvent(topic string, handler func(event model.Eve
 	}
 	return nil
 }
+
+// Ping round-trips to the server, so it also fails while reconnecting.
+func (eb *NatsEventBus) Ping(ctx context.Context) error {
+	if err := eb.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("NATS %s: %w", eb.conn.Status(), err)
+	}
+	return nil
+}
+
+func (eb *NatsEventBus) Close() error {
+	eb.conn.Close()
+	return nil
+}
@@ -0,0 +1,95 @@
+package eventbus
+
+import (
+	"context"
+	"defi/internal/config"
+	"defi/internal/health"
+	"defi/internal/model"
+	"fmt"
+	"sync"
+)
+
+// ReloadableEventBus forwards to an EventBus that is rebuilt when its
+// configuration changes. Subscriptions are replayed onto the new bus, so
+// consumers survive a broker change.
+type ReloadableEventBus struct {
+	mu            sync.RWMutex
+	bus           EventBus
+	subscriptions []subscription
+}
+
+type subscription struct {
+	topic   string
+	handler func(event model.Event)
+}
+
+func NewReloadableEventBus(cfg config.MQConfig) (*ReloadableEventBus, error) {
+	bus, err := NewEventBus(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ReloadableEventBus{bus: bus}, nil
+}
+
+func (eb *ReloadableEventBus) PublishEvent(topic string, event []byte) error {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	return eb.bus.PublishEvent(topic, event)
+}
+
+func (eb *ReloadableEventBus) ConsumerEvent(topic string, handler func(event model.Event)) error {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if err := eb.bus.ConsumerEvent(topic, handler); err != nil {
+		return err
+	}
+	eb.subscriptions = append(eb.subscriptions, subscription{topic: topic, handler: handler})
+	return nil
+}
+
+// Ping does not hold the lock while it waits on the broker, so a slow
+// check never delays a reconfiguration.
+func (eb *ReloadableEventBus) Ping(ctx context.Context) error {
+	eb.mu.RLock()
+	bus := eb.bus
+	eb.mu.RUnlock()
+	return bus.Ping(ctx)
+}
+
+// HealthCheck reports whether the current bus reaches its broker. It is not
+// critical: the outbox holds events until the bus is back.
+func (eb *ReloadableEventBus) HealthCheck() health.Check {
+	return health.Check{Name: "event bus", Run: eb.Ping}
+}
+
+func (eb *ReloadableEventBus) Close() error {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	return eb.bus.Close()
+}
+
+// Reconfigure connects a bus for next and moves every subscription onto it.
+// Connecting doubles as the health check: if it or any subscription fails,
+// the new bus is discarded and the current one keeps running.
+func (eb *ReloadableEventBus) Reconfigure(_, next config.MQConfig) error {
+	bus, err := NewEventBus(next)
+	if err != nil {
+		return err
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	for _, sub := range eb.subscriptions {
+		if err := bus.ConsumerEvent(sub.topic, sub.handler); err != nil {
+			bus.Close()
+			return fmt.Errorf("failed to resubscribe %s: %w", sub.topic, err)
+		}
+	}
+
+	previous := eb.bus
+	eb.bus = bus
+	if err := previous.Close(); err != nil {
+		logWarning(next.Type, "", err)
+	}
+	return nil
+}
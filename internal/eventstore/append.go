@@ -0,0 +1,169 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrConcurrencyConflict is returned by AppendEvents when the aggregate was
+// changed since it was loaded. Callers should reload and retry.
+var ErrConcurrencyConflict = errors.New("concurrency conflict")
+
+// ErrAggregateTypeMismatch is returned by AppendEvents when events name a
+// different aggregate type than the stream they are appended to.
+var ErrAggregateTypeMismatch = errors.New("aggregate type mismatch")
+
+// NextVersion as an expected version appends after whatever version the
+// aggregate is at, for events that do not depend on its state.
+const NextVersion int64 = -1
+
+// Append is one aggregate's share of an AppendBatch.
+type Append struct {
+	AggregateID     string
+	ExpectedVersion int64
+	Events          []model.Event
+}
+
+// AppendEvents atomically appends events to aggregateID provided its current
+// version is expectedVersion, numbering them expectedVersion+1 onwards. When
+// OutboxTopic is set, an outbox row per event is written in the same
+// transaction for OutboxRelay to publish.
+func (es *BaseEventStore) AppendEvents(ctx context.Context, aggregateID string, expectedVersion int64, events []model.Event) error {
+	return es.AppendBatch(ctx, []Append{{AggregateID: aggregateID, ExpectedVersion: expectedVersion, Events: events}})
+}
+
+// AppendBatch is AppendEvents for several aggregates in one transaction:
+// either every append succeeds or none does.
+func (es *BaseEventStore) AppendBatch(ctx context.Context, appends []Append) error {
+	tx, err := es.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin append: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock aggregates in a fixed order so concurrent batches over the same
+	// aggregates conflict instead of deadlocking.
+	ordered := append([]Append(nil), appends...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].AggregateID < ordered[j].AggregateID })
+	for _, a := range ordered {
+		if err := es.appendTx(ctx, tx, a); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit append: %w", err)
+	}
+	return nil
+}
+
+func (es *BaseEventStore) appendTx(ctx context.Context, tx *sql.Tx, a Append) error {
+	if len(a.Events) == 0 {
+		return nil
+	}
+
+	var current int64
+	query := `SELECT COALESCE(MAX(version), 0) FROM events WHERE aggregate_id = ?`
+	if err := tx.QueryRowContext(ctx, es.dialect.rebind(query), a.AggregateID).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read version of %s: %w", a.AggregateID, err)
+	}
+	if a.ExpectedVersion == NextVersion {
+		a.ExpectedVersion = current
+	}
+	if current != a.ExpectedVersion {
+		return fmt.Errorf("%w: %s is at version %d, expected %d", ErrConcurrencyConflict, a.AggregateID, current, a.ExpectedVersion)
+	}
+	aggregateType := a.Events[0].AggregateType
+	if current > 0 {
+		query := `SELECT aggregate_type FROM events WHERE aggregate_id = ? AND version = 1`
+		if err := tx.QueryRowContext(ctx, es.dialect.rebind(query), a.AggregateID).Scan(&aggregateType); err != nil {
+			return fmt.Errorf("failed to read type of %s: %w", a.AggregateID, err)
+		}
+	}
+	if err := checkAggregateType(a, aggregateType); err != nil {
+		return err
+	}
+
+	for i := range a.Events {
+		event := &a.Events[i]
+		event.AggregateID = a.AggregateID
+		event.Version = a.ExpectedVersion + int64(i) + 1
+		metadata, err := encodeMetadata(event.Metadata)
+		if err != nil {
+			return err
+		}
+		event.Position, err = es.insertTx(ctx, tx, *event, metadata)
+		if IsUniqueViolation(err) {
+			// A concurrent writer took this version between our read and insert.
+			return fmt.Errorf("%w: %s version %d already exists", ErrConcurrencyConflict, a.AggregateID, event.Version)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to append event: %w", err)
+		}
+		if es.OutboxTopic != nil {
+			if err := es.enqueueOutbox(ctx, tx, *event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkAggregateType fails unless every event of a names aggregateType, the
+// type of the stream it is appended to.
+func checkAggregateType(a Append, aggregateType string) error {
+	for _, event := range a.Events {
+		if event.AggregateType != aggregateType {
+			return fmt.Errorf("%w: %s is a %s, not a %s", ErrAggregateTypeMismatch, a.AggregateID, aggregateType, event.AggregateType)
+		}
+	}
+	return nil
+}
+
+// insertTx inserts event and returns the position the database assigned
+// it, so outbox payloads carry it.
+func (es *BaseEventStore) insertTx(ctx context.Context, tx *sql.Tx, event model.Event, metadata interface{}) (int64, error) {
+	args := []interface{}{event.ID, event.AggregateID, event.AggregateType, event.Version, event.Type, event.Data, metadata, event.Timestamp}
+	if es.dialect == dialectPostgres {
+		var position int64
+		err := tx.QueryRowContext(ctx, es.dialect.rebind(insertEventQuery)+" RETURNING position", args...).Scan(&position)
+		return position, err
+	}
+	res, err := tx.ExecContext(ctx, insertEventQuery, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// LoadEvents returns the events of aggregateID after afterVersion, in order.
+// Unlike GetEvents it always reads the primary, since command handlers must
+// see their own writes.
+func (es *BaseEventStore) LoadEvents(ctx context.Context, aggregateID string, afterVersion int64) ([]model.Event, error) {
+	query := `SELECT ` + eventColumns + ` FROM events WHERE aggregate_id = ? AND version > ? ORDER BY version`
+	rows, err := es.Db.QueryContext(ctx, es.dialect.rebind(query), aggregateID, afterVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load events: %w", err)
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+func (es *BaseEventStore) enqueueOutbox(ctx context.Context, tx *sql.Tx, event model.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox event: %w", err)
+	}
+	query := `INSERT INTO outbox (event_id, topic, payload, created_at) VALUES (?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, es.dialect.rebind(query), event.ID, es.OutboxTopic(event), string(payload), time.Now().UnixMilli()); err != nil {
+		return fmt.Errorf("failed to enqueue outbox event: %w", err)
+	}
+	return nil
+}
@@ -3,19 +3,63 @@ package eventstore
 import (
 	"database/sql"
 	"defi/internal/model"
+	"encoding/json"
+	"errors"
 	"fmt"
 )
 
+// ErrEventNotFound is returned by GetEvent for unknown event IDs.
+var ErrEventNotFound = errors.New("event not found")
+
+const insertEventQuery = `INSERT INTO events (id, aggregate_id, aggregate_type, version, type, data, metadata, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+const eventColumns = "position, id, aggregate_id, aggregate_type, version, type, data, metadata, timestamp"
+
+// ReadRouter picks the connection for read-only queries, typically a replica.
+type ReadRouter interface {
+	ReadSQL() *sql.DB
+}
+
 type BaseEventStore struct {
 	Db *sql.DB
+	// Reads routes history and projection queries. When nil they go to Db.
+	Reads ReadRouter
+	// OutboxTopic, when set, makes AppendEvents record each event in the
+	// outbox under the returned topic.
+	OutboxTopic func(model.Event) string
+	dialect     dialect
 }
 
 func InitEventStore(db *sql.DB) *BaseEventStore {
 	return &BaseEventStore{Db: db}
 }
+
+// NewEventStore returns a store over db for the named database/sql driver,
+// "mysql" or "postgres".
+func NewEventStore(db *sql.DB, driver string) (*BaseEventStore, error) {
+	switch driver {
+	case "mysql":
+		return &BaseEventStore{Db: db, dialect: dialectMySQL}, nil
+	case "postgres":
+		return &BaseEventStore{Db: db, dialect: dialectPostgres}, nil
+	default:
+		return nil, fmt.Errorf("unsupported event store driver: %s", driver)
+	}
+}
+
+func (es *BaseEventStore) readDB() *sql.DB {
+	if es.Reads == nil {
+		return es.Db
+	}
+	return es.Reads.ReadSQL()
+}
+
 func (es *BaseEventStore) SaveEvent(event model.Event) error {
-	query := `INSERT INTO events (id, aggregate_id, type, data, timestamp) VALUES (?, ?, ?, ?, ?)`
-	_, err := es.Db.Exec(query, event.ID, event.AggregateID, event.Type, event.Data, event.Timestamp)
+	metadata, err := encodeMetadata(event.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to save event: %w", err)
+	}
+	_, err = es.Db.Exec(es.dialect.rebind(insertEventQuery), event.ID, event.AggregateID, event.AggregateType, nullVersion(event.Version), event.Type, event.Data, metadata, event.Timestamp)
 	if err != nil {
 		return fmt.Errorf("failed to save event: %w", err)
 	}
@@ -23,40 +67,84 @@ func (es *BaseEventStore) SaveEvent(event model.Event) error {
 }
 
 func (es *BaseEventStore) GetEvents(aggregateID string) ([]model.Event, error) {
-	query := `SELECT id, aggregate_id, type, data, timestamp FROM events WHERE aggregate_id = ?`
-	rows, err := es.Db.Query(query, aggregateID)
+	query := `SELECT ` + eventColumns + ` FROM events WHERE aggregate_id = ? ORDER BY position`
+	rows, err := es.readDB().Query(es.dialect.rebind(query), aggregateID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get events: %w", err)
 	}
 	defer rows.Close()
 
-	var events []model.Event
-	for rows.Next() {
-		var event model.Event
-		if err := rows.Scan(&event.ID, &event.AggregateID, &event.Type, &event.Data, &event.Timestamp); err != nil {
-			return nil, fmt.Errorf("failed to scan event: %w", err)
-		}
-		events = append(events, event)
+	return scanEvents(rows)
+}
+
+// GetEvent returns the event with id, or ErrEventNotFound.
+func (es *BaseEventStore) GetEvent(id string) (model.Event, error) {
+	query := `SELECT ` + eventColumns + ` FROM events WHERE id = ?`
+	rows, err := es.readDB().Query(es.dialect.rebind(query), id)
+	if err != nil {
+		return model.Event{}, fmt.Errorf("failed to get event: %w", err)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows iteration error: %w", err)
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return model.Event{}, err
 	}
-	return events, nil
+	if len(events) == 0 {
+		return model.Event{}, fmt.Errorf("%w: %s", ErrEventNotFound, id)
+	}
+	return events[0], nil
 }
+
 func (es *BaseEventStore) QueryEvents(aggregateID string) ([]model.Event, error) {
-	rows, err := es.Db.Query("SELECT * FROM events WHERE aggregate_id = ? ORDER BY timestamp", aggregateID)
+	query := `SELECT ` + eventColumns + ` FROM events WHERE aggregate_id = ? ORDER BY timestamp, position`
+	rows, err := es.readDB().Query(es.dialect.rebind(query), aggregateID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanEvents(rows)
+}
+
+func scanEvents(rows *sql.Rows) ([]model.Event, error) {
 	var events []model.Event
 	for rows.Next() {
 		var event model.Event
-		if err := rows.Scan(&event.ID, &event.AggregateID, &event.Type, &event.Data, &event.Timestamp); err != nil {
-			return nil, err
+		var aggregateType, metadata sql.NullString
+		var version sql.NullInt64
+		if err := rows.Scan(&event.Position, &event.ID, &event.AggregateID, &aggregateType, &version, &event.Type, &event.Data, &metadata, &event.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		event.AggregateType = aggregateType.String
+		event.Version = version.Int64
+		if metadata.Valid && metadata.String != "" {
+			if err := json.Unmarshal([]byte(metadata.String), &event.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to decode metadata of event %s: %w", event.ID, err)
+			}
 		}
 		events = append(events, event)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
 	return events, nil
 }
+
+func encodeMetadata(metadata map[string]string) (interface{}, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return string(b), nil
+}
+
+func nullVersion(version int64) interface{} {
+	if version == 0 {
+		return nil
+	}
+	return version
+}
@@ -0,0 +1,73 @@
+package eventstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"strings"
+)
+
+type dialect int
+
+const (
+	dialectMySQL dialect = iota
+	dialectPostgres
+)
+
+// rebind rewrites the ?-style placeholders used throughout this package into
+// the form expected by the underlying driver.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres {
+		return query
+	}
+	return Rebind("postgres", query)
+}
+
+// Rebind rewrites the ?-style placeholders of query into the form expected
+// by the named database/sql driver, "mysql" or "postgres", for the SQL
+// stores that share this package's drivers.
+func Rebind(driver, query string) string {
+	if driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// metadataFilter returns a condition matching events whose metadata has key
+// set to value, together with its arguments. Postgres uses JSONB containment
+// so the condition can be served by the GIN index on metadata. Keys are
+// always bound, never spliced into the query or the JSON path.
+func (d dialect) metadataFilter(key, value string) (string, []interface{}) {
+	if d == dialectPostgres {
+		doc, _ := json.Marshal(map[string]string{key: value})
+		return "metadata @> ?::jsonb", []interface{}{string(doc)}
+	}
+	// JSON_QUOTE escapes the key the way JSON paths expect.
+	return "JSON_UNQUOTE(JSON_EXTRACT(metadata, CONCAT('$.', JSON_QUOTE(?)))) = ?", []interface{}{key, value}
+}
+
+// IsUniqueViolation reports whether err is a duplicate key error from
+// either driver.
+func IsUniqueViolation(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
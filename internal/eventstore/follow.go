@@ -0,0 +1,133 @@
+package eventstore
+
+import (
+	"context"
+	"defi/internal/model"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SettleTime is how long after its timestamp an event is taken to be
+// committed, on the primary and on replicas, along with every event of a
+// lower position. It must exceed the longest append transaction plus the
+// replica lag allowed before reads fail over.
+var SettleTime = 30 * time.Second
+
+// ErrGap is returned by read models given an event of an aggregate before an
+// earlier one. Follow answers it by replaying the aggregate.
+var ErrGap = errors.New("missing earlier events")
+
+// Follow is ForEach for read models that follow the log from a cursor.
+//
+// Positions are allocated when an event is inserted but become visible when
+// its transaction commits, so an event can turn up behind others already
+// read. Follow therefore only moves the cursor past events older than
+// SettleTime; later ones are passed to fn again on the next call, so fn must
+// skip events it has seen, e.g. by aggregate version.
+//
+// If fn returns ErrGap, every event of the event's aggregate is passed to fn
+// before it carries on, so an event missed despite the settle time is only
+// late, not lost.
+func Follow(ctx context.Context, source Finder, q EventQuery, fn func(model.Event) error) (string, error) {
+	settled := time.Now().Add(-SettleTime).UnixMilli()
+	cursor, holding := q.Cursor, false
+	_, err := ForEach(ctx, source, q, func(event model.Event) error {
+		err := fn(event)
+		if errors.Is(err, ErrGap) {
+			_, err = ForEach(ctx, source, EventQuery{AggregateID: event.AggregateID}, fn)
+		}
+		if err != nil {
+			return err
+		}
+		if event.Timestamp > settled {
+			holding = true
+		}
+		if !holding {
+			cursor = EncodeCursor(event.Position)
+		}
+		return nil
+	})
+	return cursor, err
+}
+
+// ReadModel keeps a state per aggregate of one type, built by applying each
+// aggregate's events in version order. Feed it with Handle or CatchUp;
+// events already seen are ignored, so at-least-once delivery is safe.
+type ReadModel[S any] struct {
+	aggregateType string
+	newState      func() S
+	apply         func(state S, event model.Event) error
+	mu            sync.RWMutex
+	states        map[string]S
+	versions      map[string]int64
+	cursor        string
+}
+
+// NewReadModel returns a read model of aggregateType whose aggregates start
+// as newState and change by apply. apply runs under the read model's lock.
+func NewReadModel[S any](aggregateType string, newState func() S, apply func(state S, event model.Event) error) *ReadModel[S] {
+	return &ReadModel[S]{
+		aggregateType: aggregateType,
+		newState:      newState,
+		apply:         apply,
+		states:        make(map[string]S),
+		versions:      make(map[string]int64),
+	}
+}
+
+// Handle applies event if it is the next of its aggregate and returns ErrGap
+// if an earlier one is missing.
+func (m *ReadModel[S]) Handle(event model.Event) error {
+	if event.AggregateType != m.aggregateType {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := m.versions[event.AggregateID]
+	if event.Version <= seen {
+		return nil
+	}
+	if event.Version > seen+1 {
+		return fmt.Errorf("%w: %s %s at version %d, got %d", ErrGap, m.aggregateType, event.AggregateID, seen, event.Version)
+	}
+	state, ok := m.states[event.AggregateID]
+	if !ok {
+		state = m.newState()
+		m.states[event.AggregateID] = state
+	}
+	if err := m.apply(state, event); err != nil {
+		return fmt.Errorf("failed to project %s event %d of %s: %w", event.Type, event.Version, event.AggregateID, err)
+	}
+	m.versions[event.AggregateID] = event.Version
+	return nil
+}
+
+// CatchUp applies the events stored since the last CatchUp.
+func (m *ReadModel[S]) CatchUp(ctx context.Context, source Finder) error {
+	m.mu.RLock()
+	cursor := m.cursor
+	m.mu.RUnlock()
+
+	cursor, err := Follow(ctx, source, EventQuery{AggregateType: m.aggregateType, Cursor: cursor}, m.Handle)
+	m.mu.Lock()
+	m.cursor = cursor
+	m.mu.Unlock()
+	return err
+}
+
+// View calls fn with the state of aggregateID and its version under the
+// read lock, and reports whether any of its events have been applied. fn
+// must not keep state.
+func (m *ReadModel[S]) View(aggregateID string, fn func(state S, version int64)) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state, ok := m.states[aggregateID]
+	if !ok {
+		return false
+	}
+	fn(state, m.versions[aggregateID])
+	return true
+}
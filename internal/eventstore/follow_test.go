@@ -0,0 +1,115 @@
+package eventstore
+
+import (
+	"context"
+	"defi/internal/model"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// visible is a Finder over the events committed so far, which need not be
+// every position up to the highest.
+type visible []model.Event
+
+func (v visible) FindEvents(q EventQuery) (EventPage, error) {
+	after, _ := DecodeCursor(q.Cursor)
+	var page EventPage
+	for _, event := range v {
+		if event.Position > after && (q.AggregateID == "" || event.AggregateID == q.AggregateID) {
+			page.Events = append(page.Events, event)
+		}
+	}
+	sort.Slice(page.Events, func(i, j int) bool { return page.Events[i].Position < page.Events[j].Position })
+	return page, nil
+}
+
+func TestFollow(t *testing.T) {
+	ctx := context.Background()
+	old := time.Now().Add(-time.Hour).UnixMilli()
+	event := func(position int64, aggregateID string, version int64, at int64) model.Event {
+		return model.Event{Position: position, AggregateID: aggregateID, Version: version, Timestamp: at}
+	}
+	// A read model that applies each aggregate's events in version order.
+	versions := make(map[string]int64)
+	var applied []string
+	handle := func(e model.Event) error {
+		switch {
+		case e.Version <= versions[e.AggregateID]:
+			return nil
+		case e.Version > versions[e.AggregateID]+1:
+			return fmt.Errorf("%w: %s", ErrGap, e.AggregateID)
+		}
+		versions[e.AggregateID] = e.Version
+		applied = append(applied, fmt.Sprintf("%s%d", e.AggregateID, e.Version))
+		return nil
+	}
+
+	// Position 2 is still being committed when 3 is read.
+	log := visible{event(1, "a", 1, old), event(3, "b", 1, time.Now().UnixMilli())}
+	cursor, err := Follow(ctx, log, EventQuery{}, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != EncodeCursor(1) {
+		t.Errorf("cursor moved past an unsettled event: %s", cursor)
+	}
+	log = append(log, event(2, "c", 1, old))
+	if cursor, err = Follow(ctx, log, EventQuery{Cursor: cursor}, handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if cursor != EncodeCursor(2) {
+		t.Errorf("cursor = %s, want position 2 until b1 settles", cursor)
+	}
+
+	// An event that commits after the cursor has passed its position is
+	// caught by the gap in its aggregate's versions.
+	log = append(log, event(5, "a", 3, old))
+	if _, err := Follow(ctx, log, EventQuery{Cursor: EncodeCursor(4)}, handle); err == nil {
+		t.Fatal("expected a gap while a2 is missing")
+	}
+	log = append(log, event(4, "a", 2, old))
+	if _, err := Follow(ctx, log, EventQuery{Cursor: EncodeCursor(4)}, handle); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a1", "b1", "c1", "a2", "a3"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied %v, want %v", applied, want)
+	}
+}
+
+func TestReadModel(t *testing.T) {
+	old := time.Now().Add(-time.Hour).UnixMilli()
+	event := func(position int64, aggregateID string, version int64) model.Event {
+		return model.Event{Position: position, AggregateType: "counter", AggregateID: aggregateID, Version: version, Timestamp: old}
+	}
+	counters := NewReadModel("counter", func() *[]int64 { return new([]int64) }, func(seen *[]int64, e model.Event) error {
+		*seen = append(*seen, e.Version)
+		return nil
+	})
+
+	if err := counters.Handle(event(2, "a", 2)); !errors.Is(err, ErrGap) {
+		t.Fatalf("Handle before version 1 = %v, want ErrGap", err)
+	}
+	log := visible{event(1, "a", 1), event(2, "a", 2), event(3, "b", 1)}
+	if err := counters.Handle(event(1, "a", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := counters.CatchUp(context.Background(), log); err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	var version int64
+	if !counters.View("a", func(seen *[]int64, v int64) { got, version = *seen, v }) {
+		t.Fatal("a not found")
+	}
+	if !reflect.DeepEqual(got, []int64{1, 2}) || version != 2 {
+		t.Errorf("a applied %v at version %d, want [1 2] at 2", got, version)
+	}
+	if counters.View("c", func(*[]int64, int64) {}) {
+		t.Error("View found an aggregate without events")
+	}
+}
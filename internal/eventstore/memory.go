@@ -0,0 +1,171 @@
+package eventstore
+
+import (
+	"context"
+	"defi/internal/model"
+	"fmt"
+	"sync"
+)
+
+// MemoryEventStore keeps events in memory with the same versioning rules as
+// AppendEvents. It is meant for tests and simulations.
+type MemoryEventStore struct {
+	mu       sync.Mutex
+	events   map[string][]model.Event
+	log      []model.Event
+	position int64
+}
+
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{events: make(map[string][]model.Event)}
+}
+
+func (s *MemoryEventStore) AppendEvents(ctx context.Context, aggregateID string, expectedVersion int64, events []model.Event) error {
+	return s.AppendBatch(ctx, []Append{{AggregateID: aggregateID, ExpectedVersion: expectedVersion, Events: events}})
+}
+
+func (s *MemoryEventStore) AppendBatch(ctx context.Context, appends []Append) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	appends = append([]Append(nil), appends...)
+	for i := range appends {
+		a := &appends[i]
+		if len(a.Events) == 0 {
+			continue
+		}
+		if a.ExpectedVersion == NextVersion {
+			a.ExpectedVersion = int64(len(s.events[a.AggregateID]))
+		}
+		if current := int64(len(s.events[a.AggregateID])); current != a.ExpectedVersion {
+			return fmt.Errorf("%w: %s is at version %d, expected %d", ErrConcurrencyConflict, a.AggregateID, current, a.ExpectedVersion)
+		}
+		aggregateType := a.Events[0].AggregateType
+		if stored := s.events[a.AggregateID]; len(stored) > 0 {
+			aggregateType = stored[0].AggregateType
+		}
+		if err := checkAggregateType(*a, aggregateType); err != nil {
+			return err
+		}
+	}
+	for _, a := range appends {
+		for i := range a.Events {
+			s.position++
+			event := &a.Events[i]
+			event.AggregateID = a.AggregateID
+			event.Version = a.ExpectedVersion + int64(i) + 1
+			event.Position = s.position
+			s.events[a.AggregateID] = append(s.events[a.AggregateID], *event)
+			s.log = append(s.log, *event)
+		}
+	}
+	return nil
+}
+
+// SaveEvent records an event outside any aggregate's version sequence, like
+// BaseEventStore.SaveEvent.
+func (s *MemoryEventStore) SaveEvent(event model.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.position++
+	event.Version = 0
+	event.Position = s.position
+	s.log = append(s.log, event)
+	return nil
+}
+
+// GetEvent returns the event with id, or ErrEventNotFound.
+func (s *MemoryEventStore) GetEvent(id string) (model.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range s.log {
+		if event.ID == id {
+			return event, nil
+		}
+	}
+	return model.Event{}, fmt.Errorf("%w: %s", ErrEventNotFound, id)
+}
+
+func (s *MemoryEventStore) LoadEvents(ctx context.Context, aggregateID string, afterVersion int64) ([]model.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.events[aggregateID]
+	if afterVersion >= int64(len(stored)) {
+		return nil, nil
+	}
+	if afterVersion < 0 {
+		afterVersion = 0
+	}
+	return append([]model.Event(nil), stored[afterVersion:]...), nil
+}
+
+// FindEvents supports the AggregateID, AggregateType, Types, Since, Until,
+// Metadata, Cursor, Limit and Order fields of q.
+func (s *MemoryEventStore) FindEvents(q EventQuery) (EventPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+	var after int64
+	if q.Cursor != "" {
+		position, err := DecodeCursor(q.Cursor)
+		if err != nil {
+			return EventPage{}, err
+		}
+		after = position
+	}
+	desc := q.Order == OrderDesc
+
+	var page EventPage
+	for i := range s.log {
+		event := s.log[i]
+		if desc {
+			event = s.log[len(s.log)-1-i]
+		}
+		if q.Cursor != "" && (!desc && event.Position <= after || desc && event.Position >= after) {
+			continue
+		}
+		if !matchesQuery(event, q) {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.NextCursor = EncodeCursor(page.Events[limit-1].Position)
+			break
+		}
+		page.Events = append(page.Events, event)
+	}
+	return page, nil
+}
+
+func matchesQuery(event model.Event, q EventQuery) bool {
+	if q.AggregateID != "" && event.AggregateID != q.AggregateID {
+		return false
+	}
+	if q.AggregateType != "" && event.AggregateType != q.AggregateType {
+		return false
+	}
+	if q.Since > 0 && event.Timestamp < q.Since || q.Until > 0 && event.Timestamp >= q.Until {
+		return false
+	}
+	for k, v := range q.Metadata {
+		if event.Metadata[k] != v {
+			return false
+		}
+	}
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if event.Type == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package eventstore
+
+import (
+	"context"
+	"defi/internal/model"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultOutboxInterval  = time.Second
+	defaultOutboxBatchSize = 100
+)
+
+// TopicByAggregateType routes events to prefix + "." + aggregate type, e.g.
+// events.account, so consumers can subscribe per aggregate type.
+func TopicByAggregateType(prefix string) func(model.Event) string {
+	return func(event model.Event) string {
+		if event.AggregateType == "" {
+			return prefix
+		}
+		return prefix + "." + event.AggregateType
+	}
+}
+
+// Publisher is the subset of eventbus.EventBus the relay needs.
+type Publisher interface {
+	PublishEvent(topic string, event []byte) error
+}
+
+// OutboxRelay publishes events recorded in the outbox by AppendEvents. Rows
+// are claimed with SKIP LOCKED so several replicas can relay concurrently;
+// delivery is at least once, in outbox order per replica.
+type OutboxRelay struct {
+	Store     *BaseEventStore
+	Bus       Publisher
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Run relays until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultOutboxInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := r.RelayBatch(ctx)
+			if err != nil {
+				log.Printf("Outbox relay failed: %v", err)
+			}
+			if err != nil || n == 0 {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayBatch publishes up to BatchSize pending events and returns how many
+// were published.
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	batch := r.BatchSize
+	if batch <= 0 {
+		batch = defaultOutboxBatchSize
+	}
+	es := r.Store
+
+	tx, err := es.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`SELECT id, topic, payload FROM outbox WHERE published_at IS NULL ORDER BY id LIMIT %d FOR UPDATE SKIP LOCKED`, batch)
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	type pending struct {
+		id      int64
+		topic   string
+		payload string
+	}
+	var items []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.topic, &p.payload); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox: %w", err)
+		}
+		items = append(items, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	update := es.dialect.rebind(`UPDATE outbox SET published_at = ? WHERE id = ?`)
+	for _, item := range items {
+		if err := r.Bus.PublishEvent(item.topic, []byte(item.payload)); err != nil {
+			// Stop here to keep order; the rest is retried next round.
+			log.Printf("Failed to publish outbox entry %d to %s: %v", item.id, item.topic, err)
+			break
+		}
+		if _, err := tx.ExecContext(ctx, update, time.Now().UnixMilli(), item.id); err != nil {
+			return 0, fmt.Errorf("failed to mark outbox entry %d: %w", item.id, err)
+		}
+		published++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox: %w", err)
+	}
+	return published, nil
+}
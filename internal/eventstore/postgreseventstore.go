@@ -14,5 +14,5 @@ func NewPostgresEventStore(dataSourceName string) (*PostgresEventStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &PostgresEventStore{&BaseEventStore{Db: db}}, nil
+	return &PostgresEventStore{&BaseEventStore{Db: db, dialect: dialectPostgres}}, nil
 }
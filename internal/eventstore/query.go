@@ -0,0 +1,199 @@
+package eventstore
+
+import (
+	"context"
+	"defi/internal/model"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+type Order string
+
+const (
+	OrderAsc  Order = "asc"
+	OrderDesc Order = "desc"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EventQuery filters events across aggregates. Zero-valued fields are ignored.
+// Since and Until bound Timestamp as a half-open range [Since, Until).
+type EventQuery struct {
+	AggregateID   string
+	AggregateType string
+	Types         []string
+	Since         int64
+	Until         int64
+	Metadata      map[string]string
+	Cursor        string
+	Limit         int
+	Order         Order
+}
+
+// EventPage is one page of results. NextCursor is empty on the last page.
+type EventPage struct {
+	Events     []model.Event
+	NextCursor string
+}
+
+func (es *BaseEventStore) FindEvents(q EventQuery) (EventPage, error) {
+	query, args, limit, err := es.buildFindQuery(q)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	rows, err := es.readDB().Query(query, args...)
+	if err != nil {
+		return EventPage{}, fmt.Errorf("failed to find events: %w", err)
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	page := EventPage{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		page.NextCursor = EncodeCursor(page.Events[limit-1].Position)
+	}
+	return page, nil
+}
+
+func (es *BaseEventStore) buildFindQuery(q EventQuery) (string, []interface{}, int, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	order := q.Order
+	if order == "" {
+		order = OrderAsc
+	}
+	if order != OrderAsc && order != OrderDesc {
+		return "", nil, 0, fmt.Errorf("invalid order %q", q.Order)
+	}
+
+	var where []string
+	var args []interface{}
+	if q.AggregateID != "" {
+		where = append(where, "aggregate_id = ?")
+		args = append(args, q.AggregateID)
+	}
+	if q.AggregateType != "" {
+		where = append(where, "aggregate_type = ?")
+		args = append(args, q.AggregateType)
+	}
+	if len(q.Types) > 0 {
+		where = append(where, "type IN (?"+strings.Repeat(", ?", len(q.Types)-1)+")")
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+	if q.Since > 0 {
+		where = append(where, "timestamp >= ?")
+		args = append(args, q.Since)
+	}
+	if q.Until > 0 {
+		where = append(where, "timestamp < ?")
+		args = append(args, q.Until)
+	}
+
+	// Sort keys so identical queries produce identical SQL.
+	keys := make([]string, 0, len(q.Metadata))
+	for k := range q.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cond, condArgs := es.dialect.metadataFilter(k, q.Metadata[k])
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	if q.Cursor != "" {
+		position, err := DecodeCursor(q.Cursor)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		if order == OrderAsc {
+			where = append(where, "position > ?")
+		} else {
+			where = append(where, "position < ?")
+		}
+		args = append(args, position)
+	}
+
+	query := `SELECT ` + eventColumns + ` FROM events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY position " + strings.ToUpper(string(order))
+	// Fetch one extra row to learn whether another page exists.
+	query += fmt.Sprintf(" LIMIT %d", limit+1)
+
+	return es.dialect.rebind(query), args, limit, nil
+}
+
+// EncodeCursor returns the opaque pagination cursor pointing after position.
+func EncodeCursor(position int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(position, 10)))
+}
+
+func DecodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	position, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || position < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return position, nil
+}
+
+// Finder pages through stored events. BaseEventStore and MemoryEventStore
+// implement it.
+type Finder interface {
+	FindEvents(q EventQuery) (EventPage, error)
+}
+
+// ForEach calls fn for every event matching q, following cursors from
+// q.Cursor to the last page, and returns the cursor after the last event
+// seen so the caller can resume from there. It stops at the first error.
+func ForEach(ctx context.Context, source Finder, q EventQuery, fn func(model.Event) error) (string, error) {
+	if q.Limit <= 0 {
+		q.Limit = MaxQueryLimit
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return q.Cursor, err
+		}
+		page, err := source.FindEvents(q)
+		if err != nil {
+			return q.Cursor, err
+		}
+		for _, event := range page.Events {
+			if err := fn(event); err != nil {
+				return q.Cursor, err
+			}
+			q.Cursor = EncodeCursor(event.Position)
+		}
+		if page.NextCursor == "" {
+			return q.Cursor, nil
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package eventstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildFindQuery(t *testing.T) {
+	es := &BaseEventStore{dialect: dialectPostgres}
+	query, args, limit, err := es.buildFindQuery(EventQuery{
+		AggregateType: "pool",
+		Types:         []string{"Swapped", "LiquidityAdded"},
+		Since:         100,
+		Metadata:      map[string]string{"user": "alice"},
+		Cursor:        EncodeCursor(42),
+		Order:         OrderDesc,
+		Limit:         10,
+	})
+	if err != nil {
+		t.Fatalf("Failed to build query: %v", err)
+	}
+	if limit != 10 {
+		t.Fatalf("Expected limit 10, got %d", limit)
+	}
+
+	expected := "WHERE aggregate_type = $1 AND type IN ($2, $3) AND timestamp >= $4 AND metadata @> $5::jsonb AND position < $6 ORDER BY position DESC LIMIT 11"
+	if !strings.HasSuffix(query, expected) {
+		t.Fatalf("Unexpected query: %s", query)
+	}
+	if len(args) != 6 || args[4] != `{"user":"alice"}` || args[5] != int64(42) {
+		t.Fatalf("Unexpected args: %v", args)
+	}
+}
+
+func TestMetadataFilterBindsKeys(t *testing.T) {
+	key := `a\"b`
+	cond, args := dialectMySQL.metadataFilter(key, "v")
+	if strings.Contains(cond, key) || len(args) != 2 || args[0] != key || args[1] != "v" {
+		t.Fatalf("Unexpected filter %s %v", cond, args)
+	}
+	if _, args := dialectPostgres.metadataFilter(key, "v"); args[0] != `{"a\\\"b":"v"}` {
+		t.Fatalf("Unexpected containment document %v", args[0])
+	}
+}
+
+func TestBuildFindQueryRejectsBadInput(t *testing.T) {
+	es := &BaseEventStore{}
+	if _, _, _, err := es.buildFindQuery(EventQuery{Cursor: "not a cursor"}); err != ErrInvalidCursor {
+		t.Fatalf("Expected ErrInvalidCursor, got %v", err)
+	}
+	if _, _, _, err := es.buildFindQuery(EventQuery{Order: "sideways"}); err == nil {
+		t.Fatal("Expected error for invalid order")
+	}
+	_, _, limit, _ := es.buildFindQuery(EventQuery{Limit: MaxQueryLimit + 1})
+	if limit != MaxQueryLimit {
+		t.Fatalf("Expected limit capped at %d, got %d", MaxQueryLimit, limit)
+	}
+}
@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Statuses, from best to worst.
+const (
+	StatusUp = "up"
+	// StatusDegraded means a non-critical dependency failed: the service
+	// still serves, with less capacity or fewer features.
+	StatusDegraded = "degraded"
+	// StatusDown means a critical dependency failed.
+	StatusDown = "down"
+)
+
+// Defaults for zero fields.
+const (
+	DefaultTimeout  = 2 * time.Second
+	DefaultCacheTTL = time.Second
+)
+
+// Check probes one dependency.
+type Check struct {
+	Name string
+	// Critical checks take the service out of rotation when they fail;
+	// others only degrade it.
+	Critical bool
+	// Timeout bounds Run; zero means DefaultTimeout.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check, and the worst of their statuses.
+type Report struct {
+	Status    string   `json:"status"`
+	Checks    []Result `json:"checks"`
+	CheckedAt int64    `json:"checkedAt"`
+}
+
+// Ready reports whether the service should receive traffic.
+func (r Report) Ready() bool {
+	return r.Status != StatusDown
+}
+
+// Registry holds the checks components register and runs them on demand.
+// Reports are reused for CacheTTL, so frequent probes do not load the
+// dependencies.
+type Registry struct {
+	CacheTTL time.Duration
+	mu       sync.Mutex
+	checks   []Check
+	last     Report
+	lastAt   time.Time
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds checks.
+func (r *Registry) Register(checks ...Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, checks...)
+	r.lastAt = time.Time{}
+}
+
+// Check runs every check concurrently and reports their results in the
+// order they were registered. The report is shared with other probes, so
+// the checks only take values from ctx: a caller that goes away does not
+// cut them short, and each is bounded by its own timeout.
+func (r *Registry) Check(ctx context.Context) Report {
+	ctx = context.WithoutCancel(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ttl := r.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	if time.Since(r.lastAt) < ttl {
+		return r.last
+	}
+
+	report := Report{Status: StatusUp, Checks: make([]Result, len(r.checks))}
+	var wg sync.WaitGroup
+	for i, check := range r.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			report.Checks[i] = run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+	for _, result := range report.Checks {
+		switch {
+		case result.Status == StatusUp:
+		case result.Critical:
+			report.Status = StatusDown
+		case report.Status == StatusUp:
+			report.Status = StatusDegraded
+		}
+	}
+	r.lastAt = time.Now()
+	report.CheckedAt = r.lastAt.UnixMilli()
+	r.last = report
+	return report
+}
+
+func run(ctx context.Context, check Check) Result {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check.Run(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Checks that ignore their context are abandoned.
+		err = ctx.Err()
+	}
+	result := Result{Name: check.Name, Status: StatusUp, Critical: check.Critical, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status, result.Error = StatusDown, err.Error()
+	}
+	return result
+}
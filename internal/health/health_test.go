@@ -0,0 +1,46 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	up := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("connection refused") }
+	hang := func(ctx context.Context) error { time.Sleep(time.Second); return nil }
+
+	r := NewRegistry()
+	r.Register(Check{Name: "database", Critical: true, Run: up}, Check{Name: "cache", Run: fail})
+	report := r.Check(ctx)
+	if report.Status != StatusDegraded || !report.Ready() || report.Checks[1].Error != "connection refused" {
+		t.Fatalf("degraded: %+v", report)
+	}
+
+	r.Register(Check{Name: "broker", Critical: true, Timeout: 10 * time.Millisecond, Run: hang})
+	report = r.Check(ctx)
+	if report.Status != StatusDown || report.Ready() || len(report.Checks) != 3 {
+		t.Fatalf("down: %+v", report)
+	}
+	if got := report.Checks[2]; got.Name != "broker" || got.Error != context.DeadlineExceeded.Error() || got.LatencyMs >= 1000 {
+		t.Errorf("timed out check: %+v", got)
+	}
+	// Reports are reused within the cache TTL.
+	if again := r.Check(ctx); again.CheckedAt != report.CheckedAt {
+		t.Errorf("report not cached")
+	}
+}
+
+func TestCheckOutlivesTheProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := NewRegistry()
+	r.Register(Check{Name: "database", Critical: true, Run: func(ctx context.Context) error { return ctx.Err() }})
+	// A probe that went away must not leave a failed report for the others.
+	if report := r.Check(ctx); report.Status != StatusUp {
+		t.Errorf("checks ran under the cancelled probe: %+v", report)
+	}
+}
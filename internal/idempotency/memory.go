@@ -0,0 +1,58 @@
+package idempotency
+
+import (
+	"context"
+	"defi/internal/model"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Store for single-replica deployments and
+// tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+}
+
+type memoryRecord struct {
+	Record
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]memoryRecord)}
+}
+
+func (s *MemoryStore) Lock(_ context.Context, key, fingerprint string, lock time.Duration) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if existing, ok := s.records[key]; ok && now.Before(existing.expires) {
+		return existing.Record, false, nil
+	}
+	claim := Record{Fingerprint: fingerprint, Token: model.NewID()}
+	s.records[key] = memoryRecord{Record: claim, expires: now.Add(lock)}
+	return claim, true, nil
+}
+
+func (s *MemoryStore) Save(_ context.Context, key string, record Record, retain time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.records[key]
+	if !ok || existing.Done() || existing.Token != record.Token {
+		return fmt.Errorf("%w: %s", ErrClaimLost, key)
+	}
+	record.Token = ""
+	s.records[key] = memoryRecord{Record: record, expires: time.Now().Add(retain)}
+	return nil
+}
+
+func (s *MemoryStore) Unlock(_ context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[key]; ok && !existing.Done() && existing.Token == token {
+		delete(s.records, key)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package idempotency
+
+import (
+	"context"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+const redisPrefix = "idempotency:"
+
+// RedisStore keeps records as JSON under idempotency:<key>, expiring with
+// their lock or retention. It looks its client up on every call so it
+// follows db.DB.ReconfigureRedis.
+type RedisStore struct {
+	client func() *redis.ClusterClient
+}
+
+func NewRedisStore(client func() *redis.ClusterClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Lock(ctx context.Context, key, fingerprint string, lock time.Duration) (Record, bool, error) {
+	claim := Record{Fingerprint: fingerprint, Token: model.NewID()}
+	value, err := json.Marshal(claim)
+	if err != nil {
+		return Record{}, false, err
+	}
+	// The existing record can expire between SETNX and GET; try again then.
+	for attempt := 0; attempt < 3; attempt++ {
+		ok, err := s.client().SetNX(ctx, redisPrefix+key, value, lock).Result()
+		if err != nil {
+			return Record{}, false, fmt.Errorf("redis lock %s: %w", key, err)
+		}
+		if ok {
+			return claim, true, nil
+		}
+		existing, err := s.client().Get(ctx, redisPrefix+key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return Record{}, false, fmt.Errorf("redis get %s: %w", key, err)
+		}
+		var record Record
+		if err := json.Unmarshal(existing, &record); err != nil {
+			return Record{}, false, fmt.Errorf("invalid idempotency record %s: %w", key, err)
+		}
+		return record, false, nil
+	}
+	return Record{}, false, ErrContended
+}
+
+// saveScript replaces a claim with its response only while the claim still
+// holds the token, so a request whose lock expired cannot overwrite the
+// request that took the key over.
+var saveScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return 0
+end
+local record = cjson.decode(value)
+if record.Status == 0 and record.Token == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+	return 1
+end
+return 0
+`)
+
+func (s *RedisStore) Save(ctx context.Context, key string, record Record, retain time.Duration) error {
+	token := record.Token
+	record.Token = ""
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	saved, err := saveScript.Run(ctx, s.client(), []string{redisPrefix + key}, token, value, retain.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("redis save %s: %w", key, err)
+	}
+	if saved == 0 {
+		return fmt.Errorf("%w: %s", ErrClaimLost, key)
+	}
+	return nil
+}
+
+// unlockScript deletes a claim only while it still holds the token, so a
+// late Unlock cannot release a completed key or another request's claim.
+var unlockScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return 0
+end
+local record = cjson.decode(value)
+if record.Status == 0 and record.Token == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (s *RedisStore) Unlock(ctx context.Context, key, token string) error {
+	if err := unlockScript.Run(ctx, s.client(), []string{redisPrefix + key}, token).Err(); err != nil {
+		return fmt.Errorf("redis unlock %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SQLStore keeps records in the idempotency_keys table. Expired rows are
+// reclaimed by Lock and deleted by Run.
+type SQLStore struct {
+	Db     *sql.DB
+	driver string
+}
+
+// NewSQLStore returns a store over db for the named database/sql driver,
+// "mysql" or "postgres".
+func NewSQLStore(db *sql.DB, driver string) (*SQLStore, error) {
+	if driver != "mysql" && driver != "postgres" {
+		return nil, fmt.Errorf("unsupported idempotency store driver: %s", driver)
+	}
+	return &SQLStore{Db: db, driver: driver}, nil
+}
+
+func (s *SQLStore) Lock(ctx context.Context, key, fingerprint string, lock time.Duration) (Record, bool, error) {
+	insert := s.rebind(`INSERT INTO idempotency_keys (id, fingerprint, token, status, expires_at, created_at) VALUES (?, ?, ?, 0, ?, ?)`)
+	for attempt := 0; attempt < 3; attempt++ {
+		now := time.Now()
+		claim := Record{Fingerprint: fingerprint, Token: model.NewID()}
+		_, err := s.Db.ExecContext(ctx, insert, key, fingerprint, claim.Token, now.Add(lock).UnixMilli(), now.UnixMilli())
+		if err == nil {
+			return claim, true, nil
+		}
+		if !eventstore.IsUniqueViolation(err) {
+			return Record{}, false, fmt.Errorf("failed to lock idempotency key %s: %w", key, err)
+		}
+
+		var (
+			record  Record
+			header  sql.NullString
+			expires int64
+		)
+		query := s.rebind(`SELECT fingerprint, status, header, body, expires_at FROM idempotency_keys WHERE id = ?`)
+		err = s.Db.QueryRowContext(ctx, query, key).Scan(&record.Fingerprint, &record.Status, &header, &record.Body, &expires)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return Record{}, false, fmt.Errorf("failed to read idempotency key %s: %w", key, err)
+		}
+		if expires < now.UnixMilli() {
+			// Only the expired row is deleted, in case another request
+			// reclaimed it meanwhile.
+			if _, err := s.Db.ExecContext(ctx, s.rebind(`DELETE FROM idempotency_keys WHERE id = ? AND expires_at = ?`), key, expires); err != nil {
+				return Record{}, false, fmt.Errorf("failed to reclaim idempotency key %s: %w", key, err)
+			}
+			continue
+		}
+		if header.Valid {
+			if err := json.Unmarshal([]byte(header.String), &record.Header); err != nil {
+				return Record{}, false, fmt.Errorf("invalid headers of idempotency key %s: %w", key, err)
+			}
+		}
+		return record, false, nil
+	}
+	return Record{}, false, ErrContended
+}
+
+func (s *SQLStore) Save(ctx context.Context, key string, record Record, retain time.Duration) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	query := s.rebind(`UPDATE idempotency_keys SET status = ?, header = ?, body = ?, expires_at = ? WHERE id = ? AND token = ? AND status = 0`)
+	res, err := s.Db.ExecContext(ctx, query, record.Status, string(header), record.Body, time.Now().Add(retain).UnixMilli(), key, record.Token)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency key %s: %w", key, err)
+	}
+	// The status always changes from 0, so MySQL counts the row as affected.
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrClaimLost, key)
+	}
+	return nil
+}
+
+func (s *SQLStore) Unlock(ctx context.Context, key, token string) error {
+	if _, err := s.Db.ExecContext(ctx, s.rebind(`DELETE FROM idempotency_keys WHERE id = ? AND token = ? AND status = 0`), key, token); err != nil {
+		return fmt.Errorf("failed to unlock idempotency key %s: %w", key, err)
+	}
+	return nil
+}
+
+// Run deletes expired records every interval until ctx is cancelled.
+func (s *SQLStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		query := s.rebind(`DELETE FROM idempotency_keys WHERE expires_at < ?`)
+		if _, err := s.Db.ExecContext(ctx, query, time.Now().UnixMilli()); err != nil {
+			log.Printf("Failed to delete expired idempotency keys: %v", err)
+		}
+	}
+}
+
+// rebind rewrites ?-style placeholders for the driver.
+func (s *SQLStore) rebind(query string) string {
+	return eventstore.Rebind(s.driver, query)
+}
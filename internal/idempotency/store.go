@@ -0,0 +1,69 @@
+package idempotency
+
+import (
+	"context"
+	"defi/internal/db"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Defaults for callers that do not configure their own.
+const (
+	// DefaultRetention is how long a response is replayed for its key.
+	DefaultRetention = 24 * time.Hour
+	// DefaultLockTimeout is how long a request may hold its key before
+	// another may claim it, in case the first one's replica died.
+	DefaultLockTimeout = time.Minute
+)
+
+var (
+	// ErrContended is returned by Lock when the key keeps changing hands.
+	ErrContended = errors.New("idempotency key is contended")
+	// ErrClaimLost is returned by Save when the claim it names no longer
+	// holds the key, e.g. because its lock expired and another request
+	// took the key over.
+	ErrClaimLost = errors.New("idempotency claim no longer holds the key")
+)
+
+// Record is what is known about a request under a key: a fingerprint of
+// the request and, once it completes, its response.
+type Record struct {
+	Fingerprint string
+	// Token identifies the claim of the request holding the key. Lock sets
+	// it, and Save and Unlock only act on the claim it names.
+	Token string `json:",omitempty"`
+	// Status is zero while the request is still being handled.
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Done reports whether the request completed.
+func (r Record) Done() bool {
+	return r.Status != 0
+}
+
+// Store keeps the records of requests by key.
+type Store interface {
+	// Lock claims key for a request with fingerprint, for up to lock. If key
+	// is already claimed or completed, it returns that record and false.
+	Lock(ctx context.Context, key, fingerprint string, lock time.Duration) (Record, bool, error)
+	// Save completes the claim on key named by record.Token with its
+	// response, which is kept for retain. It returns ErrClaimLost if that
+	// claim no longer holds the key.
+	Save(ctx context.Context, key string, record Record, retain time.Duration) error
+	// Unlock releases the claim on key with token, without a response, so
+	// the request may be retried. It leaves completed records and claims
+	// taken over after the lock expired alone.
+	Unlock(ctx context.Context, key, token string) error
+}
+
+// New returns a Store in Redis if the Redis cluster is configured, and in
+// the idempotency_keys table otherwise.
+func New(database *db.DB) (Store, error) {
+	if database.Redis() != nil {
+		return NewRedisStore(database.Redis), nil
+	}
+	return NewSQLStore(database.SQL, database.Driver)
+}
@@ -0,0 +1,158 @@
+package ledger
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/model"
+	"encoding/json"
+	"fmt"
+)
+
+// Aggregate and event types of the ledger.
+const (
+	AccountType      = "account"
+	JournalEntryType = "journal_entry"
+
+	EventAccountOpened      = "AccountOpened"
+	EventAccountPosted      = "AccountPosted"
+	EventAccountClosed      = "AccountClosed"
+	EventJournalEntryPosted = "JournalEntryPosted"
+)
+
+// Kind is the accounting classification of an account, which decides its
+// normal balance side.
+type Kind string
+
+const (
+	KindAsset     Kind = "asset"
+	KindLiability Kind = "liability"
+	KindEquity    Kind = "equity"
+	KindIncome    Kind = "income"
+	KindExpense   Kind = "expense"
+)
+
+func (k Kind) Valid() bool {
+	switch k {
+	case KindAsset, KindLiability, KindEquity, KindIncome, KindExpense:
+		return true
+	}
+	return false
+}
+
+// NormalSide is the side that increases the account's balance.
+func (k Kind) NormalSide() Side {
+	if k == KindAsset || k == KindExpense {
+		return Debit
+	}
+	return Credit
+}
+
+type Side string
+
+const (
+	Debit  Side = "debit"
+	Credit Side = "credit"
+)
+
+type AccountOpened struct {
+	Owner string `json:"owner"`
+	Kind  Kind   `json:"kind"`
+	// AllowNegative exempts the account from the no-overdraft rule, e.g. for
+	// omnibus accounts mirroring an external system.
+	AllowNegative bool `json:"allowNegative,omitempty"`
+}
+
+// AccountPosted is one leg of a journal entry as seen by its account.
+type AccountPosted struct {
+	EntryID string       `json:"entryId"`
+	Asset   string       `json:"asset"`
+	Side    Side         `json:"side"`
+	Amount  model.Amount `json:"amount"`
+}
+
+// UnmarshalJSON decodes the amount with the asset's registered decimals
+// rather than those of its text.
+func (e *AccountPosted) UnmarshalJSON(data []byte) error {
+	type plain AccountPosted
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	amount, err := assetAmount(e.Asset, e.Amount)
+	if err != nil {
+		return err
+	}
+	e.Amount = amount
+	return nil
+}
+
+type AccountClosed struct{}
+
+// Account is the state of an account aggregate. Balances are per asset, in
+// the account's normal-side terms.
+type Account struct {
+	Status        model.State
+	Owner         string
+	Kind          Kind
+	AllowNegative bool
+	Balances      map[string]model.Amount
+}
+
+var accountLifecycle = aggregate.NewMachine[*Account](model.StateInitial).
+	Allow(model.StateInitial, EventAccountOpened, model.StateActive).
+	Allow(model.StateActive, EventAccountPosted, model.StateActive).
+	Allow(model.StateActive, EventAccountClosed, model.StateClosed, func(a *Account, _ model.Event) error {
+		for asset, balance := range a.Balances {
+			if !balance.IsZero() {
+				return fmt.Errorf("%s balance is %s", asset, balance)
+			}
+		}
+		return nil
+	})
+
+// AccountLifecycle returns the state machine of accounts, e.g. for export.
+func AccountLifecycle() *aggregate.Machine[*Account] {
+	return accountLifecycle
+}
+
+func NewAccount() *Account {
+	return &Account{Balances: make(map[string]model.Amount)}
+}
+
+func (a *Account) Apply(event model.Event) error {
+	next, err := accountLifecycle.Next(a, a.Status, event)
+	if err != nil {
+		return err
+	}
+	switch event.Type {
+	case EventAccountOpened:
+		var e AccountOpened
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return fmt.Errorf("invalid %s: %w", event.Type, err)
+		}
+		a.Owner, a.Kind, a.AllowNegative = e.Owner, e.Kind, e.AllowNegative
+	case EventAccountPosted:
+		var e AccountPosted
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return fmt.Errorf("invalid %s: %w", event.Type, err)
+		}
+		balance, err := a.balanceAfter(e)
+		if err != nil {
+			return fmt.Errorf("failed to post %s to %s: %w", e.Asset, event.AggregateID, err)
+		}
+		a.Balances[e.Asset] = balance
+	}
+	a.Status = next
+	return nil
+}
+
+// balanceAfter returns the balance of e.Asset once e is applied. The first
+// posting of an asset fixes its decimals for the account.
+func (a *Account) balanceAfter(e AccountPosted) (model.Amount, error) {
+	balance, ok := a.Balances[e.Asset]
+	if !ok {
+		balance = model.Units(0, e.Amount.Decimals())
+	}
+	if e.Side == a.Kind.NormalSide() {
+		return balance.Add(e.Amount)
+	}
+	return balance.Sub(e.Amount)
+}
@@ -0,0 +1,43 @@
+package ledger
+
+import (
+	"defi/internal/eventstore"
+	"defi/internal/model"
+)
+
+// ErrGap is returned by Balances.Handle when an account event arrives before
+// an earlier one; the caller should CatchUp from the store.
+var ErrGap = eventstore.ErrGap
+
+// Balances is a read model of account balances per asset. Feed it account
+// events from the event bus with Handle, or from the store with CatchUp.
+// Events already seen are ignored, so at-least-once delivery is safe.
+type Balances struct {
+	*eventstore.ReadModel[*Account]
+}
+
+func NewBalances() *Balances {
+	return &Balances{eventstore.NewReadModel(AccountType, NewAccount, (*Account).Apply)}
+}
+
+// Balance returns the balance of asset in accountID and whether the account
+// ever held it.
+func (b *Balances) Balance(accountID, asset string) (model.Amount, bool) {
+	var balance model.Amount
+	var ok bool
+	b.View(accountID, func(account *Account, _ int64) {
+		balance, ok = account.Balances[asset]
+	})
+	return balance, ok
+}
+
+// Of returns a copy of every asset balance of accountID.
+func (b *Balances) Of(accountID string) map[string]model.Amount {
+	balances := make(map[string]model.Amount)
+	b.View(accountID, func(account *Account, _ int64) {
+		for asset, balance := range account.Balances {
+			balances[asset] = balance
+		}
+	})
+	return balances
+}
@@ -0,0 +1,145 @@
+package ledger
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/command"
+	"defi/internal/model"
+	"errors"
+	"sort"
+)
+
+type OpenAccount struct {
+	AccountID     string
+	Owner         string
+	Kind          Kind
+	AllowNegative bool
+}
+
+func (c OpenAccount) Validate() error {
+	if c.AccountID == "" {
+		return errors.New("account ID is required")
+	}
+	if !c.Kind.Valid() {
+		return errors.New("kind must be asset, liability, equity, income or expense")
+	}
+	return nil
+}
+
+type CloseAccount struct {
+	AccountID string
+}
+
+func (c CloseAccount) Validate() error {
+	if c.AccountID == "" {
+		return errors.New("account ID is required")
+	}
+	return nil
+}
+
+// PostEntry posts a balanced journal entry. EntryID doubles as an
+// idempotency key: an entry ID can be posted only once.
+type PostEntry struct {
+	EntryID     string
+	Description string
+	Legs        []Leg
+}
+
+func (c PostEntry) Validate() error {
+	if c.EntryID == "" {
+		return errors.New("entry ID is required")
+	}
+	return validateLegs(c.Legs)
+}
+
+// Ledger handles the ledger commands.
+type Ledger struct {
+	store    aggregate.BatchStore
+	accounts *aggregate.Repository[*Account]
+	entries  *aggregate.Repository[*JournalEntry]
+}
+
+func New(store aggregate.BatchStore) *Ledger {
+	return &Ledger{
+		store:    store,
+		accounts: aggregate.NewRepository(store, AccountType, NewAccount),
+		entries:  aggregate.NewRepository(store, JournalEntryType, NewJournalEntry),
+	}
+}
+
+// Register adds the ledger's handlers to bus.
+func (l *Ledger) Register(bus *command.Bus) {
+	command.Register(bus, command.Handle(l.accounts, func(c OpenAccount) string { return c.AccountID }, l.openAccount))
+	command.Register(bus, command.Handle(l.accounts, func(c CloseAccount) string { return c.AccountID }, l.closeAccount))
+	command.Register(bus, l.postEntry)
+}
+
+// Account loads the current state of an account from the store.
+func (l *Ledger) Account(ctx context.Context, id string) (*aggregate.Root[*Account], error) {
+	return l.accounts.Load(ctx, id)
+}
+
+func (l *Ledger) openAccount(ctx context.Context, c OpenAccount, root *aggregate.Root[*Account]) error {
+	return root.Raise(EventAccountOpened, AccountOpened{Owner: c.Owner, Kind: c.Kind, AllowNegative: c.AllowNegative})
+}
+
+func (l *Ledger) closeAccount(ctx context.Context, c CloseAccount, root *aggregate.Root[*Account]) error {
+	return root.Raise(EventAccountClosed, AccountClosed{})
+}
+
+// postEntry records the entry and a posting on every account it touches in
+// one atomic append, so balances can never disagree with the journal.
+func (l *Ledger) postEntry(ctx context.Context, c PostEntry) error {
+	entry, err := l.entries.Load(ctx, c.EntryID)
+	if err != nil {
+		return err
+	}
+	if err := entry.Raise(EventJournalEntryPosted, JournalEntryPosted{Description: c.Description, Legs: c.Legs}); err != nil {
+		return err
+	}
+
+	var ids []string
+	accounts := make(map[string]*aggregate.Root[*Account])
+	for _, leg := range c.Legs {
+		if accounts[leg.AccountID] != nil {
+			continue
+		}
+		root, err := l.accounts.Load(ctx, leg.AccountID)
+		if err != nil {
+			return err
+		}
+		accounts[leg.AccountID] = root
+		ids = append(ids, leg.AccountID)
+	}
+	sort.Strings(ids)
+
+	for _, leg := range c.Legs {
+		root := accounts[leg.AccountID]
+		posted := AccountPosted{EntryID: c.EntryID, Asset: leg.Asset, Side: leg.Side, Amount: leg.Amount}
+		if err := root.Raise(EventAccountPosted, posted); err != nil {
+			if errors.Is(err, model.ErrDecimalsMismatch) {
+				return command.Rejectf("%s amounts of account %s use different decimals", leg.Asset, leg.AccountID)
+			}
+			return err
+		}
+	}
+	// Check overdrafts on the net effect, so an entry may pass through an
+	// account within itself.
+	for _, id := range ids {
+		account := accounts[id].State
+		if account.AllowNegative {
+			continue
+		}
+		for asset, balance := range account.Balances {
+			if balance.Sign() < 0 {
+				return command.Rejectf("insufficient %s balance in account %s", asset, id)
+			}
+		}
+	}
+
+	changed := []aggregate.Changed{entry}
+	for _, id := range ids {
+		changed = append(changed, accounts[id])
+	}
+	return aggregate.SaveAll(ctx, l.store, changed...)
+}
@@ -0,0 +1,134 @@
+package ledger
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var errUnbalanced = errors.New("debits and credits do not balance")
+
+// Leg debits or credits one account with an amount of one asset. Amounts
+// must have the decimals registered for the asset with model.RegisterAsset.
+type Leg struct {
+	AccountID string       `json:"accountId"`
+	Asset     string       `json:"asset"`
+	Side      Side         `json:"side"`
+	Amount    model.Amount `json:"amount"`
+}
+
+// UnmarshalJSON decodes the amount with the asset's registered decimals
+// rather than those of its text.
+func (l *Leg) UnmarshalJSON(data []byte) error {
+	type plain Leg
+	if err := json.Unmarshal(data, (*plain)(l)); err != nil {
+		return err
+	}
+	amount, err := assetAmount(l.Asset, l.Amount)
+	if err != nil {
+		return err
+	}
+	l.Amount = amount
+	return nil
+}
+
+// assetAmount returns amount with the registered decimals of asset. Assets
+// that are not registered keep the decimals they were stored with, so
+// history stays readable; validateLegs keeps new ones from being posted.
+func assetAmount(asset string, amount model.Amount) (model.Amount, error) {
+	if _, ok := model.AssetDecimals(asset); !ok {
+		return amount, nil
+	}
+	return amount.ForAsset(asset)
+}
+
+type JournalEntryPosted struct {
+	Description string `json:"description,omitempty"`
+	Legs        []Leg  `json:"legs"`
+}
+
+// JournalEntry is the state of a journal entry aggregate. Entries are
+// immutable once posted, so posting an entry ID twice is rejected.
+type JournalEntry struct {
+	Status      model.State
+	Description string
+	Legs        []Leg
+}
+
+var entryLifecycle = aggregate.NewMachine[*JournalEntry](model.StateInitial).
+	Allow(model.StateInitial, EventJournalEntryPosted, model.StateActive)
+
+func NewJournalEntry() *JournalEntry {
+	return &JournalEntry{}
+}
+
+func (j *JournalEntry) Apply(event model.Event) error {
+	next, err := entryLifecycle.Next(j, j.Status, event)
+	if err != nil {
+		return err
+	}
+	if event.Type == EventJournalEntryPosted {
+		var e JournalEntryPosted
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return fmt.Errorf("invalid %s: %w", event.Type, err)
+		}
+		j.Description, j.Legs = e.Description, e.Legs
+	}
+	j.Status = next
+	return nil
+}
+
+// validateLegs checks that legs are well formed and that, for every asset,
+// debits equal credits.
+func validateLegs(legs []Leg) error {
+	if len(legs) < 2 {
+		return errors.New("a journal entry needs at least two legs")
+	}
+	net := make(map[string]model.Amount)
+	for i, leg := range legs {
+		switch {
+		case leg.AccountID == "":
+			return fmt.Errorf("leg %d: account is required", i)
+		case leg.Asset == "":
+			return fmt.Errorf("leg %d: asset is required", i)
+		case leg.Side != Debit && leg.Side != Credit:
+			return fmt.Errorf("leg %d: side must be %s or %s", i, Debit, Credit)
+		case leg.Amount.Sign() <= 0:
+			return fmt.Errorf("leg %d: amount must be positive", i)
+		}
+		switch decimals, ok := model.AssetDecimals(leg.Asset); {
+		case !ok:
+			return fmt.Errorf("leg %d: %w: %s", i, model.ErrUnknownAsset, leg.Asset)
+		case leg.Amount.Decimals() != decimals:
+			return fmt.Errorf("leg %d: %s amounts need %d decimals", i, leg.Asset, decimals)
+		}
+		amount := leg.Amount
+		if leg.Side == Credit {
+			amount = amount.Neg()
+		}
+		sum, ok := net[leg.Asset]
+		if !ok {
+			sum = model.Units(0, amount.Decimals())
+		}
+		sum, err := sum.Add(amount)
+		if err != nil {
+			return fmt.Errorf("leg %d: %s: %w", i, leg.Asset, err)
+		}
+		net[leg.Asset] = sum
+	}
+
+	var unbalanced []string
+	for asset, sum := range net {
+		if !sum.IsZero() {
+			unbalanced = append(unbalanced, fmt.Sprintf("%s off by %s", asset, sum))
+		}
+	}
+	if len(unbalanced) > 0 {
+		sort.Strings(unbalanced)
+		return fmt.Errorf("%w: %v", errUnbalanced, unbalanced)
+	}
+	return nil
+}
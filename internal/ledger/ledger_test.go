@@ -0,0 +1,123 @@
+package ledger
+
+import (
+	"context"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func eth(s string) model.Amount {
+	return model.MustParseAmount(s, 18)
+}
+
+func TestLedger(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	bus := command.NewBus()
+	New(store).Register(bus)
+	model.RegisterAsset("ETH", 18)
+	model.RegisterAsset("USDC", 6)
+
+	dispatch := func(cmd interface{}) error { return bus.Dispatch(ctx, cmd) }
+	for _, cmd := range []OpenAccount{
+		{AccountID: "treasury", Kind: KindEquity},
+		{AccountID: "alice", Owner: "alice", Kind: KindAsset},
+		{AccountID: "bob", Owner: "bob", Kind: KindAsset},
+	} {
+		if err := dispatch(cmd); err != nil {
+			t.Fatalf("open %s: %v", cmd.AccountID, err)
+		}
+	}
+
+	fund := PostEntry{EntryID: "e1", Legs: []Leg{
+		{AccountID: "alice", Asset: "ETH", Side: Debit, Amount: eth("100")},
+		{AccountID: "treasury", Asset: "ETH", Side: Credit, Amount: eth("100")},
+	}}
+	if err := dispatch(fund); err != nil {
+		t.Fatalf("fund: %v", err)
+	}
+	if err := dispatch(fund); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("posting e1 twice: got %v", err)
+	}
+
+	unbalanced := PostEntry{EntryID: "e2", Legs: []Leg{
+		{AccountID: "alice", Asset: "ETH", Side: Credit, Amount: eth("10")},
+		{AccountID: "bob", Asset: "USDC", Side: Debit, Amount: model.MustParseAmount("10", 6)},
+	}}
+	var invalid *command.ValidationError
+	if err := dispatch(unbalanced); !errors.As(err, &invalid) {
+		t.Errorf("unbalanced entry: got %v", err)
+	}
+
+	overdraft := PostEntry{EntryID: "e3", Legs: []Leg{
+		{AccountID: "alice", Asset: "ETH", Side: Credit, Amount: eth("150")},
+		{AccountID: "bob", Asset: "ETH", Side: Debit, Amount: eth("150")},
+	}}
+	if err := dispatch(overdraft); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("overdraft: got %v", err)
+	}
+
+	transfer := PostEntry{EntryID: "e4", Legs: []Leg{
+		{AccountID: "alice", Asset: "ETH", Side: Credit, Amount: eth("40")},
+		{AccountID: "bob", Asset: "ETH", Side: Debit, Amount: eth("40")},
+	}}
+	if err := dispatch(transfer); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+
+	if err := dispatch(CloseAccount{AccountID: "bob"}); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("closing funded account: got %v", err)
+	}
+	if err := dispatch(PostEntry{EntryID: "e5", Legs: []Leg{
+		{AccountID: "bob", Asset: "ETH", Side: Credit, Amount: eth("40")},
+		{AccountID: "alice", Asset: "ETH", Side: Debit, Amount: eth("40")},
+	}}); err != nil {
+		t.Fatalf("return: %v", err)
+	}
+	if err := dispatch(CloseAccount{AccountID: "bob"}); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	deposit := PostEntry{EntryID: "e6", Legs: []Leg{
+		{AccountID: "bob", Asset: "ETH", Side: Debit, Amount: eth("1")},
+		{AccountID: "treasury", Asset: "ETH", Side: Credit, Amount: eth("1")},
+	}}
+	if err := dispatch(deposit); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("deposit to closed account: got %v", err)
+	}
+
+	balances := NewBalances()
+	if err := balances.CatchUp(ctx, store); err != nil {
+		t.Fatalf("catch up: %v", err)
+	}
+	for account, want := range map[string]string{"alice": "100", "bob": "0", "treasury": "100"} {
+		if got, _ := balances.Balance(account, "ETH"); got.Cmp(eth(want)) != 0 {
+			t.Errorf("%s ETH balance = %s, want %s", account, got, want)
+		}
+	}
+
+	mixed := PostEntry{EntryID: "e7", Legs: []Leg{
+		{AccountID: "alice", Asset: "ETH", Side: Credit, Amount: model.MustParseAmount("1", 6)},
+		{AccountID: "treasury", Asset: "ETH", Side: Debit, Amount: model.MustParseAmount("1", 6)},
+	}}
+	if err := dispatch(mixed); !errors.As(err, &invalid) {
+		t.Errorf("amounts without the asset's decimals: got %v", err)
+	}
+}
+
+func TestLegDecimals(t *testing.T) {
+	model.RegisterAsset("ETH", 18)
+	var leg Leg
+	if err := json.Unmarshal([]byte(`{"accountId":"alice","asset":"ETH","side":"debit","amount":"1.5"}`), &leg); err != nil {
+		t.Fatal(err)
+	}
+	if leg.Amount.Decimals() != 18 || leg.Amount.Cmp(eth("1.5")) != 0 {
+		t.Errorf("decoded %s with %d decimals, want 1.5 with 18", leg.Amount, leg.Amount.Decimals())
+	}
+	if err := json.Unmarshal([]byte(`{"asset":"ETH","amount":"0.0000000000000000001"}`), &leg); !errors.Is(err, model.ErrInexact) {
+		t.Errorf("amount below the asset's decimals: got %v", err)
+	}
+}
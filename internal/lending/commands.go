@@ -0,0 +1,512 @@
+package lending
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/command"
+	"defi/internal/model"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+type CreateMarket struct {
+	MarketID    string
+	CloseFactor model.Amount
+}
+
+func (c CreateMarket) Validate() error {
+	if c.MarketID == "" {
+		return errors.New("market ID is required")
+	}
+	return validateFactor("close factor", c.CloseFactor)
+}
+
+type ListReserve struct {
+	MarketID             string
+	Asset                string
+	Decimals             uint8
+	CollateralFactor     model.Amount
+	LiquidationThreshold model.Amount
+	LiquidationBonus     model.Amount
+	ReserveFactor        model.Amount
+	Rates                RateModel
+	At                   int64
+}
+
+func (c ListReserve) Validate() error {
+	if c.MarketID == "" || c.Asset == "" {
+		return errors.New("market ID and asset are required")
+	}
+	for name, f := range map[string]model.Amount{
+		"collateral factor":     c.CollateralFactor,
+		"liquidation threshold": c.LiquidationThreshold,
+		"liquidation bonus":     c.LiquidationBonus,
+		"reserve factor":        c.ReserveFactor,
+		"kink":                  c.Rates.Kink,
+	} {
+		if err := validateFactor(name, f); err != nil {
+			return err
+		}
+	}
+	if c.LiquidationThreshold.Cmp(c.CollateralFactor) < 0 {
+		return errors.New("liquidation threshold must not be below the collateral factor")
+	}
+	if c.Rates.BaseRate.Sign() < 0 || c.Rates.Slope1.Sign() < 0 || c.Rates.Slope2.Sign() < 0 || c.Rates.PeriodsPerYear < 0 {
+		return errors.New("rates must not be negative")
+	}
+	return nil
+}
+
+func validateFactor(name string, f model.Amount) error {
+	if f.Decimals() != WADDecimals || f.Sign() < 0 || f.Units().Cmp(wad) > 0 {
+		return errors.New(name + " must be between 0 and 1 with 18 decimals")
+	}
+	return nil
+}
+
+// Supply, Withdraw, Borrow and Repay move Amount of Asset for Account. At is
+// the time of the action in the unit of the market's rate models, Unix
+// seconds by default; zero means now, which only markets timed in seconds
+// can tell.
+type Supply struct {
+	MarketID string
+	Account  string
+	Asset    string
+	Amount   model.Amount
+	At       int64
+}
+
+type Withdraw Supply
+
+type Borrow Supply
+
+// Repay repays at most the outstanding debt.
+type Repay Supply
+
+func (c Supply) Validate() error   { return validateMovement(c) }
+func (c Withdraw) Validate() error { return validateMovement(Supply(c)) }
+func (c Borrow) Validate() error   { return validateMovement(Supply(c)) }
+func (c Repay) Validate() error    { return validateMovement(Supply(c)) }
+
+func validateMovement(c Supply) error {
+	if c.MarketID == "" || c.Account == "" || c.Asset == "" {
+		return errors.New("market ID, account and asset are required")
+	}
+	if c.Amount.Sign() <= 0 {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
+// Accrue records interest on Asset up to At, e.g. from a scheduler.
+type Accrue struct {
+	MarketID string
+	Asset    string
+	At       int64
+}
+
+func (c Accrue) Validate() error {
+	if c.MarketID == "" || c.Asset == "" {
+		return errors.New("market ID and asset are required")
+	}
+	return nil
+}
+
+// Liquidate repays up to Amount of Account's DebtAsset debt, capped by the
+// close factor, and seizes CollateralAsset worth the repayment plus the
+// liquidation bonus.
+type Liquidate struct {
+	MarketID        string
+	Liquidator      string
+	Account         string
+	DebtAsset       string
+	CollateralAsset string
+	Amount          model.Amount
+	At              int64
+}
+
+func (c Liquidate) Validate() error {
+	switch {
+	case c.MarketID == "" || c.Liquidator == "" || c.Account == "":
+		return errors.New("market ID, liquidator and account are required")
+	case c.DebtAsset == "" || c.CollateralAsset == "":
+		return errors.New("debt and collateral assets are required")
+	case c.Liquidator == c.Account:
+		return errors.New("accounts cannot liquidate themselves")
+	case c.Amount.Sign() <= 0:
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
+// DefaultMaxPriceAge is the default of Markets.MaxPriceAge.
+const DefaultMaxPriceAge = 2 * time.Minute
+
+// Markets handles lending commands, valuing positions with Price.
+type Markets struct {
+	// MaxPriceAge is how old a price may be for a liquidation; zero means
+	// DefaultMaxPriceAge.
+	MaxPriceAge time.Duration
+	markets     *aggregate.Repository[*Market]
+	price       PriceFunc
+}
+
+func New(store aggregate.Store, price PriceFunc) *Markets {
+	return &Markets{markets: aggregate.NewRepository(store, MarketType, NewMarket), price: price}
+}
+
+// Register adds the lending handlers to bus.
+func (s *Markets) Register(bus *command.Bus) {
+	command.Register(bus, command.Handle(s.markets, func(c CreateMarket) string { return c.MarketID }, s.createMarket))
+	command.Register(bus, command.Handle(s.markets, func(c ListReserve) string { return c.MarketID }, s.listReserve))
+	command.Register(bus, command.Handle(s.markets, func(c Accrue) string { return c.MarketID }, s.accrue))
+	command.Register(bus, command.Handle(s.markets, func(c Supply) string { return c.MarketID }, s.supply))
+	command.Register(bus, command.Handle(s.markets, func(c Withdraw) string { return c.MarketID }, s.withdraw))
+	command.Register(bus, command.Handle(s.markets, func(c Borrow) string { return c.MarketID }, s.borrow))
+	command.Register(bus, command.Handle(s.markets, func(c Repay) string { return c.MarketID }, s.repay))
+	command.Register(bus, command.Handle(s.markets, func(c Liquidate) string { return c.MarketID }, s.liquidate))
+}
+
+// Market loads the current state of a market.
+func (s *Markets) Market(ctx context.Context, id string) (*aggregate.Root[*Market], error) {
+	return s.markets.Load(ctx, id)
+}
+
+func (s *Markets) createMarket(ctx context.Context, c CreateMarket, root *aggregate.Root[*Market]) error {
+	return root.Raise(EventMarketCreated, MarketCreated{CloseFactor: c.CloseFactor})
+}
+
+func (s *Markets) listReserve(ctx context.Context, c ListReserve, root *aggregate.Root[*Market]) error {
+	if _, ok := root.State.Reserves[c.Asset]; ok {
+		return command.Rejectf("%s is already listed", c.Asset)
+	}
+	for _, r := range root.State.Reserves {
+		// accrueAll advances every reserve to the same time.
+		if r.Rates.inSeconds() != c.Rates.inSeconds() {
+			return command.Rejectf("%s is timed in %s, unlike %s", c.Asset, c.Rates.unit(), r.Asset)
+		}
+	}
+	at, err := now(root, c.Rates, c.At)
+	if err != nil {
+		return err
+	}
+	return root.Raise(EventReserveListed, ReserveListed{
+		Asset:                c.Asset,
+		Decimals:             c.Decimals,
+		CollateralFactor:     c.CollateralFactor,
+		LiquidationThreshold: c.LiquidationThreshold,
+		LiquidationBonus:     c.LiquidationBonus,
+		ReserveFactor:        c.ReserveFactor,
+		Rates:                c.Rates,
+		At:                   at,
+	})
+}
+
+func (s *Markets) accrue(ctx context.Context, c Accrue, root *aggregate.Root[*Market]) error {
+	r, err := listed(root, c.Asset, model.Amount{})
+	if err != nil {
+		return err
+	}
+	at, err := now(root, r.Rates, c.At)
+	if err != nil {
+		return err
+	}
+	return accrueReserve(root, r, at)
+}
+
+func (s *Markets) supply(ctx context.Context, c Supply, root *aggregate.Root[*Market]) error {
+	r, err := listed(root, c.Asset, c.Amount)
+	if err != nil {
+		return err
+	}
+	at, err := now(root, r.Rates, c.At)
+	if err != nil {
+		return err
+	}
+	if err := accrueReserve(root, r, at); err != nil {
+		return err
+	}
+	scaled := mulDiv(c.Amount.Units(), wad, r.SupplyIndex, false)
+	return raiseMovement(root, EventSupplied, Supply(c), scaled)
+}
+
+func (s *Markets) withdraw(ctx context.Context, c Withdraw, root *aggregate.Root[*Market]) error {
+	r, err := listed(root, c.Asset, c.Amount)
+	if err != nil {
+		return err
+	}
+	at, err := now(root, r.Rates, c.At)
+	if err != nil {
+		return err
+	}
+	if err := accrueAll(root, at); err != nil {
+		return err
+	}
+	supplied := root.State.Supplied(c.Account, c.Asset)
+	if c.Amount.Units().Cmp(supplied) > 0 {
+		return command.Rejectf("%s has %s %s supplied", c.Account, supplied, c.Asset)
+	}
+	if c.Amount.Units().Cmp(r.Cash()) > 0 {
+		return command.Rejectf("not enough %s cash in the market", c.Asset)
+	}
+	scaled := mulDiv(c.Amount.Units(), wad, r.SupplyIndex, true)
+	if c.Amount.Units().Cmp(supplied) == 0 {
+		// Withdrawing everything clears the position without dust.
+		scaled = root.State.Positions[c.Account].get(root.State.Positions[c.Account].Supplied, c.Asset)
+	}
+	if err := raiseMovement(root, EventWithdrawn, Supply(c), scaled); err != nil {
+		return err
+	}
+	return s.checkBorrowLimit(root, c.Account)
+}
+
+func (s *Markets) borrow(ctx context.Context, c Borrow, root *aggregate.Root[*Market]) error {
+	r, err := listed(root, c.Asset, c.Amount)
+	if err != nil {
+		return err
+	}
+	at, err := now(root, r.Rates, c.At)
+	if err != nil {
+		return err
+	}
+	if err := accrueAll(root, at); err != nil {
+		return err
+	}
+	if c.Amount.Units().Cmp(r.Cash()) > 0 {
+		return command.Rejectf("not enough %s cash in the market", c.Asset)
+	}
+	scaled := mulDiv(c.Amount.Units(), wad, r.BorrowIndex, true)
+	if err := raiseMovement(root, EventBorrowed, Supply(c), scaled); err != nil {
+		return err
+	}
+	return s.checkBorrowLimit(root, c.Account)
+}
+
+func (s *Markets) repay(ctx context.Context, c Repay, root *aggregate.Root[*Market]) error {
+	r, err := listed(root, c.Asset, c.Amount)
+	if err != nil {
+		return err
+	}
+	at, err := now(root, r.Rates, c.At)
+	if err != nil {
+		return err
+	}
+	if err := accrueReserve(root, r, at); err != nil {
+		return err
+	}
+	debt := root.State.Debt(c.Account, c.Asset)
+	if debt.Sign() == 0 {
+		return command.Rejectf("%s owes no %s", c.Account, c.Asset)
+	}
+	if c.Amount.Units().Cmp(debt) >= 0 {
+		amount, err := model.NewAmount(debt, r.Decimals)
+		if err != nil {
+			return err
+		}
+		c.Amount = amount
+		p := root.State.Positions[c.Account]
+		return raiseMovement(root, EventRepaid, Supply(c), p.get(p.Debt, c.Asset))
+	}
+	scaled := mulDiv(c.Amount.Units(), wad, r.BorrowIndex, false)
+	return raiseMovement(root, EventRepaid, Supply(c), scaled)
+}
+
+func (s *Markets) liquidate(ctx context.Context, c Liquidate, root *aggregate.Root[*Market]) error {
+	debtReserve, err := listed(root, c.DebtAsset, c.Amount)
+	if err != nil {
+		return err
+	}
+	collReserve, err := listed(root, c.CollateralAsset, model.Amount{})
+	if err != nil {
+		return err
+	}
+	at, err := now(root, debtReserve.Rates, c.At)
+	if err != nil {
+		return err
+	}
+	if err := accrueAll(root, at); err != nil {
+		return err
+	}
+	m := root.State
+
+	health, err := m.Health(c.Account, s.price)
+	if err != nil {
+		return command.Rejectf("cannot value %s: %v", c.Account, err)
+	}
+	if !health.Liquidatable() {
+		return command.Rejectf("%s has health factor %s and cannot be liquidated", c.Account, health.Factor)
+	}
+	debtQuote, err := s.tracedPrice(c.DebtAsset)
+	if err != nil {
+		return err
+	}
+	collQuote, err := s.tracedPrice(c.CollateralAsset)
+	if err != nil {
+		return err
+	}
+	debtPrice, collPrice := debtQuote.Value, collQuote.Value
+
+	maxRepay := mulDiv(m.Debt(c.Account, c.DebtAsset), m.CloseFactor, wad, false)
+	repay := c.Amount.Units()
+	if repay.Cmp(maxRepay) > 0 {
+		repay = maxRepay
+	}
+	// seized = repay * debtPrice/collPrice * (1+bonus), converted between the
+	// assets' decimals.
+	bonus := new(big.Int).Add(wad, collReserve.LiquidationBonus)
+	num := new(big.Int).Mul(debtPrice.Units(), pow10(collPrice.Decimals()))
+	num.Mul(num, pow10(collReserve.Decimals)).Mul(num, bonus)
+	den := new(big.Int).Mul(collPrice.Units(), pow10(debtPrice.Decimals()))
+	den.Mul(den, pow10(debtReserve.Decimals)).Mul(den, wad)
+	seized := mulDiv(repay, num, den, false)
+	if available := m.Supplied(c.Account, c.CollateralAsset); seized.Cmp(available) > 0 {
+		// Not enough collateral left: take all of it and repay only what it
+		// covers.
+		seized = available
+		repay = mulDiv(seized, den, num, true)
+	}
+	if repay.Sign() == 0 || seized.Sign() == 0 {
+		return command.Rejectf("nothing to liquidate")
+	}
+
+	p := m.Positions[c.Account]
+	repaidScaled := mulDiv(repay, wad, debtReserve.BorrowIndex, false)
+	if owed := p.get(p.Debt, c.DebtAsset); repaidScaled.Cmp(owed) > 0 {
+		repaidScaled = owed
+	}
+	seizedScaled := mulDiv(seized, wad, collReserve.SupplyIndex, true)
+	if held := p.get(p.Supplied, c.CollateralAsset); seizedScaled.Cmp(held) > 0 {
+		seizedScaled = held
+	}
+
+	e := Liquidated{
+		Liquidator:           c.Liquidator,
+		Account:              c.Account,
+		DebtAsset:            c.DebtAsset,
+		CollateralAsset:      c.CollateralAsset,
+		DebtPrice:            debtPrice,
+		CollateralPrice:      collPrice,
+		DebtPriceEvent:       debtQuote.EventID,
+		CollateralPriceEvent: collQuote.EventID,
+		HealthFactor:         health.Factor,
+	}
+	for _, f := range []struct {
+		dst      *model.Amount
+		v        *big.Int
+		decimals uint8
+	}{
+		{&e.Repaid, repay, debtReserve.Decimals},
+		{&e.RepaidScaled, repaidScaled, debtReserve.Decimals},
+		{&e.Seized, seized, collReserve.Decimals},
+		{&e.SeizedScaled, seizedScaled, collReserve.Decimals},
+	} {
+		if *f.dst, err = model.NewAmount(f.v, f.decimals); err != nil {
+			return err
+		}
+	}
+	return root.Raise(EventLiquidated, e)
+}
+
+// tracedPrice returns the price of asset, which must come from a stored
+// event so the liquidation can be audited, and be recent.
+func (s *Markets) tracedPrice(asset string) (Price, error) {
+	p, ok := s.price(asset)
+	if !ok || p.Value.Sign() <= 0 {
+		return Price{}, command.Rejectf("no price for %s", asset)
+	}
+	if p.EventID == "" {
+		return Price{}, command.Rejectf("price of %s is not backed by a stored event", asset)
+	}
+	maxAge := s.MaxPriceAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxPriceAge
+	}
+	if age := time.Since(time.UnixMilli(p.At)); age > maxAge {
+		return Price{}, command.Rejectf("price of %s is %s old, more than %s", asset, age.Truncate(time.Second), maxAge)
+	}
+	return p, nil
+}
+
+// checkBorrowLimit rejects the command if account's debt now exceeds what
+// its collateral allows.
+func (s *Markets) checkBorrowLimit(root *aggregate.Root[*Market], account string) error {
+	health, err := root.State.Health(account, s.price)
+	if err != nil {
+		return command.Rejectf("cannot value %s: %v", account, err)
+	}
+	if health.Debt.Cmp(health.BorrowLimit) > 0 {
+		return command.Rejectf("%s would owe %s against a borrow limit of %s", account, health.Debt, health.BorrowLimit)
+	}
+	return nil
+}
+
+func listed(root *aggregate.Root[*Market], asset string, amount model.Amount) (*Reserve, error) {
+	if root.State.Status != model.StateActive {
+		return nil, command.Rejectf("market %s does not exist", root.ID)
+	}
+	r := root.State.Reserves[asset]
+	if r == nil {
+		return nil, command.Rejectf("%s is not listed in market %s", asset, root.ID)
+	}
+	if amount.Sign() != 0 && amount.Decimals() != r.Decimals {
+		return nil, command.Rejectf("%s amounts must have %d decimals", asset, r.Decimals)
+	}
+	return r, nil
+}
+
+// accrueReserve raises InterestAccrued for r up to at, if time has passed.
+func accrueReserve(root *aggregate.Root[*Market], r *Reserve, at int64) error {
+	if at <= r.LastAccrual {
+		return nil
+	}
+	borrowIndex, supplyIndex, borrowRate, supplyRate := r.accrue(at)
+	e := InterestAccrued{Asset: r.Asset, At: at}
+	for _, f := range []struct {
+		dst *model.Amount
+		v   *big.Int
+	}{{&e.BorrowIndex, borrowIndex}, {&e.SupplyIndex, supplyIndex}, {&e.BorrowRate, borrowRate}, {&e.SupplyRate, supplyRate}} {
+		amount, err := model.NewAmount(f.v, WADDecimals)
+		if err != nil {
+			return err
+		}
+		*f.dst = amount
+	}
+	return root.Raise(EventInterestAccrued, e)
+}
+
+// accrueAll accrues every reserve, in asset order so replays match.
+func accrueAll(root *aggregate.Root[*Market], at int64) error {
+	assets := make([]string, 0, len(root.State.Reserves))
+	for asset := range root.State.Reserves {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	for _, asset := range assets {
+		if err := accrueReserve(root, root.State.Reserves[asset], at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func raiseMovement(root *aggregate.Root[*Market], eventType string, c Supply, scaled *big.Int) error {
+	amount, err := model.NewAmount(scaled, c.Amount.Decimals())
+	if err != nil {
+		return err
+	}
+	return root.Raise(eventType, Movement{Account: c.Account, Asset: c.Asset, Amount: c.Amount, Scaled: amount})
+}
+
+// now returns at, or the current Unix time if at is zero. Markets timed in
+// blocks cannot tell the current block, so their commands must give it.
+func now(root *aggregate.Root[*Market], rates RateModel, at int64) (int64, error) {
+	if at != 0 {
+		return at, nil
+	}
+	if !rates.inSeconds() {
+		return 0, command.Rejectf("market %s is timed in %s and needs an explicit time", root.ID, rates.unit())
+	}
+	return time.Now().Unix(), nil
+}
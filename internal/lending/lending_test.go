@@ -0,0 +1,125 @@
+package lending
+
+import (
+	"context"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMarket(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	prices := map[string]model.Amount{"ETH": usd("2000"), "USDC": usd("1")}
+	observed := time.Now().UnixMilli()
+	price := func(asset string) (Price, bool) {
+		p, ok := prices[asset]
+		return Price{Value: p, EventID: "price-" + asset + "-" + p.String(), At: observed}, ok
+	}
+	markets := New(store, price)
+	bus := command.NewBus()
+	markets.Register(bus)
+	dispatch := func(cmd interface{}) error { return bus.Dispatch(ctx, cmd) }
+
+	const start = 1700000000
+	steps := []interface{}{
+		CreateMarket{MarketID: "m", CloseFactor: wadOf("0.5")},
+		ListReserve{MarketID: "m", Asset: "ETH", Decimals: 18, CollateralFactor: wadOf("0.8"), LiquidationThreshold: wadOf("0.85"), LiquidationBonus: wadOf("0.05"), ReserveFactor: wadOf("0.1"), Rates: rates(), At: start},
+		ListReserve{MarketID: "m", Asset: "USDC", Decimals: 6, CollateralFactor: wadOf("0.8"), LiquidationThreshold: wadOf("0.85"), LiquidationBonus: wadOf("0.05"), ReserveFactor: wadOf("0.1"), Rates: rates(), At: start},
+		Supply{MarketID: "m", Account: "alice", Asset: "ETH", Amount: model.MustParseAmount("10", 18), At: start},
+		Supply{MarketID: "m", Account: "bob", Asset: "USDC", Amount: usdc("100000"), At: start},
+		Borrow{MarketID: "m", Account: "alice", Asset: "USDC", Amount: usdc("15000"), At: start},
+	}
+	for _, cmd := range steps {
+		if err := dispatch(cmd); err != nil {
+			t.Fatalf("%T: %v", cmd, err)
+		}
+	}
+	if err := dispatch(Borrow{MarketID: "m", Account: "alice", Asset: "USDC", Amount: usdc("2000"), At: start}); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("borrowing past the limit: got %v", err)
+	}
+	liquidate := Liquidate{MarketID: "m", Liquidator: "carol", Account: "alice", DebtAsset: "USDC", CollateralAsset: "ETH", Amount: usdc("100000"), At: start + SecondsPerYear}
+	if err := dispatch(liquidate); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("liquidating a healthy account: got %v", err)
+	}
+
+	// A year at 15% utilization: 0.04 * 0.15/0.8 = 0.75% on the debt.
+	if err := dispatch(Accrue{MarketID: "m", Asset: "USDC", At: start + SecondsPerYear}); err != nil {
+		t.Fatalf("accrue: %v", err)
+	}
+	root, err := markets.Market(ctx, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if debt := root.State.Debt("alice", "USDC"); debt.String() != "15112500000" {
+		t.Errorf("debt after a year = %s, want 15112500000", debt)
+	}
+
+	prices["ETH"] = usd("1700")
+	positions := NewPositions(price)
+	if err := positions.CatchUp(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if atRisk, err := positions.AtRisk("m"); err != nil || len(atRisk) != 1 || atRisk[0] != "alice" {
+		t.Errorf("at risk = %v, %v", atRisk, err)
+	}
+
+	observed = time.Now().Add(-time.Hour).UnixMilli()
+	if err := dispatch(liquidate); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("liquidating at a stale price: got %v", err)
+	}
+	observed = time.Now().UnixMilli()
+	if err := dispatch(liquidate); err != nil {
+		t.Fatalf("liquidate: %v", err)
+	}
+	events, _ := store.LoadEvents(ctx, "m", 0)
+	var e Liquidated
+	if err := decode(events[len(events)-1], &e); err != nil {
+		t.Fatal(err)
+	}
+	// Half the debt, paid in ETH at 1700 plus a 5% bonus.
+	if e.Repaid.String() != "7556.250000" || e.Seized.String() != "4.667095588235294117" || e.CollateralPriceEvent != "price-ETH-1700.00000000" {
+		t.Errorf("unexpected liquidation %+v", e)
+	}
+	if err := positions.CatchUp(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	health, err := positions.Health("m", "carol")
+	if err != nil || health.Collateral.Sign() <= 0 {
+		t.Errorf("liquidator should hold the seized collateral: %+v, %v", health, err)
+	}
+
+	// Markets timed in blocks cannot default to the wall clock, nor mix
+	// with seconds.
+	blocks := rates()
+	blocks.PeriodsPerYear = 2628000
+	list := ListReserve{MarketID: "b", Asset: "ETH", Decimals: 18, CollateralFactor: wadOf("0.8"), LiquidationThreshold: wadOf("0.85"), LiquidationBonus: wadOf("0.05"), ReserveFactor: wadOf("0.1"), Rates: blocks}
+	if err := dispatch(CreateMarket{MarketID: "b", CloseFactor: wadOf("0.5")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatch(list); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("listing a block-timed reserve without a block: got %v", err)
+	}
+	list.At = 100
+	if err := dispatch(list); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatch(Supply{MarketID: "b", Account: "alice", Asset: "ETH", Amount: model.MustParseAmount("1", 18)}); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("supplying without a block: got %v", err)
+	}
+	list.Asset, list.Decimals, list.Rates = "USDC", 6, rates()
+	if err := dispatch(list); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("listing a reserve timed in seconds: got %v", err)
+	}
+}
+
+func rates() RateModel {
+	return RateModel{BaseRate: wadOf("0"), Slope1: wadOf("0.04"), Slope2: wadOf("0.75"), Kink: wadOf("0.8")}
+}
+
+func wadOf(s string) model.Amount { return model.MustParseAmount(s, WADDecimals) }
+func usd(s string) model.Amount   { return model.MustParseAmount(s, 8) }
+func usdc(s string) model.Amount  { return model.MustParseAmount(s, 6) }
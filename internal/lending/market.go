@@ -0,0 +1,395 @@
+package lending
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/model"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// Aggregate and event types of lending markets.
+const (
+	MarketType = "lending_market"
+
+	EventMarketCreated   = "MarketCreated"
+	EventReserveListed   = "ReserveListed"
+	EventInterestAccrued = "InterestAccrued"
+	EventSupplied        = "Supplied"
+	EventWithdrawn       = "Withdrawn"
+	EventBorrowed        = "Borrowed"
+	EventRepaid          = "Repaid"
+	EventLiquidated      = "Liquidated"
+)
+
+type MarketCreated struct {
+	// CloseFactor is the largest share of a debt one liquidation may repay.
+	CloseFactor model.Amount `json:"closeFactor"`
+}
+
+type ReserveListed struct {
+	Asset    string `json:"asset"`
+	Decimals uint8  `json:"decimals"`
+	// CollateralFactor bounds borrowing against the asset, and
+	// LiquidationThreshold, at least as high, bounds the health factor.
+	CollateralFactor     model.Amount `json:"collateralFactor"`
+	LiquidationThreshold model.Amount `json:"liquidationThreshold"`
+	// LiquidationBonus is the extra collateral liquidators receive.
+	LiquidationBonus model.Amount `json:"liquidationBonus"`
+	ReserveFactor    model.Amount `json:"reserveFactor"`
+	Rates            RateModel    `json:"rates"`
+	At               int64        `json:"at"`
+}
+
+// InterestAccrued records the indices reached at At; balances are stored
+// scaled by them, so this single event accrues interest for every position.
+type InterestAccrued struct {
+	Asset       string       `json:"asset"`
+	At          int64        `json:"at"`
+	BorrowRate  model.Amount `json:"borrowRate"`
+	SupplyRate  model.Amount `json:"supplyRate"`
+	BorrowIndex model.Amount `json:"borrowIndex"`
+	SupplyIndex model.Amount `json:"supplyIndex"`
+}
+
+// Movement is the payload of Supplied, Withdrawn, Borrowed and Repaid.
+// Scaled is Amount divided by the asset's index at the time.
+type Movement struct {
+	Account string       `json:"account"`
+	Asset   string       `json:"asset"`
+	Amount  model.Amount `json:"amount"`
+	Scaled  model.Amount `json:"scaled"`
+}
+
+// Liquidated records a liquidation together with the prices it used and the
+// events they came from, so it can be audited and replayed without the price
+// source.
+type Liquidated struct {
+	Liquidator      string       `json:"liquidator"`
+	Account         string       `json:"account"`
+	DebtAsset       string       `json:"debtAsset"`
+	CollateralAsset string       `json:"collateralAsset"`
+	Repaid          model.Amount `json:"repaid"`
+	RepaidScaled    model.Amount `json:"repaidScaled"`
+	Seized          model.Amount `json:"seized"`
+	SeizedScaled    model.Amount `json:"seizedScaled"`
+	DebtPrice       model.Amount `json:"debtPrice"`
+	CollateralPrice model.Amount `json:"collateralPrice"`
+	// DebtPriceEvent and CollateralPriceEvent are the IDs of the events the
+	// prices were recorded in.
+	DebtPriceEvent       string       `json:"debtPriceEvent"`
+	CollateralPriceEvent string       `json:"collateralPriceEvent"`
+	HealthFactor         model.Amount `json:"healthFactor"`
+}
+
+// Reserve is the state of one asset in a market. Amounts are base units of
+// the asset; factors, rates and indices are WAD.
+type Reserve struct {
+	Asset                string
+	Decimals             uint8
+	CollateralFactor     *big.Int
+	LiquidationThreshold *big.Int
+	LiquidationBonus     *big.Int
+	ReserveFactor        *big.Int
+	Rates                RateModel
+	BorrowIndex          *big.Int
+	SupplyIndex          *big.Int
+	BorrowRate           *big.Int
+	SupplyRate           *big.Int
+	ScaledSupply         *big.Int
+	ScaledDebt           *big.Int
+	LastAccrual          int64
+}
+
+// Cash is what suppliers could withdraw or borrowers borrow right now.
+func (r *Reserve) Cash() *big.Int {
+	supplied := mulDiv(r.ScaledSupply, r.SupplyIndex, wad, false)
+	return supplied.Sub(supplied, mulDiv(r.ScaledDebt, r.BorrowIndex, wad, true))
+}
+
+// Position holds an account's scaled supply and debt per asset.
+type Position struct {
+	Supplied map[string]*big.Int
+	Debt     map[string]*big.Int
+}
+
+func (p *Position) get(m map[string]*big.Int, asset string) *big.Int {
+	if v := m[asset]; v != nil {
+		return v
+	}
+	return new(big.Int)
+}
+
+// Market is the state of a lending market aggregate.
+type Market struct {
+	Status      model.State
+	CloseFactor *big.Int
+	Reserves    map[string]*Reserve
+	Positions   map[string]*Position
+}
+
+var marketLifecycle = aggregate.NewMachine[*Market](model.StateInitial).
+	Allow(model.StateInitial, EventMarketCreated, model.StateActive).
+	Allow(model.StateActive, EventReserveListed, model.StateActive).
+	Allow(model.StateActive, EventInterestAccrued, model.StateActive).
+	Allow(model.StateActive, EventSupplied, model.StateActive).
+	Allow(model.StateActive, EventWithdrawn, model.StateActive).
+	Allow(model.StateActive, EventBorrowed, model.StateActive).
+	Allow(model.StateActive, EventRepaid, model.StateActive).
+	Allow(model.StateActive, EventLiquidated, model.StateActive)
+
+func NewMarket() *Market {
+	return &Market{
+		Reserves:  make(map[string]*Reserve),
+		Positions: make(map[string]*Position),
+	}
+}
+
+func (m *Market) Apply(event model.Event) error {
+	next, err := marketLifecycle.Next(m, m.Status, event)
+	if err != nil {
+		return err
+	}
+	switch event.Type {
+	case EventMarketCreated:
+		var e MarketCreated
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		m.CloseFactor = e.CloseFactor.Units()
+	case EventReserveListed:
+		var e ReserveListed
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		m.Reserves[e.Asset] = &Reserve{
+			Asset:                e.Asset,
+			Decimals:             e.Decimals,
+			CollateralFactor:     e.CollateralFactor.Units(),
+			LiquidationThreshold: e.LiquidationThreshold.Units(),
+			LiquidationBonus:     e.LiquidationBonus.Units(),
+			ReserveFactor:        e.ReserveFactor.Units(),
+			Rates:                e.Rates,
+			BorrowIndex:          new(big.Int).Set(wad),
+			SupplyIndex:          new(big.Int).Set(wad),
+			BorrowRate:           new(big.Int),
+			SupplyRate:           new(big.Int),
+			ScaledSupply:         new(big.Int),
+			ScaledDebt:           new(big.Int),
+			LastAccrual:          e.At,
+		}
+	case EventInterestAccrued:
+		var e InterestAccrued
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		r, err := m.reserve(e.Asset)
+		if err != nil {
+			return err
+		}
+		r.BorrowIndex, r.SupplyIndex = e.BorrowIndex.Units(), e.SupplyIndex.Units()
+		r.BorrowRate, r.SupplyRate = e.BorrowRate.Units(), e.SupplyRate.Units()
+		r.LastAccrual = e.At
+	case EventSupplied, EventWithdrawn, EventBorrowed, EventRepaid:
+		var e Movement
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		r, err := m.reserve(e.Asset)
+		if err != nil {
+			return err
+		}
+		delta := e.Scaled.Units()
+		if event.Type == EventWithdrawn || event.Type == EventRepaid {
+			delta.Neg(delta)
+		}
+		if event.Type == EventSupplied || event.Type == EventWithdrawn {
+			err = m.move(e.Account, r, delta, nil)
+		} else {
+			err = m.move(e.Account, r, nil, delta)
+		}
+		if err != nil {
+			return err
+		}
+	case EventLiquidated:
+		var e Liquidated
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		debt, err := m.reserve(e.DebtAsset)
+		if err != nil {
+			return err
+		}
+		collateral, err := m.reserve(e.CollateralAsset)
+		if err != nil {
+			return err
+		}
+		repaid, seized := e.RepaidScaled.Units(), e.SeizedScaled.Units()
+		if err := m.move(e.Account, debt, nil, new(big.Int).Neg(repaid)); err != nil {
+			return err
+		}
+		// Seized collateral changes hands inside the market, so total supply
+		// is unchanged; the repayment adds cash.
+		if err := m.move(e.Account, collateral, new(big.Int).Neg(seized), nil); err != nil {
+			return err
+		}
+		if err := m.move(e.Liquidator, collateral, seized, nil); err != nil {
+			return err
+		}
+	}
+	m.Status = next
+	return nil
+}
+
+func (m *Market) reserve(asset string) (*Reserve, error) {
+	r := m.Reserves[asset]
+	if r == nil {
+		return nil, fmt.Errorf("asset %s is not listed", asset)
+	}
+	return r, nil
+}
+
+// move adds scaled supply and debt deltas, either of which may be nil, to
+// account and the reserve totals.
+func (m *Market) move(account string, r *Reserve, supply, debt *big.Int) error {
+	p := m.Positions[account]
+	if p == nil {
+		p = &Position{Supplied: make(map[string]*big.Int), Debt: make(map[string]*big.Int)}
+		m.Positions[account] = p
+	}
+	if supply != nil {
+		held := new(big.Int).Add(p.get(p.Supplied, r.Asset), supply)
+		if held.Sign() < 0 {
+			return fmt.Errorf("%s supply of %s would go negative", r.Asset, account)
+		}
+		p.Supplied[r.Asset] = held
+		r.ScaledSupply = new(big.Int).Add(r.ScaledSupply, supply)
+	}
+	if debt != nil {
+		owed := new(big.Int).Add(p.get(p.Debt, r.Asset), debt)
+		if owed.Sign() < 0 {
+			return fmt.Errorf("%s debt of %s would go negative", r.Asset, account)
+		}
+		p.Debt[r.Asset] = owed
+		r.ScaledDebt = new(big.Int).Add(r.ScaledDebt, debt)
+	}
+	return nil
+}
+
+func decode(event model.Event, out interface{}) error {
+	if err := json.Unmarshal([]byte(event.Data), out); err != nil {
+		return fmt.Errorf("invalid %s: %w", event.Type, err)
+	}
+	return nil
+}
+
+// Supplied returns what account could withdraw of asset, interest included
+// up to the last accrual.
+func (m *Market) Supplied(account, asset string) *big.Int {
+	r, p := m.Reserves[asset], m.Positions[account]
+	if r == nil || p == nil {
+		return new(big.Int)
+	}
+	return mulDiv(p.get(p.Supplied, asset), r.SupplyIndex, wad, false)
+}
+
+// Debt returns what account owes of asset, rounded up.
+func (m *Market) Debt(account, asset string) *big.Int {
+	r, p := m.Reserves[asset], m.Positions[account]
+	if r == nil || p == nil {
+		return new(big.Int)
+	}
+	return mulDiv(p.get(p.Debt, asset), r.BorrowIndex, wad, true)
+}
+
+// Price is the price of one whole unit of an asset in a common quote asset.
+// Prices of different assets may use different decimals.
+type Price struct {
+	Value model.Amount
+	// EventID names the stored event the price comes from, such as an
+	// oracle PriceUpdated. Liquidations require it.
+	EventID string
+	// At is when the price was observed, in Unix milliseconds. Liquidations
+	// reject prices older than MaxPriceAge.
+	At int64
+}
+
+// PriceFunc looks up the current price of asset.
+type PriceFunc func(asset string) (Price, bool)
+
+// Health summarizes an account's position in WAD units of the quote asset.
+type Health struct {
+	Collateral model.Amount
+	// BorrowLimit is collateral weighted by collateral factors, and
+	// LiquidationLimit by liquidation thresholds.
+	BorrowLimit      model.Amount
+	LiquidationLimit model.Amount
+	Debt             model.Amount
+	// Factor is LiquidationLimit/Debt; below 1 the account can be
+	// liquidated. It is zero when there is no debt.
+	Factor model.Amount
+}
+
+// Liquidatable reports whether the health factor is below 1.
+func (h Health) Liquidatable() bool {
+	return h.Debt.Sign() > 0 && h.Factor.Units().Cmp(wad) < 0
+}
+
+// Health values account's position with price.
+func (m *Market) Health(account string, price PriceFunc) (Health, error) {
+	collateral, borrowLimit, liquidationLimit, debt := new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+	if p := m.Positions[account]; p != nil {
+		for asset := range p.Supplied {
+			amount := m.Supplied(account, asset)
+			if amount.Sign() == 0 {
+				continue
+			}
+			v, err := m.value(asset, amount, price)
+			if err != nil {
+				return Health{}, err
+			}
+			r := m.Reserves[asset]
+			collateral.Add(collateral, v)
+			borrowLimit.Add(borrowLimit, mulDiv(v, r.CollateralFactor, wad, false))
+			liquidationLimit.Add(liquidationLimit, mulDiv(v, r.LiquidationThreshold, wad, false))
+		}
+		for asset := range p.Debt {
+			amount := m.Debt(account, asset)
+			if amount.Sign() == 0 {
+				continue
+			}
+			v, err := m.value(asset, amount, price)
+			if err != nil {
+				return Health{}, err
+			}
+			debt.Add(debt, v)
+		}
+	}
+
+	factor := new(big.Int)
+	if debt.Sign() > 0 {
+		factor = mulDiv(liquidationLimit, wad, debt, false)
+	}
+	h := Health{}
+	for _, f := range []struct {
+		dst *model.Amount
+		v   *big.Int
+	}{{&h.Collateral, collateral}, {&h.BorrowLimit, borrowLimit}, {&h.LiquidationLimit, liquidationLimit}, {&h.Debt, debt}, {&h.Factor, factor}} {
+		amount, err := model.NewAmount(f.v, WADDecimals)
+		if err != nil {
+			return Health{}, err
+		}
+		*f.dst = amount
+	}
+	return h, nil
+}
+
+// value converts amount base units of asset to WAD units of the quote asset.
+func (m *Market) value(asset string, amount *big.Int, price PriceFunc) (*big.Int, error) {
+	p, ok := price(asset)
+	if !ok || p.Value.Sign() <= 0 {
+		return nil, fmt.Errorf("no price for %s", asset)
+	}
+	scale := new(big.Int).Mul(pow10(m.Reserves[asset].Decimals), pow10(p.Value.Decimals()))
+	return mulDiv(new(big.Int).Mul(amount, p.Value.Units()), wad, scale, false), nil
+}
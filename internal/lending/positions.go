@@ -0,0 +1,57 @@
+package lending
+
+import (
+	"defi/internal/eventstore"
+	"fmt"
+	"sort"
+)
+
+// Positions is a read model of every market, used to watch account health
+// as prices and interest move. Feed it market events with Handle or CatchUp;
+// events already seen are ignored.
+type Positions struct {
+	*eventstore.ReadModel[*Market]
+	price PriceFunc
+}
+
+func NewPositions(price PriceFunc) *Positions {
+	return &Positions{ReadModel: eventstore.NewReadModel(MarketType, NewMarket, (*Market).Apply), price: price}
+}
+
+// Health values account in marketID at current prices, with interest up to
+// the market's last accrual.
+func (p *Positions) Health(marketID, account string) (Health, error) {
+	var health Health
+	var err error
+	if !p.View(marketID, func(market *Market, _ int64) {
+		health, err = market.Health(account, p.price)
+	}) {
+		return Health{}, fmt.Errorf("unknown market %s", marketID)
+	}
+	return health, err
+}
+
+// AtRisk returns the accounts of marketID that can be liquidated at current
+// prices, sorted.
+func (p *Positions) AtRisk(marketID string) ([]string, error) {
+	var accounts []string
+	var err error
+	if !p.View(marketID, func(market *Market, _ int64) {
+		for account := range market.Positions {
+			var health Health
+			if health, err = market.Health(account, p.price); err != nil {
+				return
+			}
+			if health.Liquidatable() {
+				accounts = append(accounts, account)
+			}
+		}
+	}) {
+		return nil, fmt.Errorf("unknown market %s", marketID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
@@ -0,0 +1,107 @@
+package lending
+
+import (
+	"defi/internal/model"
+	"math/big"
+)
+
+// WADDecimals is the precision of rates, indices and factors: 1.0 is 10^18.
+const WADDecimals = 18
+
+// SecondsPerYear is the default RateModel.PeriodsPerYear, for markets timed
+// in Unix seconds.
+const SecondsPerYear = 365 * 24 * 60 * 60
+
+var wad = pow10(WADDecimals)
+
+// RateModel is a kinked utilization curve: the borrow rate rises from
+// BaseRate by Slope1 as utilization approaches Kink, then by Slope2 more
+// steeply up to full utilization. Rates are per year with 18 decimals.
+type RateModel struct {
+	BaseRate model.Amount `json:"baseRate"`
+	Slope1   model.Amount `json:"slope1"`
+	Slope2   model.Amount `json:"slope2"`
+	Kink     model.Amount `json:"kink"`
+	// PeriodsPerYear converts the yearly rates to the unit of command times:
+	// SecondsPerYear when they are Unix seconds, or blocks per year when they
+	// are block numbers. Zero means SecondsPerYear.
+	PeriodsPerYear int64 `json:"periodsPerYear,omitempty"`
+}
+
+// BorrowRate returns the yearly borrow rate at utilization, both WAD.
+func (m RateModel) BorrowRate(utilization *big.Int) *big.Int {
+	rate := m.BaseRate.Units()
+	kink := m.Kink.Units()
+	if kink.Sign() <= 0 || kink.Cmp(wad) >= 0 {
+		// Without a usable kink the curve is a single slope.
+		return rate.Add(rate, mulDiv(m.Slope1.Units(), utilization, wad, false))
+	}
+	if utilization.Cmp(kink) <= 0 {
+		return rate.Add(rate, mulDiv(m.Slope1.Units(), utilization, kink, false))
+	}
+	rate.Add(rate, m.Slope1.Units())
+	excess := new(big.Int).Sub(utilization, kink)
+	return rate.Add(rate, mulDiv(m.Slope2.Units(), excess, new(big.Int).Sub(wad, kink), false))
+}
+
+// inSeconds reports whether times under m are Unix seconds rather than
+// block numbers.
+func (m RateModel) inSeconds() bool {
+	return m.PeriodsPerYear == 0 || m.PeriodsPerYear == SecondsPerYear
+}
+
+func (m RateModel) unit() string {
+	if m.inSeconds() {
+		return "seconds"
+	}
+	return "blocks"
+}
+
+func (m RateModel) periods() *big.Int {
+	if m.PeriodsPerYear > 0 {
+		return big.NewInt(m.PeriodsPerYear)
+	}
+	return big.NewInt(SecondsPerYear)
+}
+
+// accrue returns the indices and rates of r after growing them with simple
+// interest from r.LastAccrual to at. Successive accruals compound.
+func (r *Reserve) accrue(at int64) (borrowIndex, supplyIndex, borrowRate, supplyRate *big.Int) {
+	utilization := new(big.Int)
+	supplied := mulDiv(r.ScaledSupply, r.SupplyIndex, wad, false)
+	if supplied.Sign() > 0 {
+		utilization = mulDiv(mulDiv(r.ScaledDebt, r.BorrowIndex, wad, true), wad, supplied, false)
+		if utilization.Cmp(wad) > 0 {
+			utilization.Set(wad)
+		}
+	}
+	borrowRate = r.Rates.BorrowRate(utilization)
+	// Suppliers earn the borrowers' interest less the reserve factor.
+	supplyRate = mulDiv(mulDiv(borrowRate, utilization, wad, false), new(big.Int).Sub(wad, r.ReserveFactor), wad, false)
+
+	borrowIndex, supplyIndex = r.BorrowIndex, r.SupplyIndex
+	if elapsed := at - r.LastAccrual; elapsed > 0 {
+		dt := big.NewInt(elapsed)
+		periods := r.Rates.periods()
+		growth := func(index, rate *big.Int) *big.Int {
+			factor := new(big.Int).Add(wad, mulDiv(rate, dt, periods, false))
+			return mulDiv(index, factor, wad, false)
+		}
+		borrowIndex, supplyIndex = growth(borrowIndex, borrowRate), growth(supplyIndex, supplyRate)
+	}
+	return borrowIndex, supplyIndex, borrowRate, supplyRate
+}
+
+// mulDiv returns a*b/c, rounded up if up is set and down otherwise.
+func mulDiv(a, b, c *big.Int, up bool) *big.Int {
+	n := new(big.Int).Mul(a, b)
+	q, rem := n.QuoRem(n, c, new(big.Int))
+	if up && rem.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return q
+}
+
+func pow10(n uint8) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
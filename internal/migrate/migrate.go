@@ -0,0 +1,302 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	historyTable = "schema_migrations"
+	lockName     = "defi_schema_migrations"
+	// lockKey is the Postgres advisory lock key; any constant unique to this
+	// application works.
+	lockKey     = 727274101
+	lockTimeout = 60 * time.Second
+)
+
+// multiStatement lists the MySQL migrations that shipped with several
+// statements. They cannot be edited, so when one is retried after failing
+// part way, statements whose columns or indexes already exist are skipped.
+var multiStatement = map[int]bool{2: true, 6: true}
+
+//go:embed mysql/*.sql postgres/*.sql
+var migrationsFS embed.FS
+
+type Migration struct {
+	Version  int
+	Name     string
+	SQL      string
+	Checksum string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt int64
+}
+
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// New returns a Migrator for the embedded migrations of driver, which is the
+// database/sql driver name ("mysql" or "postgres").
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS, driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+func loadMigrations(fsys fs.FS, driver string) ([]Migration, error) {
+	if driver != "mysql" && driver != "postgres" {
+		return nil, fmt.Errorf("unsupported migration driver: %s", driver)
+	}
+	files, err := fs.Glob(fsys, driver+"/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		prefix, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s must be named <version>_<name>.sql", file)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s has an invalid version", file)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, file, version)
+		}
+		seen[version] = file
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+		sum := sha256.Sum256(content)
+		migrations = append(migrations, Migration{
+			Version:  version,
+			Name:     name,
+			SQL:      string(content),
+			Checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration and returns the ones it applied. A
+// database-level lock serialises concurrent callers, so replicas starting at
+// the same time apply each migration exactly once.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		history, err := m.history(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if st, ok := history[mig.Version]; ok {
+				if st.Checksum != mig.Checksum {
+					return fmt.Errorf("migration %d_%s was modified after being applied", mig.Version, mig.Name)
+				}
+				continue
+			}
+			log.Printf("Applying migration %d_%s", mig.Version, mig.Name)
+			if err := m.apply(ctx, conn, mig); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Status reports every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := m.ensureHistory(ctx, conn); err != nil {
+		return nil, err
+	}
+	history, err := m.history(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		st := Status{Migration: mig}
+		if h, ok := history[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = h.AppliedAt
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	switch m.driver {
+	case "mysql":
+		var got sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(lockTimeout.Seconds())).Scan(&got); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		if got.Int64 != 1 {
+			return fmt.Errorf("timed out waiting for migration lock")
+		}
+		defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+	case "postgres":
+		lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+	}
+
+	if err := m.ensureHistory(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) ensureHistory(ctx context.Context, conn *sql.Conn) error {
+	query := `CREATE TABLE IF NOT EXISTS ` + historyTable + `
+(
+    version    BIGINT PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    checksum   CHAR(64)     NOT NULL,
+    applied_at BIGINT       NOT NULL
+)`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create %s: %w", historyTable, err)
+	}
+	return nil
+}
+
+type historyEntry struct {
+	Checksum  string
+	AppliedAt int64
+}
+
+func (m *Migrator) history(ctx context.Context, conn *sql.Conn) (map[int]historyEntry, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum, applied_at FROM `+historyTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", historyTable, err)
+	}
+	defer rows.Close()
+
+	history := make(map[int]historyEntry)
+	for rows.Next() {
+		var version int
+		var entry historyEntry
+		if err := rows.Scan(&version, &entry.Checksum, &entry.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", historyTable, err)
+		}
+		history[version] = entry
+	}
+	return history, rows.Err()
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	record := `INSERT INTO ` + historyTable + ` (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`
+	if m.driver == "postgres" {
+		record = `INSERT INTO ` + historyTable + ` (version, name, checksum, applied_at) VALUES ($1, $2, $3, $4)`
+	}
+	now := time.Now().UnixMilli()
+
+	// MySQL commits DDL implicitly, so a transaction only buys atomicity on
+	// Postgres. MySQL migrations hold a single statement instead, so one that
+	// fails leaves nothing behind to trip up the next Up; see multiStatement
+	// for the exceptions. Either way the history row is written last.
+	if m.driver == "postgres" {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for _, stmt := range splitStatements(mig.SQL) {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, record, mig.Version, mig.Name, mig.Checksum, now); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	for _, stmt := range splitStatements(mig.SQL) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			if multiStatement[mig.Version] && alreadyApplied(err) {
+				log.Printf("Skipping statement of migration %04d_%s that already took effect: %v", mig.Version, mig.Name, err)
+				continue
+			}
+			return err
+		}
+	}
+	_, err := conn.ExecContext(ctx, record, mig.Version, mig.Name, mig.Checksum, now)
+	return err
+}
+
+// alreadyApplied reports whether err is MySQL refusing to add a column or
+// index that exists.
+func alreadyApplied(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && (mysqlErr.Number == 1060 || mysqlErr.Number == 1061)
+}
+
+// splitStatements splits a migration into statements terminated by a
+// semicolon at the end of a line.
+func splitStatements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			if stmt := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(current.String()), ";")); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			current.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrationsAreAligned(t *testing.T) {
+	mysql, err := loadMigrations(migrationsFS, "mysql")
+	if err != nil {
+		t.Fatalf("Failed to load mysql migrations: %v", err)
+	}
+	postgres, err := loadMigrations(migrationsFS, "postgres")
+	if err != nil {
+		t.Fatalf("Failed to load postgres migrations: %v", err)
+	}
+	if len(mysql) != len(postgres) {
+		t.Fatalf("Expected the same number of migrations, got %d mysql and %d postgres", len(mysql), len(postgres))
+	}
+	for i := range mysql {
+		if mysql[i].Version != postgres[i].Version || mysql[i].Name != postgres[i].Name {
+			t.Fatalf("Migration %d differs: %d_%s vs %d_%s", i, mysql[i].Version, mysql[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+		// MySQL cannot roll back DDL, so each new migration must be one
+		// statement.
+		if n := len(splitStatements(mysql[i].SQL)); n != 1 && !multiStatement[mysql[i].Version] {
+			t.Fatalf("MySQL migration %d_%s has %d statements, want 1", mysql[i].Version, mysql[i].Name, n)
+		}
+	}
+}
+
+func TestLoadMigrationsRejectsDuplicateVersions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"mysql/0001_a.sql": {Data: []byte("SELECT 1;")},
+		"mysql/1_b.sql":    {Data: []byte("SELECT 2;")},
+	}
+	if _, err := loadMigrations(fsys, "mysql"); err == nil {
+		t.Fatal("Expected duplicate version error")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements("CREATE TABLE a\n(\n    id INT\n);\n\nCREATE INDEX i ON a (id);\n")
+	if len(stmts) != 2 {
+		t.Fatalf("Expected 2 statements, got %d: %q", len(stmts), stmts)
+	}
+	if stmts[1] != "CREATE INDEX i ON a (id)" {
+		t.Fatalf("Unexpected statement: %q", stmts[1])
+	}
+}
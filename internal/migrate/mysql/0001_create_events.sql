@@ -1,8 +1,8 @@
-CREATE TABLE events
+CREATE TABLE IF NOT EXISTS events
 (
     id           VARCHAR(255) PRIMARY KEY,
     aggregate_id VARCHAR(255),
     type         VARCHAR(255),
     data         TEXT,
     timestamp    BIGINT
-);
\ No newline at end of file
+);
@@ -0,0 +1,9 @@
+ALTER TABLE events
+    ADD COLUMN position BIGINT NOT NULL AUTO_INCREMENT UNIQUE FIRST,
+    ADD COLUMN aggregate_type VARCHAR(255) AFTER aggregate_id,
+    ADD COLUMN metadata JSON AFTER data;
+
+CREATE INDEX idx_events_aggregate_position ON events (aggregate_id, position);
+CREATE INDEX idx_events_aggregate_type_position ON events (aggregate_type, position);
+CREATE INDEX idx_events_type_position ON events (type, position);
+CREATE INDEX idx_events_timestamp ON events (timestamp);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS snapshots
+(
+    aggregate_id   VARCHAR(255) NOT NULL,
+    aggregate_type VARCHAR(255),
+    version        BIGINT       NOT NULL,
+    state          LONGTEXT     NOT NULL,
+    created_at     BIGINT       NOT NULL,
+    PRIMARY KEY (aggregate_id, version)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS checkpoints
+(
+    name       VARCHAR(255) PRIMARY KEY,
+    position   BIGINT NOT NULL,
+    updated_at BIGINT NOT NULL
+);
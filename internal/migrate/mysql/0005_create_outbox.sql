@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id           BIGINT AUTO_INCREMENT PRIMARY KEY,
+    event_id     VARCHAR(255) NOT NULL,
+    topic        VARCHAR(255) NOT NULL,
+    payload      LONGTEXT     NOT NULL,
+    created_at   BIGINT       NOT NULL,
+    published_at BIGINT,
+    INDEX idx_outbox_pending (published_at, id)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE events
+    ADD COLUMN version BIGINT AFTER aggregate_type;
+
+CREATE UNIQUE INDEX idx_events_aggregate_version ON events (aggregate_id, version);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    id          VARCHAR(64) PRIMARY KEY,
+    fingerprint VARCHAR(64) NOT NULL,
+    status      INT         NOT NULL,
+    header      TEXT,
+    body        LONGBLOB,
+    expires_at  BIGINT      NOT NULL,
+    created_at  BIGINT      NOT NULL,
+    INDEX idx_idempotency_keys_expires (expires_at)
+);
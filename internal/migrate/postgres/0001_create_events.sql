@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS events
+(
+    id           VARCHAR(255) PRIMARY KEY,
+    aggregate_id VARCHAR(255),
+    type         VARCHAR(255),
+    data         TEXT,
+    timestamp    BIGINT
+);
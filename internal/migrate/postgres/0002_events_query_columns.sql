@@ -0,0 +1,10 @@
+ALTER TABLE events
+    ADD COLUMN IF NOT EXISTS position BIGSERIAL UNIQUE,
+    ADD COLUMN IF NOT EXISTS aggregate_type VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_events_aggregate_position ON events (aggregate_id, position);
+CREATE INDEX IF NOT EXISTS idx_events_aggregate_type_position ON events (aggregate_type, position);
+CREATE INDEX IF NOT EXISTS idx_events_type_position ON events (type, position);
+CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events (timestamp);
+CREATE INDEX IF NOT EXISTS idx_events_metadata ON events USING GIN (metadata);
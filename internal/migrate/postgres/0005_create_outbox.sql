@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id           BIGSERIAL PRIMARY KEY,
+    event_id     VARCHAR(255) NOT NULL,
+    topic        VARCHAR(255) NOT NULL,
+    payload      TEXT         NOT NULL,
+    created_at   BIGINT       NOT NULL,
+    published_at BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (published_at, id);
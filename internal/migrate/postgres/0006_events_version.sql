@@ -0,0 +1,4 @@
+ALTER TABLE events
+    ADD COLUMN IF NOT EXISTS version BIGINT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_aggregate_version ON events (aggregate_id, version);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS scheduled_jobs
+(
+    id          VARCHAR(255) PRIMARY KEY,
+    kind        VARCHAR(16)  NOT NULL,
+    name        VARCHAR(255) NOT NULL,
+    payload     TEXT         NOT NULL,
+    due_at      BIGINT       NOT NULL,
+    interval_ms BIGINT       NOT NULL DEFAULT 0,
+    status      VARCHAR(16)  NOT NULL,
+    occurrence  BIGINT       NOT NULL DEFAULT 0,
+    attempts    INT          NOT NULL DEFAULT 0,
+    last_error  TEXT,
+    lease_owner VARCHAR(255),
+    lease_until BIGINT,
+    created_at  BIGINT       NOT NULL,
+    updated_at  BIGINT       NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_jobs_due ON scheduled_jobs (status, due_at);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    id          VARCHAR(64) PRIMARY KEY,
+    fingerprint VARCHAR(64) NOT NULL,
+    status      INT         NOT NULL,
+    header      TEXT,
+    body        BYTEA,
+    expires_at  BIGINT      NOT NULL,
+    created_at  BIGINT      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys (expires_at);
@@ -0,0 +1,2 @@
+ALTER TABLE idempotency_keys
+    ADD COLUMN IF NOT EXISTS token VARCHAR(36);
@@ -0,0 +1,325 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	// ErrOverflow is returned when a result does not fit in 256 bits, the
+	// width of token balances on chain.
+	ErrOverflow = errors.New("amount overflows 256 bits")
+	// ErrInexact is returned with RoundExact when rounding would lose units.
+	ErrInexact = errors.New("amount cannot be represented exactly")
+	// ErrDecimalsMismatch is returned when combining amounts of different
+	// precision, which almost always means mixing assets.
+	ErrDecimalsMismatch = errors.New("amounts have different decimals")
+	ErrInvalidAmount    = errors.New("invalid amount")
+)
+
+// RoundingMode decides what happens to units below an amount's precision.
+type RoundingMode int
+
+const (
+	// RoundExact fails with ErrInexact instead of rounding.
+	RoundExact RoundingMode = iota
+	// RoundDown truncates toward zero.
+	RoundDown
+	// RoundUp rounds away from zero.
+	RoundUp
+	// RoundHalfUp rounds to nearest, ties away from zero.
+	RoundHalfUp
+	// RoundHalfEven rounds to nearest, ties to even (banker's rounding).
+	RoundHalfEven
+)
+
+var maxUnits = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// Amount is a token quantity held as an integer number of base units (e.g.
+// wei) together with the asset's decimals, so 1.5 ETH is 1500000000000000000
+// units with 18 decimals. Amounts are immutable values; the zero value is
+// zero with no decimals. The magnitude is limited to 256 bits.
+//
+// Amounts encode to JSON and SQL as a decimal string with exactly Decimals
+// fractional digits, e.g. "1.500000000000000000", which round-trips without
+// loss.
+type Amount struct {
+	units    *big.Int
+	decimals uint8
+}
+
+// NewAmount returns units base units of an asset with decimals places.
+func NewAmount(units *big.Int, decimals uint8) (Amount, error) {
+	if units == nil {
+		return Amount{decimals: decimals}, nil
+	}
+	return checked(new(big.Int).Set(units), decimals)
+}
+
+// Units returns an amount of n base units.
+func Units(n int64, decimals uint8) Amount {
+	return Amount{units: big.NewInt(n), decimals: decimals}
+}
+
+// ParseAmount parses a decimal string such as "1.5" or "-0.25" into an
+// amount with decimals places, rounding extra fractional digits with mode.
+func ParseAmount(s string, decimals uint8, mode RoundingMode) (Amount, error) {
+	text := s
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "-"), "+")
+	whole, frac, _ := strings.Cut(text, ".")
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	units, _ := new(big.Int).SetString(whole+frac, 10)
+	if units == nil {
+		units = new(big.Int)
+	}
+	if negative {
+		units.Neg(units)
+	}
+	shift := int(decimals) - len(frac)
+	if shift >= 0 {
+		units.Mul(units, pow10(shift))
+	} else {
+		var err error
+		if units, err = divRound(units, pow10(-shift), mode); err != nil {
+			return Amount{}, fmt.Errorf("%q with %d decimals: %w", s, decimals, err)
+		}
+	}
+	return checked(units, decimals)
+}
+
+// MustParseAmount is ParseAmount with RoundExact that panics on error, for
+// constants and tests.
+func MustParseAmount(s string, decimals uint8) Amount {
+	a, err := ParseAmount(s, decimals, RoundExact)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func checked(units *big.Int, decimals uint8) (Amount, error) {
+	if units.CmpAbs(maxUnits) > 0 {
+		return Amount{}, ErrOverflow
+	}
+	return Amount{units: units, decimals: decimals}, nil
+}
+
+// divRound returns n/d rounded with mode.
+func divRound(n, d *big.Int, mode RoundingMode) (*big.Int, error) {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if r.Sign() == 0 {
+		return q, nil
+	}
+	away := false
+	switch mode {
+	case RoundExact:
+		return nil, ErrInexact
+	case RoundDown:
+	case RoundUp:
+		away = true
+	case RoundHalfUp, RoundHalfEven:
+		half := new(big.Int).Abs(r)
+		half.Lsh(half, 1)
+		switch c := half.CmpAbs(d); {
+		case c > 0:
+			away = true
+		case c == 0:
+			away = mode == RoundHalfUp || q.Bit(0) == 1
+		}
+	default:
+		return nil, fmt.Errorf("unknown rounding mode %d", mode)
+	}
+	if away {
+		if n.Sign()*d.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q, nil
+}
+
+func (a Amount) int() *big.Int {
+	if a.units == nil {
+		return new(big.Int)
+	}
+	return a.units
+}
+
+// Units returns a copy of the amount in base units.
+func (a Amount) Units() *big.Int {
+	return new(big.Int).Set(a.int())
+}
+
+func (a Amount) Decimals() uint8 { return a.decimals }
+
+func (a Amount) Sign() int { return a.int().Sign() }
+
+func (a Amount) IsZero() bool { return a.Sign() == 0 }
+
+// Cmp compares a and b by value, so 1.5 with 2 decimals equals 1.50 with 6.
+func (a Amount) Cmp(b Amount) int {
+	x, y := a.int(), b.int()
+	switch {
+	case a.decimals < b.decimals:
+		x = new(big.Int).Mul(x, pow10(int(b.decimals-a.decimals)))
+	case a.decimals > b.decimals:
+		y = new(big.Int).Mul(y, pow10(int(a.decimals-b.decimals)))
+	}
+	return x.Cmp(y)
+}
+
+func (a Amount) Neg() Amount {
+	return Amount{units: new(big.Int).Neg(a.int()), decimals: a.decimals}
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	if err := a.sameDecimals(b); err != nil {
+		return Amount{}, err
+	}
+	return checked(new(big.Int).Add(a.int(), b.int()), a.decimals)
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if err := a.sameDecimals(b); err != nil {
+		return Amount{}, err
+	}
+	return checked(new(big.Int).Sub(a.int(), b.int()), a.decimals)
+}
+
+// MulDiv returns a*num/den rounded with mode, e.g. to apply a fee of 30
+// basis points with MulDiv(big.NewInt(30), big.NewInt(10000), RoundUp).
+func (a Amount) MulDiv(num, den *big.Int, mode RoundingMode) (Amount, error) {
+	if den.Sign() == 0 {
+		return Amount{}, errors.New("division by zero")
+	}
+	units, err := divRound(new(big.Int).Mul(a.int(), num), den, mode)
+	if err != nil {
+		return Amount{}, err
+	}
+	return checked(units, a.decimals)
+}
+
+// Rescale converts a to decimals places, rounding with mode when precision
+// is lost.
+func (a Amount) Rescale(decimals uint8, mode RoundingMode) (Amount, error) {
+	if decimals >= a.decimals {
+		return checked(new(big.Int).Mul(a.int(), pow10(int(decimals-a.decimals))), decimals)
+	}
+	units, err := divRound(a.int(), pow10(int(a.decimals-decimals)), mode)
+	if err != nil {
+		return Amount{}, err
+	}
+	return Amount{units: units, decimals: decimals}, nil
+}
+
+func (a Amount) sameDecimals(b Amount) error {
+	if a.decimals != b.decimals {
+		return fmt.Errorf("%w: %d and %d", ErrDecimalsMismatch, a.decimals, b.decimals)
+	}
+	return nil
+}
+
+// String formats a with exactly Decimals fractional digits.
+func (a Amount) String() string {
+	digits := new(big.Int).Abs(a.int()).String()
+	sign := ""
+	if a.Sign() < 0 {
+		sign = "-"
+	}
+	if a.decimals == 0 {
+		return sign + digits
+	}
+	if pad := int(a.decimals) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	cut := len(digits) - int(a.decimals)
+	return sign + digits[:cut] + "." + digits[cut:]
+}
+
+// parseExact parses a String-formatted amount, taking the decimals from the
+// number of fractional digits. Callers that know the asset normalize the
+// result with ForAsset.
+func parseExact(s string) (Amount, error) {
+	_, frac, _ := strings.Cut(s, ".")
+	if len(frac) > 255 {
+		return Amount{}, fmt.Errorf("%w: too many decimals", ErrInvalidAmount)
+	}
+	return ParseAmount(s, uint8(len(frac)), RoundExact)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a bare JSON number. Either way
+// the digits are parsed as text, never through float64.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+	parsed, err := parseExact(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Value stores the amount as its decimal string, suitable for DECIMAL and
+// text columns.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = Amount{}
+		return nil
+	case int64:
+		*a = Units(v, 0)
+		return nil
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	}
+	return fmt.Errorf("cannot scan %T into Amount", src)
+}
+
+func (a *Amount) scanString(s string) error {
+	parsed, err := parseExact(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
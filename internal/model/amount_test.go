@@ -0,0 +1,119 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParseAmountRounding(t *testing.T) {
+	cases := []struct {
+		in   string
+		mode RoundingMode
+		want string
+	}{
+		{"1.5", RoundExact, "1.50"},
+		{"0.125", RoundDown, "0.12"},
+		{"0.125", RoundUp, "0.13"},
+		{"0.125", RoundHalfUp, "0.13"},
+		{"0.125", RoundHalfEven, "0.12"},
+		{"0.135", RoundHalfEven, "0.14"},
+		{"-0.125", RoundHalfUp, "-0.13"},
+		{"-0.121", RoundDown, "-0.12"},
+		{"-0.121", RoundUp, "-0.13"},
+		{"7", RoundExact, "7.00"},
+	}
+	for _, c := range cases {
+		a, err := ParseAmount(c.in, 2, c.mode)
+		if err != nil {
+			t.Errorf("ParseAmount(%q): %v", c.in, err)
+			continue
+		}
+		if a.String() != c.want {
+			t.Errorf("ParseAmount(%q, mode %d) = %s, want %s", c.in, c.mode, a, c.want)
+		}
+	}
+
+	if _, err := ParseAmount("0.125", 2, RoundExact); !errors.Is(err, ErrInexact) {
+		t.Errorf("expected ErrInexact, got %v", err)
+	}
+	for _, bad := range []string{"", ".", "-", "1e5", "1.2.3", "0x10", " 1"} {
+		if _, err := ParseAmount(bad, 2, RoundDown); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("ParseAmount(%q): expected ErrInvalidAmount, got %v", bad, err)
+		}
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	a := MustParseAmount("1.5", 18)
+	b := MustParseAmount("0.000000000000000001", 18)
+	sum, err := a.Add(b)
+	if err != nil || sum.String() != "1.500000000000000001" {
+		t.Errorf("Add = %s, %v", sum, err)
+	}
+	if _, err := a.Add(Units(1, 6)); !errors.Is(err, ErrDecimalsMismatch) {
+		t.Errorf("expected ErrDecimalsMismatch, got %v", err)
+	}
+	if a.Cmp(MustParseAmount("1.5", 6)) != 0 {
+		t.Error("1.5 with 18 and 6 decimals should compare equal")
+	}
+
+	max, _ := NewAmount(maxUnits, 0)
+	if _, err := max.Add(Units(1, 0)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+
+	fee, err := MustParseAmount("1000", 6).MulDiv(big.NewInt(30), big.NewInt(10000), RoundUp)
+	if err != nil || fee.String() != "3.000000" {
+		t.Errorf("fee = %s, %v", fee, err)
+	}
+	usdc, err := a.Rescale(6, RoundDown)
+	if err != nil || usdc.String() != "1.500000" {
+		t.Errorf("Rescale = %s, %v", usdc, err)
+	}
+}
+
+func TestAmountEncoding(t *testing.T) {
+	a := MustParseAmount("-12345678901234567890.123456789012345678", 18)
+	raw, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Amount
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cmp(a) != 0 || decoded.Decimals() != 18 {
+		t.Errorf("JSON round trip: got %s (%d decimals) from %s", decoded, decoded.Decimals(), raw)
+	}
+
+	// Bare numbers are parsed as text, so no float64 rounding creeps in.
+	if err := json.Unmarshal([]byte("0.1000000000000000055"), &decoded); err != nil || decoded.String() != "0.1000000000000000055" {
+		t.Errorf("number: got %s, %v", decoded, err)
+	}
+
+	value, _ := a.Value()
+	var scanned Amount
+	if err := scanned.Scan([]byte(value.(string))); err != nil || scanned.Cmp(a) != 0 {
+		t.Errorf("SQL round trip: got %s, %v", scanned, err)
+	}
+}
+
+func TestAssetDecimals(t *testing.T) {
+	if err := RegisterAsset("TKN", 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterAsset("TKN", 8); err == nil {
+		t.Error("registered TKN again with other decimals")
+	}
+	if a, err := ParseAssetAmount("TKN", "1.5"); err != nil || a.String() != "1.500000" {
+		t.Errorf("ParseAssetAmount = %s, %v", a, err)
+	}
+	if a, err := MustParseAmount("2.50", 2).ForAsset("TKN"); err != nil || a.String() != "2.500000" {
+		t.Errorf("ForAsset = %s, %v", a, err)
+	}
+	if _, err := MustParseAmount("1", 0).ForAsset("NONE"); !errors.Is(err, ErrUnknownAsset) {
+		t.Errorf("ForAsset of an unknown asset: got %v", err)
+	}
+}
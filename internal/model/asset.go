@@ -0,0 +1,74 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnknownAsset is returned for assets without registered decimals.
+var ErrUnknownAsset = errors.New("unknown asset")
+
+var (
+	assetsMu sync.RWMutex
+	assets   = make(map[string]uint8)
+)
+
+// RegisterAsset declares that amounts of asset have decimals places. An
+// asset's decimals cannot change once registered, since stored amounts
+// depend on them.
+func RegisterAsset(asset string, decimals uint8) error {
+	if asset == "" {
+		return errors.New("asset is required")
+	}
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+	if registered, ok := assets[asset]; ok && registered != decimals {
+		return fmt.Errorf("%s is registered with %d decimals, not %d", asset, registered, decimals)
+	}
+	assets[asset] = decimals
+	return nil
+}
+
+// AssetDecimals returns the registered decimals of asset.
+func AssetDecimals(asset string) (uint8, bool) {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	decimals, ok := assets[asset]
+	return decimals, ok
+}
+
+// CheckAssetDecimals fails if asset is registered with other decimals, e.g.
+// when a pool or book declares the decimals of the assets it trades.
+func CheckAssetDecimals(asset string, decimals uint8) error {
+	if registered, ok := AssetDecimals(asset); ok && registered != decimals {
+		return fmt.Errorf("%s has %d decimals, not %d", asset, registered, decimals)
+	}
+	return nil
+}
+
+// ParseAssetAmount parses s as an amount of asset, with the asset's
+// registered decimals. Nonzero digits beyond them are rejected, not rounded.
+func ParseAssetAmount(asset, s string) (Amount, error) {
+	decimals, ok := AssetDecimals(asset)
+	if !ok {
+		return Amount{}, fmt.Errorf("%w: %s", ErrUnknownAsset, asset)
+	}
+	return ParseAmount(s, decimals, RoundExact)
+}
+
+// ForAsset returns a with the registered decimals of asset. Amounts decoded
+// from JSON or SQL take their decimals from the text, so code that knows the
+// asset should pass them through ForAsset. It fails if a has nonzero digits
+// beyond the asset's decimals.
+func (a Amount) ForAsset(asset string) (Amount, error) {
+	decimals, ok := AssetDecimals(asset)
+	if !ok {
+		return Amount{}, fmt.Errorf("%w: %s", ErrUnknownAsset, asset)
+	}
+	converted, err := a.Rescale(decimals, RoundExact)
+	if err != nil {
+		return Amount{}, fmt.Errorf("%s of %s with %d decimals: %w", a, asset, decimals, err)
+	}
+	return converted, nil
+}
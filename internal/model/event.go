@@ -1,5 +1,10 @@
 package model
 
+import (
+	"crypto/rand"
+	"fmt"
+)
+
 type State string
 
 const (
@@ -9,9 +14,27 @@ const (
 )
 
 type Event struct {
-	ID          string
-	AggregateID string
-	Type        string
-	Data        string
-	Timestamp   int64
+	ID            string
+	AggregateID   string
+	AggregateType string
+	// Version is the event's 1-based sequence number within its aggregate.
+	// Events written without an aggregate version have zero.
+	Version   int64
+	Type      string
+	Data      string
+	Metadata  map[string]string
+	Timestamp int64
+	// Position is the store-assigned global sequence number of the event.
+	Position int64
+}
+
+// NewID returns a random UUID (version 4) for events and other records.
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("model: failed to read random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 }
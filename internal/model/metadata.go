@@ -0,0 +1,27 @@
+package model
+
+// Metadata keys the service sets on events itself. Sagas and the scheduler
+// trust them to route and deduplicate events, so clients may not set them.
+const (
+	// MetadataPrincipal and MetadataAuthMethod record who wrote an event.
+	MetadataPrincipal  = "principal"
+	MetadataAuthMethod = "auth_method"
+	// MetadataSagaID and MetadataSagaStep mark the events of commands
+	// dispatched by a saga step.
+	MetadataSagaID   = "saga_id"
+	MetadataSagaStep = "saga_step"
+	// MetadataScheduleID and MetadataScheduleOccurrence mark the events
+	// caused by a scheduled job.
+	MetadataScheduleID         = "schedule_id"
+	MetadataScheduleOccurrence = "schedule_occurrence"
+)
+
+// IsServerMetadata reports whether key is one of the metadata keys only the
+// service may set.
+func IsServerMetadata(key string) bool {
+	switch key {
+	case MetadataPrincipal, MetadataAuthMethod, MetadataSagaID, MetadataSagaStep, MetadataScheduleID, MetadataScheduleOccurrence:
+		return true
+	}
+	return false
+}
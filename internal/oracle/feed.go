@@ -0,0 +1,113 @@
+package oracle
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/model"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Aggregate and event types of price feeds. Each asset has one feed.
+const (
+	FeedType          = "price_feed"
+	EventPriceUpdated = "PriceUpdated"
+)
+
+// maxHistory bounds the prices a feed keeps for TWAPs.
+const maxHistory = 24 * time.Hour
+
+// PriceUpdated records an accepted price together with the source quotes it
+// was aggregated from, so any valuation using it can be audited.
+type PriceUpdated struct {
+	Asset string       `json:"asset"`
+	Price model.Amount `json:"price"`
+	// At is the observation time in Unix milliseconds.
+	At      int64        `json:"at"`
+	TWAP    model.Amount `json:"twap"`
+	Sources []Quote      `json:"sources"`
+}
+
+// Point is one accepted price.
+type Point struct {
+	Price model.Amount
+	At    int64
+}
+
+// Feed is the state of a price feed aggregate.
+type Feed struct {
+	Status  model.State
+	Asset   string
+	Price   model.Amount
+	At      int64
+	TWAP    model.Amount
+	EventID string
+	History []Point
+}
+
+var feedLifecycle = aggregate.NewMachine[*Feed](model.StateInitial).
+	Allow(model.StateInitial, EventPriceUpdated, model.StateActive).
+	Allow(model.StateActive, EventPriceUpdated, model.StateActive, func(f *Feed, event model.Event) error {
+		var e PriceUpdated
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return err
+		}
+		if e.At <= f.At {
+			return fmt.Errorf("price at %d is not newer than %d", e.At, f.At)
+		}
+		return nil
+	})
+
+func NewFeed() *Feed {
+	return &Feed{}
+}
+
+func (f *Feed) Apply(event model.Event) error {
+	next, err := feedLifecycle.Next(f, f.Status, event)
+	if err != nil {
+		return err
+	}
+	var e PriceUpdated
+	if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+		return fmt.Errorf("invalid %s: %w", event.Type, err)
+	}
+	f.Asset, f.Price, f.At, f.TWAP, f.EventID = e.Asset, e.Price, e.At, e.TWAP, event.ID
+	f.History = append(f.History, Point{Price: e.Price, At: e.At})
+	cutoff := e.At - maxHistory.Milliseconds()
+	for len(f.History) > 1 && f.History[1].At <= cutoff {
+		f.History = f.History[1:]
+	}
+	f.Status = next
+	return nil
+}
+
+// twap returns the time-weighted average of history over the window ending
+// at now, each price holding until the next one. Before the first point the
+// first price is assumed. Prices must share decimals.
+func twap(history []Point, now int64, window time.Duration) (model.Amount, error) {
+	if len(history) == 0 {
+		return model.Amount{}, fmt.Errorf("no prices")
+	}
+	start := now - window.Milliseconds()
+	if window <= 0 || now <= start {
+		return history[len(history)-1].Price, nil
+	}
+	sum := new(big.Int)
+	for i, p := range history {
+		from := p.At
+		if i == 0 || from < start {
+			from = start
+		}
+		until := now
+		if i+1 < len(history) {
+			until = history[i+1].At
+		}
+		if until <= from {
+			continue
+		}
+		sum.Add(sum, new(big.Int).Mul(p.Price.Units(), big.NewInt(until-from)))
+	}
+	avg, _ := new(big.Int).QuoRem(sum, big.NewInt(now-start), new(big.Int))
+	return model.NewAmount(avg, history[len(history)-1].Price.Decimals())
+}
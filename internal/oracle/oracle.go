@@ -0,0 +1,290 @@
+package oracle
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults for zero Options fields.
+const (
+	DefaultMaxAge          = time.Minute
+	DefaultMaxDeviationBps = 1000
+	DefaultTWAPWindow      = 30 * time.Minute
+	DefaultDecimals        = 8
+)
+
+var (
+	// ErrNoQuorum is returned when too few sources have fresh quotes.
+	ErrNoQuorum = errors.New("not enough fresh quotes")
+	// ErrDeviation is returned when a price jumps further than allowed from
+	// the last accepted one.
+	ErrDeviation = errors.New("price deviates too far from the last price")
+)
+
+type Options struct {
+	// MaxAge is how old a quote may be and still count.
+	MaxAge time.Duration
+	// MinSources is how many sources must have a fresh quote; zero means 1.
+	MinSources int
+	// MaxDeviationBps bounds the change from the last accepted price while
+	// that price is no older than MaxAge. A negative value disables the
+	// check.
+	MaxDeviationBps int64
+	// TWAPWindow is the window of the TWAP recorded with each price.
+	TWAPWindow time.Duration
+	// Decimals is the precision prices are stored with.
+	Decimals uint8
+	// Clock returns the current time; replays set it to simulated time.
+	Clock func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxAge <= 0 {
+		o.MaxAge = DefaultMaxAge
+	}
+	if o.MinSources <= 0 {
+		o.MinSources = 1
+	}
+	if o.MaxDeviationBps == 0 {
+		o.MaxDeviationBps = DefaultMaxDeviationBps
+	}
+	if o.TWAPWindow <= 0 {
+		o.TWAPWindow = DefaultTWAPWindow
+	}
+	if o.Decimals == 0 {
+		o.Decimals = DefaultDecimals
+	}
+	if o.Clock == nil {
+		o.Clock = time.Now
+	}
+	return o
+}
+
+// Observation is the latest accepted price of an asset and the PriceUpdated
+// event that recorded it.
+type Observation struct {
+	Asset   string
+	Price   model.Amount
+	TWAP    model.Amount
+	At      int64
+	EventID string
+}
+
+// Oracle polls its sources, takes the median of fresh quotes per asset and
+// records accepted prices as PriceUpdated events on the asset's feed.
+type Oracle struct {
+	opts    Options
+	feeds   *aggregate.Repository[*Feed]
+	sources []Source
+	mu      sync.RWMutex
+	latest  map[string]Observation
+	// quotes holds the last quote of each source per asset, so a source that
+	// fails one poll still counts while its quote is fresh.
+	quotes map[string]map[string]Quote
+}
+
+func New(store aggregate.Store, opts Options, sources ...Source) *Oracle {
+	return &Oracle{
+		opts:    opts.withDefaults(),
+		feeds:   aggregate.NewRepository(store, FeedType, NewFeed),
+		sources: sources,
+		latest:  make(map[string]Observation),
+		quotes:  make(map[string]map[string]Quote),
+	}
+}
+
+// Run polls every interval until ctx is cancelled.
+func (o *Oracle) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := o.Poll(ctx); err != nil {
+			log.Printf("Oracle poll failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fetches every source once and records a new price for each asset
+// that passes the guards. It returns the errors of sources and assets that
+// failed; the other assets are still updated.
+func (o *Oracle) Poll(ctx context.Context) error {
+	var failed []error
+	for _, s := range o.sources {
+		quotes, err := s.Fetch(ctx)
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", s.Name(), err))
+			continue
+		}
+		o.mu.Lock()
+		for _, q := range quotes {
+			if o.quotes[q.Asset] == nil {
+				o.quotes[q.Asset] = make(map[string]Quote)
+			}
+			if prev, ok := o.quotes[q.Asset][q.Source]; !ok || q.At >= prev.At {
+				o.quotes[q.Asset][q.Source] = q
+			}
+		}
+		o.mu.Unlock()
+	}
+
+	o.mu.RLock()
+	assets := make([]string, 0, len(o.quotes))
+	for asset := range o.quotes {
+		assets = append(assets, asset)
+	}
+	o.mu.RUnlock()
+	sort.Strings(assets)
+	for _, asset := range assets {
+		if err := o.update(ctx, asset); err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", asset, err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+func (o *Oracle) update(ctx context.Context, asset string) error {
+	now := o.opts.Clock().UnixMilli()
+	fresh, err := o.freshQuotes(asset, now)
+	if err != nil {
+		return err
+	}
+	price, err := median(fresh, o.opts.Decimals)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		root, err := o.feeds.Load(ctx, asset)
+		if err != nil {
+			return err
+		}
+		feed := root.State
+		if feed.At >= now {
+			return nil
+		}
+		if err := o.checkDeviation(feed, price, now); err != nil {
+			return err
+		}
+		history := append(append([]Point(nil), feed.History...), Point{Price: price, At: now})
+		avg, err := twap(history, now, o.opts.TWAPWindow)
+		if err != nil {
+			return err
+		}
+		if err := root.Raise(EventPriceUpdated, PriceUpdated{Asset: asset, Price: price, At: now, TWAP: avg, Sources: fresh}); err != nil {
+			return err
+		}
+		err = o.feeds.Save(ctx, root)
+		if errors.Is(err, eventstore.ErrConcurrencyConflict) && attempt < 2 {
+			// Another replica recorded a price; reconsider against it.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		o.mu.Lock()
+		o.latest[asset] = observation(root.State)
+		o.mu.Unlock()
+		return nil
+	}
+}
+
+// freshQuotes returns the quotes of asset no older than MaxAge, by source.
+func (o *Oracle) freshQuotes(asset string, now int64) ([]Quote, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	var fresh []Quote
+	for _, q := range o.quotes[asset] {
+		if now-q.At <= o.opts.MaxAge.Milliseconds() && q.Price.Sign() > 0 {
+			fresh = append(fresh, q)
+		}
+	}
+	if len(fresh) < o.opts.MinSources {
+		return nil, fmt.Errorf("%w: %d of %d sources", ErrNoQuorum, len(fresh), o.opts.MinSources)
+	}
+	sort.Slice(fresh, func(i, j int) bool { return fresh[i].Source < fresh[j].Source })
+	return fresh, nil
+}
+
+// checkDeviation rejects price if it moves too far from the feed's last
+// price. A last price older than MaxAge says little about the market, e.g.
+// after an outage, so the new one then only needs the quorum of fresh
+// quotes.
+func (o *Oracle) checkDeviation(feed *Feed, price model.Amount, now int64) error {
+	if feed.Status != model.StateActive || o.opts.MaxDeviationBps < 0 {
+		return nil
+	}
+	if now-feed.At > o.opts.MaxAge.Milliseconds() {
+		return nil
+	}
+	diff, err := price.Sub(feed.Price)
+	if err != nil {
+		return err
+	}
+	// |diff| * 10000 > last * maxBps
+	lhs := new(big.Int).Abs(diff.Units())
+	lhs.Mul(lhs, big.NewInt(10000))
+	rhs := new(big.Int).Mul(feed.Price.Units(), big.NewInt(o.opts.MaxDeviationBps))
+	if lhs.Cmp(rhs) > 0 {
+		return fmt.Errorf("%w: %s after %s", ErrDeviation, price, feed.Price)
+	}
+	return nil
+}
+
+// median returns the median price of quotes at decimals places, averaging
+// the middle two of an even count.
+func median(quotes []Quote, decimals uint8) (model.Amount, error) {
+	prices := make([]model.Amount, len(quotes))
+	for i, q := range quotes {
+		p, err := q.Price.Rescale(decimals, model.RoundHalfEven)
+		if err != nil {
+			return model.Amount{}, err
+		}
+		prices[i] = p
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Cmp(prices[j]) < 0 })
+	mid := len(prices) / 2
+	if len(prices)%2 == 1 {
+		return prices[mid], nil
+	}
+	sum, err := prices[mid-1].Add(prices[mid])
+	if err != nil {
+		return model.Amount{}, err
+	}
+	return sum.MulDiv(big.NewInt(1), big.NewInt(2), model.RoundHalfEven)
+}
+
+func observation(f *Feed) Observation {
+	return Observation{Asset: f.Asset, Price: f.Price, TWAP: f.TWAP, At: f.At, EventID: f.EventID}
+}
+
+// Latest returns the last price this oracle recorded for asset.
+func (o *Oracle) Latest(asset string) (Observation, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	obs, ok := o.latest[asset]
+	return obs, ok
+}
+
+// TWAP returns the time-weighted average price of asset over window ending
+// now, from the feed's stored history.
+func (o *Oracle) TWAP(ctx context.Context, asset string, window time.Duration) (model.Amount, error) {
+	root, err := o.feeds.Load(ctx, asset)
+	if err != nil {
+		return model.Amount{}, err
+	}
+	return twap(root.State.History, o.opts.Clock().UnixMilli(), window)
+}
@@ -0,0 +1,101 @@
+package oracle
+
+import (
+	"context"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOracle(t *testing.T) {
+	ctx := context.Background()
+	now := time.UnixMilli(1700000000000)
+	usd := func(s string) model.Amount { return model.MustParseAmount(s, 8) }
+
+	stubA, stubB := NewStubHandler(), NewStubHandler()
+	serverA, serverB := httptest.NewServer(stubA), httptest.NewServer(stubB)
+	defer serverA.Close()
+	defer serverB.Close()
+
+	path := filepath.Join(t.TempDir(), "prices.jsonl")
+	ticks := `{"asset":"ETH","price":"2010.00","at":1700000000000}
+{"asset":"ETH","price":"2500.00","at":1700000060000}
+`
+	if err := os.WriteFile(path, []byte(ticks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewFileSource("file", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := eventstore.NewMemoryEventStore()
+	o := New(store, Options{MinSources: 2, Clock: func() time.Time { return now }},
+		NewHTTPSource("a", serverA.URL, time.Second), NewHTTPSource("b", serverB.URL, time.Second), file)
+
+	stubA.Set("ETH", usd("2000"), now)
+	stubB.Set("ETH", usd("1990"), now.Add(-2*time.Minute)) // stale
+	if err := o.Poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	obs, ok := o.Latest("ETH")
+	// Median of the two fresh quotes, 2000 and 2010.
+	if !ok || obs.Price.Cmp(usd("2005")) != 0 || obs.EventID == "" {
+		t.Fatalf("latest = %+v, %v", obs, ok)
+	}
+
+	// The file's next tick is a 25% jump: the median of 2000, 2001 and 2500
+	// stays put, so nothing trips the deviation guard.
+	now = now.Add(time.Minute)
+	stubA.Set("ETH", usd("2000"), now)
+	stubB.Set("ETH", usd("2001"), now)
+	if err := o.Poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if obs, _ = o.Latest("ETH"); obs.Price.Cmp(usd("2001")) != 0 {
+		t.Errorf("median = %s, want 2001", obs.Price)
+	}
+
+	now = now.Add(time.Minute)
+	stubA.Set("ETH", usd("3000"), now)
+	stubB.Set("ETH", usd("3000"), now)
+	if err := o.Poll(ctx); !errors.Is(err, ErrDeviation) {
+		t.Errorf("expected the deviation guard to trip, got %v", err)
+	}
+	// 2005 for a minute, then 2001 for a minute.
+	if avg, err := o.TWAP(ctx, "ETH", 2*time.Minute); err != nil || avg.Cmp(usd("2003")) != 0 {
+		t.Errorf("TWAP = %s, %v", avg, err)
+	}
+
+	prices := NewPrices()
+	if err := prices.CatchUp(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	stored, ok := prices.Latest("ETH")
+	if !ok || stored.EventID != obs.EventID || stored.Price.Cmp(obs.Price) != 0 {
+		t.Errorf("projected %+v, oracle %+v", stored, obs)
+	}
+	events, _ := store.LoadEvents(ctx, "ETH", 0)
+	var e PriceUpdated
+	if len(events) != 2 || json.Unmarshal([]byte(events[1].Data), &e) != nil || len(e.Sources) != 3 {
+		t.Errorf("expected two PriceUpdated events with their sources, got %+v", events)
+	}
+
+	// Once the last price is older than MaxAge, the jump is accepted.
+	now = now.Add(10 * time.Minute)
+	stubA.Set("ETH", usd("3000"), now)
+	stubB.Set("ETH", usd("3000"), now)
+	// The file source has run out of ticks by now.
+	if err := o.Poll(ctx); errors.Is(err, ErrDeviation) {
+		t.Fatalf("poll after a stale price: %v", err)
+	}
+	if obs, _ = o.Latest("ETH"); obs.Price.Cmp(usd("3000")) != 0 {
+		t.Errorf("price after a stale one = %s, want 3000", obs.Price)
+	}
+}
@@ -0,0 +1,43 @@
+package oracle
+
+import (
+	"context"
+	"defi/internal/eventstore"
+	"log"
+	"time"
+)
+
+// Prices is a read model of the latest price per asset, for processes that
+// consume PriceUpdated events instead of running an Oracle. Feed it with
+// Handle or CatchUp; events already seen are ignored.
+type Prices struct {
+	*eventstore.ReadModel[*Feed]
+}
+
+func NewPrices() *Prices {
+	return &Prices{eventstore.NewReadModel(FeedType, NewFeed, (*Feed).Apply)}
+}
+
+// Run catches up every interval until ctx is cancelled.
+func (p *Prices) Run(ctx context.Context, source eventstore.Finder, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := p.CatchUp(ctx, source); err != nil {
+			log.Printf("Price catch-up failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Prices) Latest(asset string) (Observation, bool) {
+	var latest Observation
+	ok := p.View(asset, func(feed *Feed, _ int64) {
+		latest = observation(feed)
+	})
+	return latest, ok
+}
@@ -0,0 +1,160 @@
+package oracle
+
+import (
+	"bufio"
+	"context"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrExhausted is returned by FileSource once every recorded tick was read.
+var ErrExhausted = errors.New("price source exhausted")
+
+// Quote is one source's price for an asset, in a common quote currency per
+// whole unit of the asset.
+type Quote struct {
+	Source string       `json:"source,omitempty"`
+	Asset  string       `json:"asset"`
+	Price  model.Amount `json:"price"`
+	// At is the observation time in Unix milliseconds.
+	At int64 `json:"at"`
+}
+
+// Source supplies quotes. Fetch returns the latest quote per asset it knows.
+type Source interface {
+	Name() string
+	Fetch(ctx context.Context) ([]Quote, error)
+}
+
+// FileSource replays recorded quotes from a file with one JSON Quote per
+// line, in time order. Each Fetch returns the next tick: the following run
+// of lines with the same At.
+type FileSource struct {
+	name   string
+	mu     sync.Mutex
+	quotes []Quote
+}
+
+func NewFileSource(name, path string) (*FileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open price file: %w", err)
+	}
+	defer f.Close()
+
+	s := &FileSource{name: name}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var q Quote
+		if err := json.Unmarshal(scanner.Bytes(), &q); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		s.quotes = append(s.quotes, q)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read price file: %w", err)
+	}
+	return s, nil
+}
+
+func (s *FileSource) Name() string { return s.name }
+
+func (s *FileSource) Fetch(ctx context.Context) ([]Quote, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.quotes) == 0 {
+		return nil, ErrExhausted
+	}
+	n := 1
+	for n < len(s.quotes) && s.quotes[n].At == s.quotes[0].At {
+		n++
+	}
+	tick := s.quotes[:n]
+	s.quotes = s.quotes[n:]
+	return stamp(tick, s.name), nil
+}
+
+// HTTPSource polls a JSON endpoint returning {"quotes": [Quote, ...]}, the
+// format StubHandler serves.
+type HTTPSource struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSource(name, url string, timeout time.Duration) *HTTPSource {
+	return &HTTPSource{name: name, url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPSource) Name() string { return s.name }
+
+func (s *HTTPSource) Fetch(ctx context.Context) ([]Quote, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch prices from %s: %w", s.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch prices from %s: %s", s.name, resp.Status)
+	}
+	var body struct {
+		Quotes []Quote `json:"quotes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid prices from %s: %w", s.name, err)
+	}
+	return stamp(body.Quotes, s.name), nil
+}
+
+func stamp(quotes []Quote, source string) []Quote {
+	stamped := make([]Quote, len(quotes))
+	for i, q := range quotes {
+		q.Source = source
+		stamped[i] = q
+	}
+	return stamped
+}
+
+// StubHandler serves settable prices in the HTTPSource format, for local
+// development and tests.
+type StubHandler struct {
+	mu     sync.Mutex
+	quotes map[string]Quote
+}
+
+func NewStubHandler() *StubHandler {
+	return &StubHandler{quotes: make(map[string]Quote)}
+}
+
+// Set publishes price for asset, observed at at.
+func (h *StubHandler) Set(asset string, price model.Amount, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.quotes[asset] = Quote{Asset: asset, Price: price, At: at.UnixMilli()}
+}
+
+func (h *StubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	body := struct {
+		Quotes []Quote `json:"quotes"`
+	}{Quotes: []Quote{}}
+	for _, q := range h.quotes {
+		body.Quotes = append(body.Quotes, q)
+	}
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
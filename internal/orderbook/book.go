@@ -0,0 +1,296 @@
+package orderbook
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/model"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Aggregate and event types of order books.
+const (
+	BookType = "order_book"
+
+	EventBookOpened     = "BookOpened"
+	EventOrderPlaced    = "OrderPlaced"
+	EventOrderAmended   = "OrderAmended"
+	EventOrderMatched   = "OrderMatched"
+	EventOrderCancelled = "OrderCancelled"
+)
+
+type Side string
+
+const (
+	Buy  Side = "buy"
+	Sell Side = "sell"
+)
+
+type OrderType string
+
+const (
+	Limit  OrderType = "limit"
+	Market OrderType = "market"
+)
+
+// SelfTrade decides what happens when an order would match another order of
+// the same owner.
+type SelfTrade string
+
+const (
+	// CancelResting cancels the resting order and keeps matching.
+	CancelResting SelfTrade = "cancel_resting"
+	// CancelIncoming cancels the rest of the incoming order.
+	CancelIncoming SelfTrade = "cancel_incoming"
+)
+
+// Reasons recorded in OrderCancelled.
+const (
+	ReasonRequested = "requested"
+	ReasonSelfTrade = "self_trade"
+	ReasonUnfilled  = "unfilled_market_order"
+)
+
+type BookOpened struct {
+	Base          string `json:"base"`
+	Quote         string `json:"quote"`
+	BaseDecimals  uint8  `json:"baseDecimals"`
+	QuoteDecimals uint8  `json:"quoteDecimals"`
+}
+
+// OrderPlaced records an accepted order. Limit orders rest in the book
+// until filled or cancelled; the fills it takes on arrival follow as
+// OrderMatched events.
+type OrderPlaced struct {
+	OrderID   string       `json:"orderId"`
+	Owner     string       `json:"owner"`
+	Side      Side         `json:"side"`
+	Type      OrderType    `json:"type"`
+	Price     model.Amount `json:"price"`
+	Quantity  model.Amount `json:"quantity"`
+	SelfTrade SelfTrade    `json:"selfTrade,omitempty"`
+}
+
+// OrderAmended changes a resting order. Requeued orders lose their time
+// priority, which happens when the price changes or the quantity grows.
+type OrderAmended struct {
+	OrderID  string       `json:"orderId"`
+	Price    model.Amount `json:"price"`
+	Quantity model.Amount `json:"quantity"`
+	Requeued bool         `json:"requeued"`
+}
+
+// OrderMatched is one fill at the resting (maker) order's price.
+type OrderMatched struct {
+	TakerOrderID string       `json:"takerOrderId"`
+	MakerOrderID string       `json:"makerOrderId"`
+	TakerOwner   string       `json:"takerOwner"`
+	MakerOwner   string       `json:"makerOwner"`
+	TakerSide    Side         `json:"takerSide"`
+	Price        model.Amount `json:"price"`
+	Quantity     model.Amount `json:"quantity"`
+}
+
+type OrderCancelled struct {
+	OrderID   string       `json:"orderId"`
+	Remaining model.Amount `json:"remaining"`
+	Reason    string       `json:"reason"`
+}
+
+// Order is a live order. Price is in quote units per whole base unit and
+// Remaining in base units.
+type Order struct {
+	ID        string
+	Owner     string
+	Side      Side
+	Type      OrderType
+	Price     *big.Int
+	Remaining *big.Int
+	SelfTrade SelfTrade
+	// Seq orders arrival; lower is earlier and fills first at equal prices.
+	Seq int64
+}
+
+// Book is the state of an order book aggregate. Bids are sorted best (highest)
+// first and asks best (lowest) first, each by arrival within a price.
+type Book struct {
+	Status        model.State
+	Base          string
+	Quote         string
+	BaseDecimals  uint8
+	QuoteDecimals uint8
+	Bids          []*Order
+	Asks          []*Order
+	// Orders holds live orders by ID; Known every order ID ever placed.
+	Orders  map[string]*Order
+	Known   map[string]bool
+	NextSeq int64
+}
+
+var bookLifecycle = aggregate.NewMachine[*Book](model.StateInitial).
+	Allow(model.StateInitial, EventBookOpened, model.StateActive).
+	Allow(model.StateActive, EventOrderPlaced, model.StateActive).
+	Allow(model.StateActive, EventOrderAmended, model.StateActive).
+	Allow(model.StateActive, EventOrderMatched, model.StateActive).
+	Allow(model.StateActive, EventOrderCancelled, model.StateActive)
+
+func NewBook() *Book {
+	return &Book{Orders: make(map[string]*Order), Known: make(map[string]bool)}
+}
+
+// UnmarshalJSON decodes a book encoded with encoding/json, e.g. by the state
+// cache. Resting orders are shared between Bids or Asks and Orders, so they
+// are linked up again after decoding.
+func (b *Book) UnmarshalJSON(data []byte) error {
+	type plain Book
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+	if b.Orders == nil {
+		b.Orders = make(map[string]*Order)
+	}
+	if b.Known == nil {
+		b.Known = make(map[string]bool)
+	}
+	for _, levels := range [][]*Order{b.Bids, b.Asks} {
+		for _, o := range levels {
+			b.Orders[o.ID] = o
+		}
+	}
+	return nil
+}
+
+func (b *Book) Apply(event model.Event) error {
+	next, err := bookLifecycle.Next(b, b.Status, event)
+	if err != nil {
+		return err
+	}
+	switch event.Type {
+	case EventBookOpened:
+		var e BookOpened
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		b.Base, b.Quote, b.BaseDecimals, b.QuoteDecimals = e.Base, e.Quote, e.BaseDecimals, e.QuoteDecimals
+	case EventOrderPlaced:
+		var e OrderPlaced
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		if b.Known[e.OrderID] {
+			return fmt.Errorf("order %s already exists", e.OrderID)
+		}
+		o := &Order{
+			ID:        e.OrderID,
+			Owner:     e.Owner,
+			Side:      e.Side,
+			Type:      e.Type,
+			Price:     e.Price.Units(),
+			Remaining: e.Quantity.Units(),
+			SelfTrade: e.SelfTrade,
+			Seq:       b.NextSeq,
+		}
+		b.NextSeq++
+		b.Known[o.ID] = true
+		b.Orders[o.ID] = o
+		if o.Type == Limit {
+			b.insert(o)
+		}
+	case EventOrderAmended:
+		var e OrderAmended
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		o := b.Orders[e.OrderID]
+		if o == nil {
+			return fmt.Errorf("order %s is not live", e.OrderID)
+		}
+		b.remove(o)
+		o.Price, o.Remaining = e.Price.Units(), e.Quantity.Units()
+		if e.Requeued {
+			o.Seq = b.NextSeq
+			b.NextSeq++
+		}
+		b.insert(o)
+	case EventOrderMatched:
+		var e OrderMatched
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		for _, id := range []string{e.TakerOrderID, e.MakerOrderID} {
+			if err := b.fill(id, e.Quantity.Units()); err != nil {
+				return err
+			}
+		}
+	case EventOrderCancelled:
+		var e OrderCancelled
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		o := b.Orders[e.OrderID]
+		if o == nil {
+			return fmt.Errorf("order %s is not live", e.OrderID)
+		}
+		b.remove(o)
+		delete(b.Orders, o.ID)
+	}
+	b.Status = next
+	return nil
+}
+
+func (b *Book) fill(id string, quantity *big.Int) error {
+	o := b.Orders[id]
+	if o == nil {
+		return fmt.Errorf("order %s is not live", id)
+	}
+	if o.Remaining.Cmp(quantity) < 0 {
+		return fmt.Errorf("order %s has only %s left", id, o.Remaining)
+	}
+	o.Remaining = new(big.Int).Sub(o.Remaining, quantity)
+	if o.Remaining.Sign() == 0 {
+		b.remove(o)
+		delete(b.Orders, id)
+	}
+	return nil
+}
+
+func (b *Book) side(s Side) *[]*Order {
+	if s == Buy {
+		return &b.Bids
+	}
+	return &b.Asks
+}
+
+// before reports whether x has priority over y on the same side.
+func before(x, y *Order) bool {
+	if c := x.Price.Cmp(y.Price); c != 0 {
+		return (c > 0) == (x.Side == Buy)
+	}
+	return x.Seq < y.Seq
+}
+
+func (b *Book) insert(o *Order) {
+	levels := b.side(o.Side)
+	i := sort.Search(len(*levels), func(i int) bool { return before(o, (*levels)[i]) })
+	*levels = append(*levels, nil)
+	copy((*levels)[i+1:], (*levels)[i:])
+	(*levels)[i] = o
+}
+
+func (b *Book) remove(o *Order) {
+	levels := b.side(o.Side)
+	for i, resting := range *levels {
+		if resting == o {
+			*levels = append((*levels)[:i], (*levels)[i+1:]...)
+			return
+		}
+	}
+}
+
+func decode(event model.Event, out interface{}) error {
+	if err := json.Unmarshal([]byte(event.Data), out); err != nil {
+		return fmt.Errorf("invalid %s: %w", event.Type, err)
+	}
+	return nil
+}
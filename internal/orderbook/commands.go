@@ -0,0 +1,202 @@
+package orderbook
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/command"
+	"defi/internal/model"
+	"errors"
+)
+
+type OpenBook struct {
+	BookID        string
+	Base          string
+	Quote         string
+	BaseDecimals  uint8
+	QuoteDecimals uint8
+}
+
+func (c OpenBook) Validate() error {
+	if c.BookID == "" || c.Base == "" || c.Quote == "" || c.Base == c.Quote {
+		return errors.New("book ID and two different assets are required")
+	}
+	if err := model.CheckAssetDecimals(c.Base, c.BaseDecimals); err != nil {
+		return err
+	}
+	return model.CheckAssetDecimals(c.Quote, c.QuoteDecimals)
+}
+
+// PlaceOrder places a limit order, which rests at Price once it stops
+// matching, or a market order, which matches at any price and cancels what
+// it cannot fill. Price is in quote units per whole base unit; Quantity is in
+// base units.
+type PlaceOrder struct {
+	BookID    string
+	OrderID   string
+	Owner     string
+	Side      Side
+	Type      OrderType
+	Price     model.Amount
+	Quantity  model.Amount
+	SelfTrade SelfTrade
+}
+
+func (c PlaceOrder) Validate() error {
+	switch {
+	case c.BookID == "" || c.OrderID == "" || c.Owner == "":
+		return errors.New("book ID, order ID and owner are required")
+	case c.Side != Buy && c.Side != Sell:
+		return errors.New("side must be buy or sell")
+	case c.Type != Limit && c.Type != Market:
+		return errors.New("type must be limit or market")
+	case c.Type == Limit && c.Price.Sign() <= 0:
+		return errors.New("limit orders need a positive price")
+	case c.Type == Market && !c.Price.IsZero():
+		return errors.New("market orders take no price")
+	case c.Quantity.Sign() <= 0:
+		return errors.New("quantity must be positive")
+	case c.SelfTrade != "" && c.SelfTrade != CancelResting && c.SelfTrade != CancelIncoming:
+		return errors.New("self-trade prevention must be cancel_resting or cancel_incoming")
+	}
+	return nil
+}
+
+type CancelOrder struct {
+	BookID  string
+	OrderID string
+	Owner   string
+}
+
+func (c CancelOrder) Validate() error {
+	if c.BookID == "" || c.OrderID == "" || c.Owner == "" {
+		return errors.New("book ID, order ID and owner are required")
+	}
+	return nil
+}
+
+// AmendOrder changes the price or remaining quantity of a resting limit
+// order. Only reducing the quantity keeps the order's time priority.
+type AmendOrder struct {
+	BookID   string
+	OrderID  string
+	Owner    string
+	Price    model.Amount
+	Quantity model.Amount
+}
+
+func (c AmendOrder) Validate() error {
+	switch {
+	case c.BookID == "" || c.OrderID == "" || c.Owner == "":
+		return errors.New("book ID, order ID and owner are required")
+	case c.Price.Sign() <= 0 || c.Quantity.Sign() <= 0:
+		return errors.New("price and quantity must be positive")
+	}
+	return nil
+}
+
+// Register adds the order book handlers to bus.
+func Register(bus *command.Bus, store aggregate.Store) {
+	books := aggregate.NewRepository(store, BookType, NewBook)
+	command.Register(bus, command.Handle(books, func(c OpenBook) string { return c.BookID }, openBook))
+	command.Register(bus, command.Handle(books, func(c PlaceOrder) string { return c.BookID }, placeOrder))
+	command.Register(bus, command.Handle(books, func(c CancelOrder) string { return c.BookID }, cancelOrder))
+	command.Register(bus, command.Handle(books, func(c AmendOrder) string { return c.BookID }, amendOrder))
+}
+
+func openBook(ctx context.Context, c OpenBook, root *aggregate.Root[*Book]) error {
+	return root.Raise(EventBookOpened, BookOpened{Base: c.Base, Quote: c.Quote, BaseDecimals: c.BaseDecimals, QuoteDecimals: c.QuoteDecimals})
+}
+
+func placeOrder(ctx context.Context, c PlaceOrder, root *aggregate.Root[*Book]) error {
+	b := root.State
+	if b.Status != model.StateActive {
+		return command.Rejectf("book %s is not open", root.ID)
+	}
+	if b.Known[c.OrderID] {
+		return command.Rejectf("order %s already exists", c.OrderID)
+	}
+	if err := b.checkDecimals(c.Price, c.Quantity, c.Type == Market); err != nil {
+		return err
+	}
+	if c.SelfTrade == "" {
+		c.SelfTrade = CancelResting
+	}
+	price := c.Price
+	if c.Type == Market {
+		price = model.Units(0, b.QuoteDecimals)
+	}
+	err := root.Raise(EventOrderPlaced, OrderPlaced{
+		OrderID:   c.OrderID,
+		Owner:     c.Owner,
+		Side:      c.Side,
+		Type:      c.Type,
+		Price:     price,
+		Quantity:  c.Quantity,
+		SelfTrade: c.SelfTrade,
+	})
+	if err != nil {
+		return err
+	}
+	return raiseMatches(root, c.OrderID)
+}
+
+func cancelOrder(ctx context.Context, c CancelOrder, root *aggregate.Root[*Book]) error {
+	o, err := owned(root, c.OrderID, c.Owner)
+	if err != nil {
+		return err
+	}
+	cancelled, err := root.State.cancellation(o.ID, o.Remaining, ReasonRequested)
+	if err != nil {
+		return err
+	}
+	return root.Raise(cancelled.eventType, cancelled.payload)
+}
+
+func amendOrder(ctx context.Context, c AmendOrder, root *aggregate.Root[*Book]) error {
+	o, err := owned(root, c.OrderID, c.Owner)
+	if err != nil {
+		return err
+	}
+	if err := root.State.checkDecimals(c.Price, c.Quantity, false); err != nil {
+		return err
+	}
+	requeued := o.Price.Cmp(c.Price.Units()) != 0 || c.Quantity.Units().Cmp(o.Remaining) > 0
+	if err := root.Raise(EventOrderAmended, OrderAmended{OrderID: o.ID, Price: c.Price, Quantity: c.Quantity, Requeued: requeued}); err != nil {
+		return err
+	}
+	// A new price may cross the spread.
+	return raiseMatches(root, o.ID)
+}
+
+// raiseMatches runs the matching engine for the order just placed or
+// amended. Reexecute runs the same code on replay.
+func raiseMatches(root *aggregate.Root[*Book], orderID string) error {
+	outcomes, err := root.State.match(root.State.Orders[orderID])
+	if err != nil {
+		return err
+	}
+	for _, o := range outcomes {
+		if err := root.Raise(o.eventType, o.payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func owned(root *aggregate.Root[*Book], orderID, owner string) (*Order, error) {
+	o := root.State.Orders[orderID]
+	if o == nil || o.Type != Limit {
+		return nil, command.Rejectf("order %s is not resting in book %s", orderID, root.ID)
+	}
+	if o.Owner != owner {
+		return nil, command.Rejectf("order %s belongs to another owner", orderID)
+	}
+	return o, nil
+}
+
+func (b *Book) checkDecimals(price, quantity model.Amount, market bool) error {
+	if quantity.Decimals() != b.BaseDecimals || !market && price.Decimals() != b.QuoteDecimals {
+		return command.Rejectf("prices need %d decimals and quantities %d", b.QuoteDecimals, b.BaseDecimals)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package orderbook
+
+import (
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"fmt"
+	"math/big"
+)
+
+// DefaultTradeHistory is how many trades per book Market keeps by default.
+const DefaultTradeHistory = 1000
+
+// Level is the total quantity resting at one price.
+type Level struct {
+	Price    model.Amount `json:"price"`
+	Quantity model.Amount `json:"quantity"`
+	Orders   int          `json:"orders"`
+}
+
+// Depth is a snapshot of the best levels of a book as of Version.
+type Depth struct {
+	BookID  string  `json:"bookId"`
+	Version int64   `json:"version"`
+	Bids    []Level `json:"bids"`
+	Asks    []Level `json:"asks"`
+}
+
+// Trade is one fill.
+type Trade struct {
+	OrderMatched
+	Timestamp int64 `json:"timestamp"`
+	Version   int64 `json:"version"`
+}
+
+// MarketData is a read model of order book depth and recent trades. Feed it
+// book events with Handle or CatchUp; events already seen are ignored.
+type MarketData struct {
+	*eventstore.ReadModel[*bookData]
+	// History bounds the trades kept per book; zero means
+	// DefaultTradeHistory.
+	History int
+}
+
+type bookData struct {
+	book   *Book
+	trades []Trade
+}
+
+func NewMarketData() *MarketData {
+	m := &MarketData{}
+	m.ReadModel = eventstore.NewReadModel(BookType, func() *bookData { return &bookData{book: NewBook()} }, m.apply)
+	return m
+}
+
+func (m *MarketData) apply(d *bookData, event model.Event) error {
+	if err := d.book.Apply(event); err != nil {
+		return err
+	}
+	if event.Type == EventOrderMatched {
+		var e OrderMatched
+		if err := decode(event, &e); err != nil {
+			return err
+		}
+		d.trades = append(d.trades, Trade{OrderMatched: e, Timestamp: event.Timestamp, Version: event.Version})
+		history := m.History
+		if history <= 0 {
+			history = DefaultTradeHistory
+		}
+		if len(d.trades) > history {
+			d.trades = append([]Trade(nil), d.trades[len(d.trades)-history:]...)
+		}
+	}
+	return nil
+}
+
+// Depth returns up to levels price levels per side of bookID, best first.
+func (m *MarketData) Depth(bookID string, levels int) (Depth, error) {
+	depth := Depth{BookID: bookID}
+	var err error
+	if !m.View(bookID, func(d *bookData, version int64) {
+		depth.Version = version
+		if depth.Bids, err = aggregateLevels(d.book.Bids, levels, d.book); err != nil {
+			return
+		}
+		depth.Asks, err = aggregateLevels(d.book.Asks, levels, d.book)
+	}) {
+		return Depth{}, fmt.Errorf("unknown book %s", bookID)
+	}
+	if err != nil {
+		return Depth{}, err
+	}
+	return depth, nil
+}
+
+func aggregateLevels(orders []*Order, levels int, b *Book) ([]Level, error) {
+	result := []Level{}
+	var price, quantity *big.Int
+	count := 0
+	flush := func() error {
+		p, err := model.NewAmount(price, b.QuoteDecimals)
+		if err != nil {
+			return err
+		}
+		q, err := model.NewAmount(quantity, b.BaseDecimals)
+		if err != nil {
+			return err
+		}
+		result = append(result, Level{Price: p, Quantity: q, Orders: count})
+		return nil
+	}
+	for _, o := range orders {
+		if price != nil && o.Price.Cmp(price) != 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			if len(result) == levels {
+				return result, nil
+			}
+			price = nil
+		}
+		if price == nil {
+			price, quantity, count = o.Price, new(big.Int), 0
+		}
+		quantity.Add(quantity, o.Remaining)
+		count++
+	}
+	if price != nil {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Trades returns up to limit of the most recent trades of bookID, oldest
+// first.
+func (m *MarketData) Trades(bookID string, limit int) []Trade {
+	var trades []Trade
+	m.View(bookID, func(d *bookData, _ int64) {
+		recent := d.trades
+		if limit > 0 && len(recent) > limit {
+			recent = recent[len(recent)-limit:]
+		}
+		trades = append([]Trade(nil), recent...)
+	})
+	return trades
+}
@@ -0,0 +1,174 @@
+package orderbook
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/model"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// outcome is an event the matching engine produces for an incoming order.
+type outcome struct {
+	eventType string
+	payload   interface{}
+}
+
+// match returns the fills and cancellations taker produces against the
+// opposite side of b, in order, without changing b. Fills are at the maker's
+// price, best price first and earliest first within a price.
+func (b *Book) match(taker *Order) ([]outcome, error) {
+	opposite := b.Asks
+	if taker.Side == Sell {
+		opposite = b.Bids
+	}
+	var outcomes []outcome
+	remaining := new(big.Int).Set(taker.Remaining)
+	for _, maker := range opposite {
+		if remaining.Sign() == 0 || !crosses(taker, maker) {
+			break
+		}
+		if maker.Owner == taker.Owner {
+			if taker.SelfTrade == CancelIncoming {
+				cancelled, err := b.cancellation(taker.ID, remaining, ReasonSelfTrade)
+				if err != nil {
+					return nil, err
+				}
+				return append(outcomes, cancelled), nil
+			}
+			cancelled, err := b.cancellation(maker.ID, maker.Remaining, ReasonSelfTrade)
+			if err != nil {
+				return nil, err
+			}
+			outcomes = append(outcomes, cancelled)
+			continue
+		}
+
+		quantity := new(big.Int).Set(remaining)
+		if maker.Remaining.Cmp(quantity) < 0 {
+			quantity.Set(maker.Remaining)
+		}
+		remaining.Sub(remaining, quantity)
+		e := OrderMatched{
+			TakerOrderID: taker.ID,
+			MakerOrderID: maker.ID,
+			TakerOwner:   taker.Owner,
+			MakerOwner:   maker.Owner,
+			TakerSide:    taker.Side,
+		}
+		var err error
+		if e.Price, err = model.NewAmount(maker.Price, b.QuoteDecimals); err != nil {
+			return nil, err
+		}
+		if e.Quantity, err = model.NewAmount(quantity, b.BaseDecimals); err != nil {
+			return nil, err
+		}
+		outcomes = append(outcomes, outcome{EventOrderMatched, e})
+	}
+	if remaining.Sign() > 0 && taker.Type == Market {
+		cancelled, err := b.cancellation(taker.ID, remaining, ReasonUnfilled)
+		if err != nil {
+			return nil, err
+		}
+		outcomes = append(outcomes, cancelled)
+	}
+	return outcomes, nil
+}
+
+func crosses(taker, maker *Order) bool {
+	if taker.Type == Market {
+		return true
+	}
+	if taker.Side == Buy {
+		return maker.Price.Cmp(taker.Price) <= 0
+	}
+	return maker.Price.Cmp(taker.Price) >= 0
+}
+
+func (b *Book) cancellation(orderID string, remaining *big.Int, reason string) (outcome, error) {
+	amount, err := model.NewAmount(remaining, b.BaseDecimals)
+	if err != nil {
+		return outcome{}, err
+	}
+	return outcome{EventOrderCancelled, OrderCancelled{OrderID: orderID, Remaining: amount, Reason: reason}}, nil
+}
+
+// ReexecuteBook loads the events of bookID from store and rebuilds the book
+// with Reexecute, failing unless the engine reproduces exactly the recorded
+// fills and cancellations.
+func ReexecuteBook(ctx context.Context, store aggregate.Store, bookID string) (*Book, error) {
+	events, err := store.LoadEvents(ctx, bookID, 0)
+	if err != nil {
+		return nil, err
+	}
+	book, err := Reexecute(events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-execute order book %s: %w", bookID, err)
+	}
+	return book, nil
+}
+
+// Reexecute rebuilds a book from its events, re-running the matching engine
+// for every placed or amended order and checking that it yields exactly the
+// recorded fills and cancellations. It is how replay proves that stored
+// fills are deterministic.
+func Reexecute(events []model.Event) (*Book, error) {
+	book := NewBook()
+	var expected []outcome
+	for _, event := range events {
+		generated := event.Type == EventOrderMatched || event.Type == EventOrderCancelled && !isRequested(event)
+		if generated {
+			if len(expected) == 0 {
+				return nil, fmt.Errorf("event %d: unexpected %s", event.Version, event.Type)
+			}
+			want, err := json.Marshal(expected[0].payload)
+			if err != nil {
+				return nil, err
+			}
+			if event.Type != expected[0].eventType || event.Data != string(want) {
+				return nil, fmt.Errorf("event %d: recorded %s %s, engine produced %s %s", event.Version, event.Type, event.Data, expected[0].eventType, want)
+			}
+			expected = expected[1:]
+		} else if len(expected) > 0 {
+			return nil, fmt.Errorf("event %d: engine produced %s that was not recorded", event.Version, expected[0].eventType)
+		}
+
+		if err := book.Apply(event); err != nil {
+			return nil, fmt.Errorf("event %d: %w", event.Version, err)
+		}
+
+		if event.Type == EventOrderPlaced || event.Type == EventOrderAmended {
+			taker, err := takerOf(event, book)
+			if err != nil {
+				return nil, err
+			}
+			if expected, err = book.match(taker); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(expected) > 0 {
+		return nil, fmt.Errorf("engine produced %s that was not recorded", expected[0].eventType)
+	}
+	return book, nil
+}
+
+func takerOf(event model.Event, book *Book) (*Order, error) {
+	var ref struct {
+		OrderID string `json:"orderId"`
+	}
+	if err := decode(event, &ref); err != nil {
+		return nil, err
+	}
+	taker := book.Orders[ref.OrderID]
+	if taker == nil {
+		return nil, fmt.Errorf("event %d: order %s is not live", event.Version, ref.OrderID)
+	}
+	return taker, nil
+}
+
+func isRequested(event model.Event) bool {
+	var e OrderCancelled
+	return decode(event, &e) == nil && e.Reason == ReasonRequested
+}
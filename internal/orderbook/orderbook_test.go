@@ -0,0 +1,188 @@
+package orderbook
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMatching(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	bus := command.NewBus()
+	Register(bus, store)
+	price := func(s string) model.Amount { return model.MustParseAmount(s, 6) }
+	qty := func(s string) model.Amount { return model.MustParseAmount(s, 18) }
+	limit := func(id, owner string, side Side, p, q string) PlaceOrder {
+		return PlaceOrder{BookID: "eth", OrderID: id, Owner: owner, Side: side, Type: Limit, Price: price(p), Quantity: qty(q)}
+	}
+
+	steps := []interface{}{
+		OpenBook{BookID: "eth", Base: "ETH", Quote: "USDC", BaseDecimals: 18, QuoteDecimals: 6},
+		limit("a1", "alice", Sell, "2001", "1"),
+		limit("a2", "alice", Sell, "2000", "1"),
+		limit("b1", "bob", Sell, "2000", "2"),
+		limit("c1", "carol", Buy, "1990", "5"),
+		// Takes a2 before b1 at the same price, then part of b1.
+		limit("d1", "dave", Buy, "2000", "1.5"),
+		// Takes the rest of b1, then meets alice's own ask a1, which is
+		// cancelled; the remaining 1 rests at 2001.
+		limit("a3", "alice", Buy, "2001", "2.5"),
+		CancelOrder{BookID: "eth", OrderID: "c1", Owner: "carol"},
+		limit("c2", "carol", Buy, "1995", "2"),
+		limit("b2", "bob", Sell, "2005", "1"),
+		// The new price crosses the spread and fills against a3 at its price.
+		AmendOrder{BookID: "eth", OrderID: "b2", Owner: "bob", Price: price("2001"), Quantity: qty("1")},
+		// Fills c2; the unfilled rest of a market order is cancelled.
+		PlaceOrder{BookID: "eth", OrderID: "m1", Owner: "erin", Side: Sell, Type: Market, Quantity: qty("3")},
+	}
+	for i, cmd := range steps {
+		if err := bus.Dispatch(ctx, cmd); err != nil {
+			t.Fatalf("step %d (%T): %v", i, cmd, err)
+		}
+	}
+	if err := bus.Dispatch(ctx, limit("a1", "alice", Sell, "2100", "1")); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("reusing an order ID: got %v", err)
+	}
+
+	data := NewMarketData()
+	if err := data.CatchUp(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	var fills []string
+	for _, tr := range data.Trades("eth", 0) {
+		fills = append(fills, tr.TakerOrderID+">"+tr.MakerOrderID+"@"+tr.Price.String()+"x"+tr.Quantity.String())
+	}
+	want := []string{
+		"d1>a2@2000.000000x1.000000000000000000",
+		"d1>b1@2000.000000x0.500000000000000000",
+		"a3>b1@2000.000000x1.500000000000000000",
+		"b2>a3@2001.000000x1.000000000000000000",
+		"m1>c2@1995.000000x2.000000000000000000",
+	}
+	if !reflect.DeepEqual(fills, want) {
+		t.Errorf("fills:\n got %v\nwant %v", fills, want)
+	}
+	depth, err := data.Depth("eth", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depth.Bids) != 0 || len(depth.Asks) != 0 {
+		t.Errorf("book should be empty, got %+v", depth)
+	}
+
+	// Replaying the stored events rebuilds the same book, and re-running the
+	// engine over them reproduces every recorded fill.
+	events, err := store.LoadEvents(ctx, "eth", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := aggregate.NewRepository(store, BookType, NewBook).Load(ctx, "eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reexecuted, err := Reexecute(events)
+	if err != nil {
+		t.Fatalf("reexecute: %v", err)
+	}
+	if !reflect.DeepEqual(rebuilt.State, reexecuted) {
+		t.Error("replayed and re-executed books differ")
+	}
+
+	// Tampering with a fill is detected.
+	for i := range events {
+		if events[i].Type == EventOrderMatched {
+			events[i].Data = `{"takerOrderId":"d1","makerOrderId":"b1"}`
+			break
+		}
+	}
+	if _, err := Reexecute(events); err == nil {
+		t.Error("expected a tampered fill to be detected")
+	}
+}
+
+func TestBookSurvivesEncoding(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	bus := command.NewBus()
+	Register(bus, store)
+	for _, cmd := range []interface{}{
+		OpenBook{BookID: "eth", Base: "ETH", Quote: "USDC", BaseDecimals: 18, QuoteDecimals: 6},
+		PlaceOrder{BookID: "eth", OrderID: "a1", Owner: "alice", Side: Sell, Type: Limit, Price: model.MustParseAmount("2001", 6), Quantity: model.MustParseAmount("1", 18)},
+		PlaceOrder{BookID: "eth", OrderID: "b1", Owner: "bob", Side: Buy, Type: Limit, Price: model.MustParseAmount("1999", 6), Quantity: model.MustParseAmount("1", 18)},
+	} {
+		if err := bus.Dispatch(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := aggregate.NewRepository(store, BookType, NewBook).Load(ctx, "eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := root.State
+	raw, err := json.Marshal(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewBook()
+	if err := json.Unmarshal(raw, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(book, decoded) {
+		t.Fatalf("decoded book differs:\n got %+v\nwant %+v", decoded, book)
+	}
+	// Fills through Orders must show in Bids and Asks, as in a replayed book.
+	if decoded.Orders["a1"] != decoded.Asks[0] || decoded.Orders["b1"] != decoded.Bids[0] {
+		t.Error("resting orders are not shared between Orders and the book sides")
+	}
+}
+
+// tampered changes the quantity of every stored fill.
+type tampered struct{ aggregate.Store }
+
+func (s tampered) LoadEvents(ctx context.Context, aggregateID string, afterVersion int64) ([]model.Event, error) {
+	events, err := s.Store.LoadEvents(ctx, aggregateID, afterVersion)
+	for i := range events {
+		if events[i].Type == EventOrderMatched {
+			events[i].Data = `{"takerOrderId":"b1","makerOrderId":"a1","price":"2000.000000","quantity":"0.500000000000000000"}`
+		}
+	}
+	return events, err
+}
+
+func TestReexecuteBook(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	bus := command.NewBus()
+	Register(bus, store)
+	order := func(id, owner string, side Side, price string) PlaceOrder {
+		return PlaceOrder{BookID: "eth", OrderID: id, Owner: owner, Side: side, Type: Limit, Price: model.MustParseAmount(price, 6), Quantity: model.MustParseAmount("1", 18)}
+	}
+	for _, cmd := range []interface{}{
+		OpenBook{BookID: "eth", Base: "ETH", Quote: "USDC", BaseDecimals: 18, QuoteDecimals: 6},
+		order("a1", "alice", Sell, "2000"),
+		order("b1", "bob", Buy, "2001"),
+		order("a2", "alice", Sell, "2002"),
+	} {
+		if err := bus.Dispatch(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	book, err := ReexecuteBook(ctx, store, "eth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Asks) != 1 || book.Asks[0].ID != "a2" || len(book.Bids) != 0 {
+		t.Errorf("unexpected book after re-execution: %+v", book)
+	}
+	if _, err := ReexecuteBook(ctx, tampered{store}, "eth"); err == nil {
+		t.Error("expected a tampered fill to fail the re-execution")
+	}
+}
@@ -56,3 +56,10 @@ func (p *Projection) GetState() map[string]interface{} {
 	defer p.mu.Unlock()
 	return p.State
 }
+
+// SetState replaces the projection state, e.g. with a cached snapshot.
+func (p *Projection) SetState(state map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.State = state
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets are dropped.
+const sweepInterval = time.Minute
+
+// MemoryLimiter counts in process, for single-replica deployments, tests
+// and when Redis is unreachable.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     int64
+	// full is when the bucket will be full again, after which it can be
+	// dropped: a new one starts full.
+	full time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	if limit.PerMinute <= 0 {
+		return unlimited(), nil
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for k, b := range l.buckets {
+			if now.After(b.full) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), at: now.UnixMilli()}
+		l.buckets[key] = b
+	}
+	var allowed bool
+	b.tokens, b.at, allowed = take(limit, b.tokens, b.at, now.UnixMilli())
+	r := result(limit, b.tokens, allowed)
+	b.full = now.Add(r.Reset)
+	return r, nil
+}
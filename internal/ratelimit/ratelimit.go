@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"defi/internal/db"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled at PerMinute.
+// A zero PerMinute means no limit.
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+func (l Limit) perMilli() float64 {
+	return float64(l.PerMinute) / float64(time.Minute/time.Millisecond)
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket and Remaining the tokens left in it.
+	Limit     int
+	Remaining int
+	// Reset is when the bucket will be full again.
+	Reset time.Duration
+	// RetryAfter is when the next token is available, if none is now.
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from buckets identified by key.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// New returns a Limiter counting in Redis if the Redis cluster is
+// configured, shared by every replica, and in process otherwise.
+func New(database *db.DB) Limiter {
+	if database.Redis() != nil {
+		return NewRedisLimiter(database.Redis)
+	}
+	return NewMemoryLimiter()
+}
+
+// take refills a bucket that held tokens at at, in milliseconds, and takes
+// one token from it if there is one.
+func take(limit Limit, tokens float64, at, now int64) (float64, int64, bool) {
+	if now > at {
+		tokens = math.Min(float64(limit.Burst), tokens+float64(now-at)*limit.perMilli())
+		at = now
+	}
+	if tokens < 1 {
+		return tokens, at, false
+	}
+	return tokens - 1, at, true
+}
+
+func result(limit Limit, tokens float64, allowed bool) Result {
+	rate := limit.perMilli()
+	r := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(tokens),
+		Reset:     time.Duration(math.Ceil((float64(limit.Burst)-tokens)/rate)) * time.Millisecond,
+	}
+	if !allowed {
+		r.RetryAfter = time.Duration(math.Ceil((1-tokens)/rate)) * time.Millisecond
+	}
+	return r
+}
+
+// unlimited is the result of a request no limit applies to.
+func unlimited() Result {
+	return Result{Allowed: true}
+}
@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	limit := Limit{PerMinute: 60, Burst: 2}
+	tokens, at := float64(limit.Burst), int64(0)
+	var allowed bool
+	for i, want := range []bool{true, true, false} {
+		if tokens, at, allowed = take(limit, tokens, at, 0); allowed != want {
+			t.Fatalf("request %d: allowed %v", i, allowed)
+		}
+	}
+	r := result(limit, tokens, false)
+	if r.Remaining != 0 || r.RetryAfter != time.Second || r.Reset != 2*time.Second {
+		t.Errorf("empty bucket: %+v", r)
+	}
+	// Half a second refills half a token; a second a whole one.
+	if tokens, at, allowed = take(limit, tokens, at, 500); allowed {
+		t.Error("allowed with half a token")
+	}
+	if tokens, at, allowed = take(limit, tokens, at, 1000); !allowed || tokens != 0 {
+		t.Errorf("after a second: allowed %v with %v tokens left", allowed, tokens)
+	}
+	// The bucket never holds more than Burst.
+	if tokens, _, _ = take(limit, tokens, at, 60_000); tokens != float64(limit.Burst-1) {
+		t.Errorf("after a minute: %v tokens", tokens)
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryLimiter()
+	limit := Limit{PerMinute: 1, Burst: 1}
+	if r, _ := l.Allow(ctx, "a", limit); !r.Allowed || r.Remaining != 0 {
+		t.Fatalf("first: %+v", r)
+	}
+	if r, _ := l.Allow(ctx, "a", limit); r.Allowed || r.RetryAfter <= 0 {
+		t.Errorf("second: %+v", r)
+	}
+	if r, _ := l.Allow(ctx, "b", limit); !r.Allowed {
+		t.Errorf("other key: %+v", r)
+	}
+	if r, _ := l.Allow(ctx, "a", Limit{}); !r.Allowed {
+		t.Errorf("unlimited: %+v", r)
+	}
+}
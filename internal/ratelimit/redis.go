@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const redisPrefix = "ratelimit:"
+
+// takeScript is take run atomically on a bucket stored as a hash of its
+// tokens and the time they were counted. Buckets expire once full.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(state[1]) or burst
+local at = tonumber(state[2]) or now
+if now > at then
+  tokens = math.min(burst, tokens + (now - at) * rate)
+  at = now
+end
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(at))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisLimiter counts in Redis, so limits hold across replicas. While
+// Redis fails it counts in Fallback instead, per replica. It looks its
+// client up on every call so it follows db.DB.ReconfigureRedis.
+type RedisLimiter struct {
+	client   func() *redis.ClusterClient
+	Fallback Limiter
+	failing  atomic.Bool
+}
+
+func NewRedisLimiter(client func() *redis.ClusterClient) *RedisLimiter {
+	return &RedisLimiter{client: client, Fallback: NewMemoryLimiter()}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.PerMinute <= 0 {
+		return unlimited(), nil
+	}
+	r, err := l.allow(ctx, key, limit)
+	if err != nil {
+		if !l.failing.Swap(true) {
+			log.Printf("Rate limiting in process while Redis fails: %v", err)
+		}
+		return l.Fallback.Allow(ctx, key, limit)
+	}
+	if l.failing.Swap(false) {
+		log.Printf("Rate limiting in Redis again")
+	}
+	return r, nil
+}
+
+func (l *RedisLimiter) allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now().UnixMilli()
+	reply, err := takeScript.Run(ctx, l.client(), []string{redisPrefix + key}, limit.perMilli(), limit.Burst, now).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("redis rate limit %s: %w", key, err)
+	}
+	if len(reply) != 2 {
+		return Result{}, fmt.Errorf("redis rate limit %s: unexpected reply %v", key, reply)
+	}
+	allowed, _ := reply[0].(int64)
+	text, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("redis rate limit %s: invalid tokens %q", key, text)
+	}
+	return result(limit, tokens, allowed == 1), nil
+}
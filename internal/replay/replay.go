@@ -1,8 +1,11 @@
 package replay
 
 import (
+	"context"
+	"defi/internal/aggregate"
 	"defi/internal/eventstore"
 	"defi/internal/projection"
+	"fmt"
 )
 
 func ReplayEvents(es *eventstore.BaseEventStore, p *projection.Projection, aggregateID string) error {
@@ -14,3 +17,20 @@ func ReplayEvents(es *eventstore.BaseEventStore, p *projection.Projection, aggre
 
 	return nil
 }
+
+// Aggregate rebuilds state from the events of aggregateID in version order,
+// as command handlers see it, and returns the version reached.
+func Aggregate(ctx context.Context, store aggregate.Store, aggregateID string, state aggregate.State) (int64, error) {
+	events, err := store.LoadEvents(ctx, aggregateID, 0)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for _, event := range events {
+		if err := state.Apply(event); err != nil {
+			return version, fmt.Errorf("failed to replay %s event %d of %s: %w", event.Type, event.Version, aggregateID, err)
+		}
+		version = event.Version
+	}
+	return version, nil
+}
@@ -0,0 +1,399 @@
+package saga
+
+import (
+	"context"
+	"defi/internal/aggregate"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrStarted is returned by Start for saga IDs that are already in use.
+var ErrStarted = errors.New("saga already started")
+
+// Step is one step of a saga definition.
+type Step struct {
+	// Name identifies the step in the metadata of the events it causes and
+	// must be unique within the definition.
+	Name string
+	// Action dispatches the step's command. An error wrapping
+	// command.ErrRejected or a *command.ValidationError fails the step for
+	// good; other errors are retried by Tick up to MaxAttempts times. An
+	// action can run again if the process stops before its result is
+	// recorded, so commands should be idempotent, e.g. by deriving entity
+	// IDs from the saga ID.
+	Action func(ctx context.Context, inst *Instance) error
+	// Await, when set, keeps the step open after Action until an event
+	// caused by it completes the step. It may update the saga data from the
+	// event; an error fails the step for good.
+	Await func(inst *Instance, event model.Event) (bool, error)
+	// Timeout bounds the wait for Await; zero waits forever.
+	Timeout time.Duration
+	// Compensate undoes the step when the saga rolls back. It is retried
+	// until it succeeds, so it must be idempotent too. A step whose event
+	// timed out is compensated as well, since its command may have run.
+	Compensate func(ctx context.Context, inst *Instance) error
+}
+
+// Definition is a named sequence of steps. A saga runs them in order and,
+// if one fails, compensates the completed ones in reverse order.
+type Definition struct {
+	Name  string
+	Steps []Step
+	// MaxAttempts bounds the attempts of a step failing with a transient
+	// error; zero means command.DefaultMaxAttempts.
+	MaxAttempts int
+}
+
+func (d *Definition) maxAttempts() int {
+	if d.MaxAttempts <= 0 {
+		return command.DefaultMaxAttempts
+	}
+	return d.MaxAttempts
+}
+
+// Instance is what steps see of a running saga.
+type Instance struct {
+	ID         string
+	Definition string
+	Step       int
+	// Data is the saga's JSON data, recorded again after each step.
+	Data json.RawMessage
+}
+
+func (i *Instance) Decode(v interface{}) error {
+	return json.Unmarshal(i.Data, v)
+}
+
+func (i *Instance) Encode(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	i.Data = data
+	return nil
+}
+
+// Manager runs sagas. It advances them when they start and when events
+// they await arrive, and Tick retries failed steps and enforces timeouts.
+// Feed it events with Handle or CatchUp, as for a projection. Several
+// managers may share a store: saga aggregates are versioned, so a step's
+// progress is recorded once.
+type Manager struct {
+	// Clock returns the current time; tests set it to simulated time.
+	Clock       func() time.Time
+	repo        *aggregate.Repository[*Saga]
+	definitions map[string]*Definition
+	mu          sync.Mutex
+	active      map[string]bool
+	cursor      string
+}
+
+func NewManager(store aggregate.Store) *Manager {
+	return &Manager{
+		Clock:       time.Now,
+		repo:        aggregate.NewRepository(store, SagaType, NewSaga),
+		definitions: make(map[string]*Definition),
+		active:      make(map[string]bool),
+	}
+}
+
+// Define adds a saga definition. It panics on invalid or duplicate
+// definitions, which are programming errors.
+func (m *Manager) Define(def Definition) {
+	if def.Name == "" || len(def.Steps) == 0 {
+		panic("saga: a definition needs a name and steps")
+	}
+	if _, ok := m.definitions[def.Name]; ok {
+		panic(fmt.Sprintf("saga: %s is already defined", def.Name))
+	}
+	names := make(map[string]bool)
+	for _, step := range def.Steps {
+		if step.Name == "" || names[step.Name] || step.Action == nil {
+			panic(fmt.Sprintf("saga: %s needs uniquely named steps with actions", def.Name))
+		}
+		names[step.Name] = true
+	}
+	m.definitions[def.Name] = &def
+}
+
+// Start records a new saga of the named definition and runs it as far as
+// it can go. Failures after the saga is recorded are left to Tick.
+func (m *Manager) Start(ctx context.Context, definition, id string, data interface{}) error {
+	if _, ok := m.definitions[definition]; !ok {
+		return fmt.Errorf("unknown saga definition %s", definition)
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode saga data: %w", err)
+	}
+	root, err := m.repo.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if root.Version > 0 {
+		return fmt.Errorf("%w: %s", ErrStarted, id)
+	}
+	if err := root.Raise(EventSagaStarted, SagaStarted{Definition: definition, Data: encoded}); err != nil {
+		return err
+	}
+	if err := m.repo.Save(ctx, root); err != nil {
+		return err
+	}
+	m.track(id, true)
+	if err := m.advance(ctx, id); err != nil {
+		log.Printf("Saga %s paused: %v", id, err)
+	}
+	return nil
+}
+
+// Saga loads the current state of a saga.
+func (m *Manager) Saga(ctx context.Context, id string) (*aggregate.Root[*Saga], error) {
+	return m.repo.Load(ctx, id)
+}
+
+// Handle completes the step awaiting event, if any, and continues its saga.
+// Saga events themselves update the set of sagas Tick looks after, so
+// catching up from the start resumes the sagas of a stopped process.
+func (m *Manager) Handle(ctx context.Context, event model.Event) error {
+	if event.AggregateType == SagaType {
+		switch event.Type {
+		case EventSagaStarted:
+			m.track(event.AggregateID, true)
+		case EventSagaCompleted, EventSagaRolledBack:
+			m.track(event.AggregateID, false)
+		}
+		return nil
+	}
+	id := event.Metadata[MetadataSagaID]
+	if id == "" {
+		return nil
+	}
+	root, err := m.repo.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	s := root.State
+	if s.Status != model.StateActive || !s.Dispatched {
+		return nil
+	}
+	def, err := m.definition(s)
+	if err != nil {
+		return err
+	}
+	step := def.Steps[s.Step]
+	if step.Await == nil || event.Metadata[MetadataSagaStep] != step.Name {
+		return nil
+	}
+
+	inst := m.instance(root, s.Step)
+	done, awaitErr := step.Await(inst, event)
+	switch {
+	case awaitErr != nil:
+		err = root.Raise(EventStepFailed, StepFailed{Step: s.Step, Error: awaitErr.Error(), Dispatched: true})
+		if err == nil {
+			err = root.Raise(EventCompensationStarted, CompensationStarted{Reason: fmt.Sprintf("step %s failed: %v", step.Name, awaitErr)})
+		}
+	case done:
+		err = root.Raise(EventStepCompleted, StepCompleted{Step: s.Step, Data: inst.Data})
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.repo.Save(ctx, root); err != nil {
+		return err
+	}
+	if err := m.advance(ctx, id); err != nil {
+		log.Printf("Saga %s paused: %v", id, err)
+	}
+	return nil
+}
+
+// CatchUp handles the events stored since the last CatchUp.
+func (m *Manager) CatchUp(ctx context.Context, source eventstore.Finder) error {
+	m.mu.Lock()
+	cursor := m.cursor
+	m.mu.Unlock()
+
+	cursor, err := eventstore.Follow(ctx, source, eventstore.EventQuery{Cursor: cursor}, func(event model.Event) error {
+		return m.Handle(ctx, event)
+	})
+	m.mu.Lock()
+	m.cursor = cursor
+	m.mu.Unlock()
+	return err
+}
+
+// Tick advances every unfinished saga: failed steps are retried, timed out
+// ones fail and rollbacks continue. It returns the errors of sagas that
+// are still stuck.
+func (m *Manager) Tick(ctx context.Context) error {
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.active))
+	for id := range m.active {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+	sort.Strings(ids)
+
+	var failed []error
+	for _, id := range ids {
+		if err := m.advance(ctx, id); err != nil {
+			failed = append(failed, fmt.Errorf("saga %s: %w", id, err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// Run catches up with source and ticks every interval until ctx is done.
+func (m *Manager) Run(ctx context.Context, source eventstore.Finder, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.CatchUp(ctx, source); err != nil {
+			log.Printf("Saga catch-up failed: %v", err)
+		}
+		if err := m.Tick(ctx); err != nil {
+			log.Printf("Saga tick failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// advance runs the saga until it waits for an event, fails with a transient
+// error or finishes, recording each step before taking the next.
+func (m *Manager) advance(ctx context.Context, id string) error {
+	for {
+		root, err := m.repo.Load(ctx, id)
+		if err != nil {
+			return err
+		}
+		s := root.State
+		if s.Status != model.StateActive {
+			m.track(id, false)
+			return nil
+		}
+		def, err := m.definition(s)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case s.Compensating:
+			k := s.NextCompensation()
+			if k < 0 {
+				err = root.Raise(EventSagaRolledBack, SagaRolledBack{Reason: s.Reason})
+				break
+			}
+			step := def.Steps[k]
+			if step.Compensate != nil {
+				if err := step.Compensate(m.stepContext(ctx, id, step), m.instance(root, k)); err != nil {
+					return fmt.Errorf("failed to compensate step %s: %w", step.Name, err)
+				}
+			}
+			err = root.Raise(EventStepCompensated, StepCompensated{Step: k})
+		case s.Dispatched:
+			if s.Deadline == 0 || m.Clock().UnixMilli() < s.Deadline {
+				return nil
+			}
+			name := def.Steps[s.Step].Name
+			err = root.Raise(EventStepFailed, StepFailed{Step: s.Step, Error: "timed out", Dispatched: true})
+			if err == nil {
+				err = root.Raise(EventCompensationStarted, CompensationStarted{Reason: fmt.Sprintf("step %s timed out", name)})
+			}
+		case s.Step >= len(def.Steps):
+			err = root.Raise(EventSagaCompleted, SagaCompleted{})
+		default:
+			err = m.runStep(ctx, root, def)
+		}
+		if err != nil {
+			return err
+		}
+		if err := m.repo.Save(ctx, root); err != nil {
+			return err
+		}
+	}
+}
+
+// runStep runs the action of the current step and raises its result on
+// root. Steps with an Await are recorded as dispatched beforehand, so an
+// event they cause is never missed.
+func (m *Manager) runStep(ctx context.Context, root *aggregate.Root[*Saga], def *Definition) error {
+	s := root.State
+	step := def.Steps[s.Step]
+	if step.Await != nil {
+		var deadline int64
+		if step.Timeout > 0 {
+			deadline = m.Clock().Add(step.Timeout).UnixMilli()
+		}
+		if err := root.Raise(EventStepDispatched, StepDispatched{Step: s.Step, Deadline: deadline}); err != nil {
+			return err
+		}
+		if err := m.repo.Save(ctx, root); err != nil {
+			return err
+		}
+	}
+
+	inst := m.instance(root, s.Step)
+	err := step.Action(m.stepContext(ctx, root.ID, step), inst)
+	if err == nil {
+		if step.Await != nil {
+			return nil
+		}
+		return root.Raise(EventStepCompleted, StepCompleted{Step: s.Step, Data: inst.Data})
+	}
+
+	var invalid *command.ValidationError
+	permanent := errors.Is(err, command.ErrRejected) || errors.As(err, &invalid) || s.Attempts+1 >= def.maxAttempts()
+	if raiseErr := root.Raise(EventStepFailed, StepFailed{Step: s.Step, Error: err.Error()}); raiseErr != nil {
+		return raiseErr
+	}
+	if !permanent {
+		if saveErr := m.repo.Save(ctx, root); saveErr != nil {
+			return saveErr
+		}
+		return fmt.Errorf("step %s failed: %w", step.Name, err)
+	}
+	return root.Raise(EventCompensationStarted, CompensationStarted{Reason: fmt.Sprintf("step %s failed: %v", step.Name, err)})
+}
+
+func (m *Manager) definition(s *Saga) (*Definition, error) {
+	def, ok := m.definitions[s.Definition]
+	if !ok {
+		return nil, fmt.Errorf("unknown saga definition %s", s.Definition)
+	}
+	return def, nil
+}
+
+func (m *Manager) instance(root *aggregate.Root[*Saga], step int) *Instance {
+	return &Instance{ID: root.ID, Definition: root.State.Definition, Step: step, Data: root.State.Data}
+}
+
+// stepContext tags the events of commands dispatched by step with the saga
+// and step, for Handle to route them back.
+func (m *Manager) stepContext(ctx context.Context, id string, step Step) context.Context {
+	return aggregate.WithMetadata(ctx, map[string]string{MetadataSagaID: id, MetadataSagaStep: step.Name})
+}
+
+func (m *Manager) track(id string, active bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if active {
+		m.active[id] = true
+	} else {
+		delete(m.active, id)
+	}
+}
@@ -0,0 +1,213 @@
+package saga
+
+import (
+	"defi/internal/aggregate"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Aggregate and event types of sagas. Each running saga is one aggregate
+// recording its progress, so another process can pick it up where it
+// stopped.
+const (
+	SagaType                 = "saga"
+	EventSagaStarted         = "SagaStarted"
+	EventStepDispatched      = "StepDispatched"
+	EventStepCompleted       = "StepCompleted"
+	EventStepFailed          = "StepFailed"
+	EventCompensationStarted = "CompensationStarted"
+	EventStepCompensated     = "StepCompensated"
+	EventSagaCompleted       = "SagaCompleted"
+	EventSagaRolledBack      = "SagaRolledBack"
+)
+
+// Outcomes of a finished saga.
+const (
+	OutcomeCompleted  = "completed"
+	OutcomeRolledBack = "rolled_back"
+)
+
+// Metadata keys added to the events of commands dispatched by a saga step.
+const (
+	MetadataSagaID   = model.MetadataSagaID
+	MetadataSagaStep = model.MetadataSagaStep
+)
+
+type SagaStarted struct {
+	Definition string          `json:"definition"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// StepDispatched is recorded before the command of a step with an Await is
+// dispatched. Deadline is in Unix milliseconds; zero waits forever.
+type StepDispatched struct {
+	Step     int   `json:"step"`
+	Deadline int64 `json:"deadline,omitempty"`
+}
+
+// StepCompleted carries the saga data as left by the step.
+type StepCompleted struct {
+	Step int             `json:"step"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// StepFailed records a failed attempt. Dispatched reports whether the
+// step's command may still have taken effect, as when its event did not
+// arrive in time; such a step is compensated along with those before it.
+type StepFailed struct {
+	Step       int    `json:"step"`
+	Error      string `json:"error"`
+	Dispatched bool   `json:"dispatched,omitempty"`
+}
+
+type CompensationStarted struct {
+	Reason string `json:"reason"`
+}
+
+type StepCompensated struct {
+	Step int `json:"step"`
+}
+
+type SagaCompleted struct{}
+
+type SagaRolledBack struct {
+	Reason string `json:"reason"`
+}
+
+// Saga is the state of a saga aggregate. Steps before Step have completed;
+// while Compensating they are undone from the last one down.
+type Saga struct {
+	Status     model.State
+	Definition string
+	Data       json.RawMessage
+	Step       int
+	// Dispatched is set while the command of Step may have been dispatched
+	// and the saga waits for its event until Deadline.
+	Dispatched   bool
+	Deadline     int64
+	Attempts     int
+	Compensating bool
+	Reason       string
+	Compensated  int
+	Outcome      string
+}
+
+var sagaLifecycle = aggregate.NewMachine[*Saga](model.StateInitial).
+	Allow(model.StateInitial, EventSagaStarted, model.StateActive).
+	Allow(model.StateActive, EventStepDispatched, model.StateActive, running).
+	Allow(model.StateActive, EventStepCompleted, model.StateActive, running, func(s *Saga, event model.Event) error {
+		var e StepCompleted
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return err
+		}
+		if e.Step != s.Step {
+			return fmt.Errorf("step %d completed while at step %d", e.Step, s.Step)
+		}
+		return nil
+	}).
+	Allow(model.StateActive, EventStepFailed, model.StateActive, running).
+	Allow(model.StateActive, EventCompensationStarted, model.StateActive, running).
+	Allow(model.StateActive, EventSagaCompleted, model.StateClosed, running).
+	Allow(model.StateActive, EventStepCompensated, model.StateActive, compensating, func(s *Saga, event model.Event) error {
+		var e StepCompensated
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return err
+		}
+		if e.Step != s.NextCompensation() {
+			return fmt.Errorf("step %d compensated out of order, expected %d", e.Step, s.NextCompensation())
+		}
+		return nil
+	}).
+	Allow(model.StateActive, EventSagaRolledBack, model.StateClosed, compensating, func(s *Saga, _ model.Event) error {
+		if s.NextCompensation() >= 0 {
+			return fmt.Errorf("step %d is not compensated yet", s.NextCompensation())
+		}
+		return nil
+	})
+
+func running(s *Saga, _ model.Event) error {
+	if s.Compensating {
+		return errors.New("saga is compensating")
+	}
+	return nil
+}
+
+func compensating(s *Saga, _ model.Event) error {
+	if !s.Compensating {
+		return errors.New("saga is not compensating")
+	}
+	return nil
+}
+
+func NewSaga() *Saga {
+	return &Saga{}
+}
+
+// Lifecycle returns the state machine of sagas, e.g. for export.
+func Lifecycle() *aggregate.Machine[*Saga] {
+	return sagaLifecycle
+}
+
+// NextCompensation returns the index of the next step to undo, or -1 once
+// every completed step is compensated.
+func (s *Saga) NextCompensation() int {
+	return s.Step - 1 - s.Compensated
+}
+
+func (s *Saga) Apply(event model.Event) error {
+	next, err := sagaLifecycle.Next(s, s.Status, event)
+	if err != nil {
+		return err
+	}
+	switch event.Type {
+	case EventSagaStarted:
+		var e SagaStarted
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return fmt.Errorf("invalid %s: %w", event.Type, err)
+		}
+		s.Definition, s.Data = e.Definition, e.Data
+	case EventStepDispatched:
+		var e StepDispatched
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return fmt.Errorf("invalid %s: %w", event.Type, err)
+		}
+		s.Dispatched, s.Deadline = true, e.Deadline
+	case EventStepCompleted:
+		var e StepCompleted
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return fmt.Errorf("invalid %s: %w", event.Type, err)
+		}
+		if len(e.Data) > 0 {
+			s.Data = e.Data
+		}
+		s.Step++
+		s.Dispatched, s.Deadline, s.Attempts = false, 0, 0
+	case EventStepFailed:
+		var e StepFailed
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return fmt.Errorf("invalid %s: %w", event.Type, err)
+		}
+		s.Attempts++
+		s.Dispatched = e.Dispatched
+	case EventCompensationStarted:
+		var e CompensationStarted
+		if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+			return fmt.Errorf("invalid %s: %w", event.Type, err)
+		}
+		s.Compensating, s.Reason = true, e.Reason
+		if s.Dispatched {
+			s.Step++
+			s.Dispatched = false
+		}
+	case EventStepCompensated:
+		s.Compensated++
+	case EventSagaCompleted:
+		s.Outcome = OutcomeCompleted
+	case EventSagaRolledBack:
+		s.Outcome = OutcomeRolledBack
+	}
+	s.Status = next
+	return nil
+}
@@ -0,0 +1,227 @@
+package saga
+
+import (
+	"context"
+	"defi/internal/amm"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/ledger"
+	"defi/internal/model"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type swapData struct {
+	Wallet    string        `json:"wallet"`
+	AmountIn  model.Amount  `json:"amountIn"`
+	MinOut    model.Amount  `json:"minOut"`
+	AmountOut *model.Amount `json:"amountOut,omitempty"`
+}
+
+// swapSaga debits ETH from a wallet into escrow, swaps it in the pool and
+// credits the USDC received back to the wallet.
+func swapSaga(bus *command.Bus) Definition {
+	transfer := func(entryID, from, to, asset string, amount model.Amount) ledger.PostEntry {
+		return ledger.PostEntry{EntryID: entryID, Legs: []ledger.Leg{
+			{AccountID: from, Asset: asset, Side: ledger.Credit, Amount: amount},
+			{AccountID: to, Asset: asset, Side: ledger.Debit, Amount: amount},
+		}}
+	}
+	return Definition{Name: "swap", Steps: []Step{
+		{
+			Name: "debit",
+			Action: func(ctx context.Context, inst *Instance) error {
+				var d swapData
+				if err := inst.Decode(&d); err != nil {
+					return err
+				}
+				return bus.Dispatch(ctx, transfer(inst.ID+"-debit", d.Wallet, "escrow", "ETH", d.AmountIn))
+			},
+			Compensate: func(ctx context.Context, inst *Instance) error {
+				var d swapData
+				if err := inst.Decode(&d); err != nil {
+					return err
+				}
+				return bus.Dispatch(ctx, transfer(inst.ID+"-refund", "escrow", d.Wallet, "ETH", d.AmountIn))
+			},
+		},
+		{
+			Name: "swap",
+			Action: func(ctx context.Context, inst *Instance) error {
+				var d swapData
+				if err := inst.Decode(&d); err != nil {
+					return err
+				}
+				return bus.Dispatch(ctx, amm.Swap{PoolID: "eth-usdc", Trader: d.Wallet, TokenIn: "ETH", AmountIn: d.AmountIn, MinAmountOut: d.MinOut})
+			},
+			Await: func(inst *Instance, event model.Event) (bool, error) {
+				if event.Type != amm.EventSwapped {
+					return false, nil
+				}
+				var swapped amm.Swapped
+				var d swapData
+				if err := json.Unmarshal([]byte(event.Data), &swapped); err != nil {
+					return false, err
+				}
+				if err := inst.Decode(&d); err != nil {
+					return false, err
+				}
+				d.AmountOut = &swapped.AmountOut
+				return true, inst.Encode(d)
+			},
+			Timeout: time.Minute,
+		},
+		{
+			Name: "credit",
+			Action: func(ctx context.Context, inst *Instance) error {
+				var d swapData
+				if err := inst.Decode(&d); err != nil {
+					return err
+				}
+				return bus.Dispatch(ctx, transfer(inst.ID+"-credit", "escrow", d.Wallet, "USDC", *d.AmountOut))
+			},
+		},
+	}}
+}
+
+func TestSwapSaga(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	bus := command.NewBus()
+	l := ledger.New(store)
+	l.Register(bus)
+	amm.Register(bus, store)
+	eth := func(s string) model.Amount { return model.MustParseAmount(s, 18) }
+	usdc := func(s string) model.Amount { return model.MustParseAmount(s, 6) }
+	model.RegisterAsset("ETH", 18)
+	model.RegisterAsset("USDC", 6)
+
+	for _, cmd := range []interface{}{
+		ledger.OpenAccount{AccountID: "treasury", Kind: ledger.KindEquity},
+		ledger.OpenAccount{AccountID: "alice", Kind: ledger.KindAsset},
+		ledger.OpenAccount{AccountID: "escrow", Kind: ledger.KindAsset, AllowNegative: true},
+		ledger.PostEntry{EntryID: "fund", Legs: []ledger.Leg{
+			{AccountID: "alice", Asset: "ETH", Side: ledger.Debit, Amount: eth("10")},
+			{AccountID: "treasury", Asset: "ETH", Side: ledger.Credit, Amount: eth("10")},
+		}},
+		amm.CreatePool{PoolID: "eth-usdc", Token0: "ETH", Token1: "USDC", Decimals0: 18, Decimals1: 6, FeeBps: 30},
+		amm.AddLiquidity{PoolID: "eth-usdc", Provider: "lp", Amount0: eth("100"), Amount1: usdc("200000")},
+	} {
+		if err := bus.Dispatch(ctx, cmd); err != nil {
+			t.Fatalf("%T: %v", cmd, err)
+		}
+	}
+
+	m := NewManager(store)
+	m.Define(swapSaga(bus))
+	balance := func(asset string) model.Amount {
+		t.Helper()
+		root, err := l.Account(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root.State.Balances[asset]
+	}
+	outcome := func(id string) string {
+		t.Helper()
+		root, err := m.Saga(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root.State.Outcome
+	}
+
+	// The saga waits for the Swapped event before crediting the wallet.
+	if err := m.Start(ctx, "swap", "s1", swapData{Wallet: "alice", AmountIn: eth("1"), MinOut: usdc("1900")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := outcome("s1"); got != "" {
+		t.Fatalf("s1 finished before its swap event: %s", got)
+	}
+	if err := m.CatchUp(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if got := outcome("s1"); got != OutcomeCompleted {
+		t.Fatalf("s1: got outcome %q", got)
+	}
+	if got := balance("ETH"); got.Cmp(eth("9")) != 0 {
+		t.Errorf("ETH after s1: got %s", got)
+	}
+	received := balance("USDC")
+	if received.Sign() <= 0 {
+		t.Errorf("USDC after s1: got %s", received)
+	}
+
+	// A swap rejected for slippage refunds the debit.
+	if err := m.Start(ctx, "swap", "s2", swapData{Wallet: "alice", AmountIn: eth("1"), MinOut: usdc("5000")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := outcome("s2"); got != OutcomeRolledBack {
+		t.Fatalf("s2: got outcome %q", got)
+	}
+	if got := balance("ETH"); got.Cmp(eth("9")) != 0 {
+		t.Errorf("ETH after s2: got %s", got)
+	}
+	if got := balance("USDC"); got.Cmp(received) != 0 {
+		t.Errorf("USDC after s2: got %s", got)
+	}
+	if err := m.Start(ctx, "swap", "s2", swapData{}); err == nil {
+		t.Error("expected restarting s2 to fail")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	now := time.UnixMilli(1_700_000_000_000)
+	m := NewManager(store)
+	m.Clock = func() time.Time { return now }
+
+	var attempts, compensated int
+	m.Define(Definition{Name: "wait", MaxAttempts: 2, Steps: []Step{{
+		Name: "wait",
+		Action: func(ctx context.Context, inst *Instance) error {
+			attempts++
+			if attempts == 1 {
+				return context.DeadlineExceeded
+			}
+			return nil
+		},
+		Await:      func(*Instance, model.Event) (bool, error) { return false, nil },
+		Timeout:    time.Minute,
+		Compensate: func(context.Context, *Instance) error { compensated++; return nil },
+	}}})
+
+	if err := m.Start(ctx, "wait", "w1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Tick(ctx); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("got %d attempts, want 2", attempts)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := m.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := m.Saga(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.State.Outcome != OutcomeRolledBack || compensated != 1 {
+		t.Errorf("got outcome %q with %d compensations", root.State.Outcome, compensated)
+	}
+
+	// A fresh manager resumes nothing once the saga is finished.
+	resumed := NewManager(store)
+	resumed.Define(Definition{Name: "wait", Steps: []Step{{Name: "wait", Action: func(context.Context, *Instance) error { return nil }}}})
+	if err := resumed.CatchUp(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if len(resumed.active) != 0 {
+		t.Errorf("finished sagas resumed: %v", resumed.active)
+	}
+}
@@ -0,0 +1,245 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"defi/internal/aggregate"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Run fires due jobs until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := s.RunDue(ctx)
+			if err != nil {
+				log.Printf("Scheduler run failed: %v", err)
+			}
+			if err != nil || n == 0 {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue fires up to BatchSize due jobs and records their outcomes. Each
+// job is claimed just before it fires, so its lease only has to cover that
+// one job. It returns how many jobs it claimed.
+func (s *Scheduler) RunDue(ctx context.Context) (int, error) {
+	batch := s.BatchSize
+	if batch <= 0 {
+		batch = DefaultBatchSize
+	}
+	n := 0
+	for ; n < batch; n++ {
+		job, leaseUntil, ok, err := s.claim(ctx)
+		if err != nil || !ok {
+			return n, err
+		}
+		fireCtx, cancel := context.WithDeadline(ctx, leaseUntil)
+		fireErr := s.fire(fireCtx, job)
+		cancel()
+		if err := s.finish(ctx, job, fireErr); err != nil {
+			log.Printf("Failed to record the outcome of job %s: %v", job.ID, err)
+		}
+	}
+	return n, nil
+}
+
+// claim leases the next due job to this replica and reports whether there
+// was one. SKIP LOCKED lets replicas claim concurrently without waiting on
+// each other's rows.
+func (s *Scheduler) claim(ctx context.Context) (Job, time.Time, bool, error) {
+	lease := s.Lease
+	if lease <= 0 {
+		lease = DefaultLease
+	}
+	now := s.Clock()
+	leaseUntil := now.Add(lease)
+
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return Job{}, time.Time{}, false, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, kind, name, payload, due_at, interval_ms, occurrence, attempts FROM scheduled_jobs
+WHERE status = ? AND due_at <= ? AND (lease_until IS NULL OR lease_until < ?)
+ORDER BY due_at LIMIT 1 FOR UPDATE SKIP LOCKED`
+	var (
+		job           Job
+		payload       string
+		due, interval int64
+	)
+	err = tx.QueryRowContext(ctx, s.rebind(query), StatusPending, now.UnixMilli(), now.UnixMilli()).
+		Scan(&job.ID, &job.Kind, &job.Name, &payload, &due, &interval, &job.Occurrence, &job.Attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, time.Time{}, false, nil
+	}
+	if err != nil {
+		return Job{}, time.Time{}, false, fmt.Errorf("failed to read due jobs: %w", err)
+	}
+	job.Payload = json.RawMessage(payload)
+	job.DueAt = time.UnixMilli(due)
+	job.Interval = time.Duration(interval) * time.Millisecond
+	job.Status = StatusPending
+	job.Attempts++
+
+	update := s.rebind(`UPDATE scheduled_jobs SET lease_owner = ?, lease_until = ?, attempts = ?, updated_at = ? WHERE id = ?`)
+	if _, err := tx.ExecContext(ctx, update, s.Owner, leaseUntil.UnixMilli(), job.Attempts, now.UnixMilli(), job.ID); err != nil {
+		return Job{}, time.Time{}, false, fmt.Errorf("failed to lease job %s: %w", job.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Job{}, time.Time{}, false, fmt.Errorf("failed to commit lease: %w", err)
+	}
+	return job, leaseUntil, true, nil
+}
+
+// fire runs one occurrence of job.
+func (s *Scheduler) fire(ctx context.Context, job Job) error {
+	key := occurrenceKey(job)
+	switch job.Kind {
+	case KindCommand:
+		decode, ok := s.commands[job.Name]
+		if !ok {
+			return fmt.Errorf("command %s is not registered with the scheduler", job.Name)
+		}
+		cmd, err := decode(job.Payload)
+		if err != nil {
+			return err
+		}
+		if s.Commands == nil {
+			return errors.New("scheduler has no command bus")
+		}
+		if job.Attempts > 1 && s.Fence != nil {
+			page, err := s.Fence.FindEvents(eventstore.EventQuery{Metadata: map[string]string{MetadataOccurrence: key}, Limit: 1})
+			if err != nil {
+				return fmt.Errorf("failed to check earlier attempts: %w", err)
+			}
+			if len(page.Events) > 0 {
+				log.Printf("Job %s occurrence %d already took effect in event %s", job.ID, job.Occurrence, page.Events[0].ID)
+				return nil
+			}
+		}
+		ctx = aggregate.WithMetadata(ctx, map[string]string{MetadataJobID: job.ID, MetadataOccurrence: key})
+		return s.Commands.Dispatch(ctx, cmd)
+	case KindEvent:
+		var e scheduledEvent
+		if err := json.Unmarshal(job.Payload, &e); err != nil {
+			return fmt.Errorf("invalid event job: %w", err)
+		}
+		if s.Events == nil {
+			return errors.New("scheduler has no event publisher")
+		}
+		// The ID is derived from the occurrence so consumers can drop the
+		// duplicate published when an attempt is repeated.
+		event := model.Event{
+			ID:          eventID(key),
+			AggregateID: job.ID,
+			Type:        e.Type,
+			Data:        string(e.Data),
+			Metadata:    map[string]string{MetadataJobID: job.ID, MetadataOccurrence: key},
+			Timestamp:   s.Clock().UnixMilli(),
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return s.Events.PublishEvent(job.Name, payload)
+	}
+	return fmt.Errorf("unknown job kind %s", job.Kind)
+}
+
+// finish records the outcome of an occurrence and releases the lease. A
+// job whose lease was taken over meanwhile is left to its new owner.
+func (s *Scheduler) finish(ctx context.Context, job Job, fireErr error) error {
+	now := s.Clock()
+	maxAttempts := s.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	var invalid *command.ValidationError
+	permanent := errors.Is(fireErr, command.ErrRejected) || errors.As(fireErr, &invalid) || job.Attempts >= maxAttempts
+
+	status, due, occurrence, attempts := job.Status, job.DueAt, job.Occurrence, job.Attempts
+	var lastError interface{}
+	switch {
+	case fireErr != nil && !permanent:
+		retry := s.RetryDelay
+		if retry <= 0 {
+			retry = DefaultRetryDelay
+		}
+		due = now.Add(retry * time.Duration(job.Attempts))
+		lastError = fireErr.Error()
+		log.Printf("Job %s failed, retrying at %s: %v", job.ID, due.Format(time.RFC3339), fireErr)
+	default:
+		if fireErr != nil {
+			lastError = fireErr.Error()
+			log.Printf("Job %s occurrence %d failed for good: %v", job.ID, job.Occurrence, fireErr)
+		}
+		occurrence++
+		attempts = 0
+		switch {
+		case job.Interval > 0:
+			due = nextDue(job.DueAt, job.Interval, now)
+		case fireErr != nil:
+			status = StatusFailed
+		default:
+			status = StatusDone
+		}
+	}
+
+	query := `UPDATE scheduled_jobs SET status = CASE WHEN status = ? THEN ? ELSE status END, due_at = ?, occurrence = ?, attempts = ?, last_error = ?,
+lease_owner = NULL, lease_until = NULL, updated_at = ? WHERE id = ? AND lease_owner = ? AND occurrence = ?`
+	res, err := s.Db.ExecContext(ctx, s.rebind(query), StatusPending, status, due.UnixMilli(), occurrence, attempts, lastError, now.UnixMilli(), job.ID, s.Owner, job.Occurrence)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		log.Printf("Lost the lease on job %s before recording occurrence %d", job.ID, job.Occurrence)
+	}
+	return nil
+}
+
+// nextDue returns the first time after now on the schedule of a job due at
+// due every interval. Occurrences missed while no replica ran are skipped.
+func nextDue(due time.Time, interval time.Duration, now time.Time) time.Time {
+	next := due.Add(interval)
+	if next.After(now) {
+		return next
+	}
+	missed := now.Sub(due) / interval
+	return due.Add((missed + 1) * interval)
+}
+
+func occurrenceKey(job Job) string {
+	return fmt.Sprintf("%s#%d", job.ID, job.Occurrence)
+}
+
+// eventID derives a UUID-formatted event ID from key.
+func eventID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	b := sum[:16]
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,246 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Job kinds.
+const (
+	KindCommand = "command"
+	KindEvent   = "event"
+)
+
+// Job statuses. Only pending jobs fire; recurring jobs stay pending.
+const (
+	StatusPending   = "pending"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Metadata keys added to the events caused by a job, so a repeated attempt
+// can tell whether an occurrence already took effect.
+const (
+	MetadataJobID      = model.MetadataScheduleID
+	MetadataOccurrence = model.MetadataScheduleOccurrence
+)
+
+// Defaults for zero Scheduler fields.
+const (
+	DefaultLease       = time.Minute
+	DefaultInterval    = time.Second
+	DefaultRetryDelay  = 10 * time.Second
+	DefaultBatchSize   = 100
+	DefaultMaxAttempts = 5
+)
+
+var (
+	// ErrNotFound is returned for unknown job IDs.
+	ErrNotFound = errors.New("job not found")
+	// ErrExists is returned when scheduling under an ID already in use.
+	ErrExists = errors.New("job already exists")
+	// ErrNotPending is returned by Cancel for jobs that already finished.
+	ErrNotPending = errors.New("job is not pending")
+)
+
+// Job is a command or event scheduled to fire at DueAt, and every Interval
+// after that if Interval is set.
+type Job struct {
+	ID   string
+	Kind string
+	// Name is the registered command name, or the topic of an event.
+	Name     string
+	Payload  json.RawMessage
+	DueAt    time.Time
+	Interval time.Duration
+	Status   string
+	// Occurrence counts the times the job has fired.
+	Occurrence int64
+	Attempts   int
+	LastError  string
+}
+
+// scheduledEvent is the payload of event jobs.
+type scheduledEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Scheduler stores jobs in the scheduled_jobs table and fires them when
+// due. Replicas sharing the table claim due jobs under a lease, so each
+// occurrence is fired by one replica at a time; a replica that stops
+// mid-fire leaves the occurrence to be retried once its lease expires.
+type Scheduler struct {
+	Db *sql.DB
+	// Owner identifies this replica in leases. It defaults to the host name
+	// and a random suffix.
+	Owner string
+	// Commands dispatches command jobs.
+	Commands *command.Bus
+	// Events publishes event jobs, e.g. an eventbus.EventBus.
+	Events eventstore.Publisher
+	// Fence, when set, is searched for events of an occurrence before its
+	// command is dispatched again after an unfinished attempt, so a command
+	// that took effect is not repeated. It should read the primary.
+	Fence       eventstore.Finder
+	Lease       time.Duration
+	Interval    time.Duration
+	RetryDelay  time.Duration
+	BatchSize   int
+	MaxAttempts int
+	// Clock returns the current time; tests set it to simulated time.
+	Clock    func() time.Time
+	driver   string
+	commands map[string]func(json.RawMessage) (interface{}, error)
+	names    map[reflect.Type]string
+}
+
+// New returns a scheduler over db for the named database/sql driver,
+// "mysql" or "postgres".
+func New(db *sql.DB, driver string) (*Scheduler, error) {
+	if driver != "mysql" && driver != "postgres" {
+		return nil, fmt.Errorf("unsupported scheduler driver: %s", driver)
+	}
+	host, _ := os.Hostname()
+	return &Scheduler{
+		Db:       db,
+		Owner:    host + "-" + model.NewID()[:8],
+		Clock:    time.Now,
+		driver:   driver,
+		commands: make(map[string]func(json.RawMessage) (interface{}, error)),
+		names:    make(map[reflect.Type]string),
+	}, nil
+}
+
+// RegisterCommand lets commands of type C be scheduled. The name is stored
+// with each job, so it must stay stable across releases.
+func RegisterCommand[C any](s *Scheduler, name string) {
+	t := reflect.TypeOf(*new(C))
+	if _, ok := s.commands[name]; ok {
+		panic(fmt.Sprintf("scheduler: command %s is already registered", name))
+	}
+	s.names[t] = name
+	s.commands[name] = func(payload json.RawMessage) (interface{}, error) {
+		var cmd C
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		return cmd, nil
+	}
+}
+
+// ScheduleCommand schedules cmd, whose type must be registered, to be
+// dispatched at at and, if every is positive, periodically after that.
+func (s *Scheduler) ScheduleCommand(ctx context.Context, id string, at time.Time, every time.Duration, cmd interface{}) error {
+	name, ok := s.names[reflect.TypeOf(cmd)]
+	if !ok {
+		return fmt.Errorf("command %T is not registered with the scheduler", cmd)
+	}
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return s.insert(ctx, Job{ID: id, Kind: KindCommand, Name: name, Payload: payload, DueAt: at, Interval: every})
+}
+
+// ScheduleEvent schedules an event of eventType with data to be published
+// on topic at at and, if every is positive, periodically after that.
+func (s *Scheduler) ScheduleEvent(ctx context.Context, id string, at time.Time, every time.Duration, topic, eventType string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", eventType, err)
+	}
+	payload, err := json.Marshal(scheduledEvent{Type: eventType, Data: encoded})
+	if err != nil {
+		return err
+	}
+	return s.insert(ctx, Job{ID: id, Kind: KindEvent, Name: topic, Payload: payload, DueAt: at, Interval: every})
+}
+
+func (s *Scheduler) insert(ctx context.Context, job Job) error {
+	if job.ID == "" {
+		return errors.New("job ID is required")
+	}
+	if job.Interval < 0 {
+		return errors.New("job interval must not be negative")
+	}
+	tx, err := s.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin scheduling: %w", err)
+	}
+	defer tx.Rollback()
+
+	var existing string
+	err = tx.QueryRowContext(ctx, s.rebind(`SELECT status FROM scheduled_jobs WHERE id = ?`), job.ID).Scan(&existing)
+	if err == nil {
+		return fmt.Errorf("%w: %s is %s", ErrExists, job.ID, existing)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to look up job %s: %w", job.ID, err)
+	}
+	now := s.Clock().UnixMilli()
+	query := `INSERT INTO scheduled_jobs (id, kind, name, payload, due_at, interval_ms, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, s.rebind(query), job.ID, job.Kind, job.Name, string(job.Payload), job.DueAt.UnixMilli(), job.Interval.Milliseconds(), StatusPending, now, now); err != nil {
+		if eventstore.IsUniqueViolation(err) {
+			return fmt.Errorf("%w: %s was scheduled concurrently", ErrExists, job.ID)
+		}
+		return fmt.Errorf("failed to schedule job %s: %w", job.ID, err)
+	}
+	return tx.Commit()
+}
+
+// Cancel stops a pending job from firing again. An occurrence that is
+// firing at that moment still completes.
+func (s *Scheduler) Cancel(ctx context.Context, id string) error {
+	query := `UPDATE scheduled_jobs SET status = ?, updated_at = ? WHERE id = ? AND status = ?`
+	res, err := s.Db.ExecContext(ctx, s.rebind(query), StatusCancelled, s.Clock().UnixMilli(), id, StatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to cancel job %s: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	job, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s is %s", ErrNotPending, id, job.Status)
+}
+
+// Get returns the job with id.
+func (s *Scheduler) Get(ctx context.Context, id string) (Job, error) {
+	query := `SELECT id, kind, name, payload, due_at, interval_ms, status, occurrence, attempts, last_error FROM scheduled_jobs WHERE id = ?`
+	var (
+		job           Job
+		payload       string
+		due, interval int64
+		lastError     sql.NullString
+	)
+	err := s.Db.QueryRowContext(ctx, s.rebind(query), id).Scan(&job.ID, &job.Kind, &job.Name, &payload, &due, &interval, &job.Status, &job.Occurrence, &job.Attempts, &lastError)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to read job %s: %w", id, err)
+	}
+	job.Payload = json.RawMessage(payload)
+	job.DueAt = time.UnixMilli(due)
+	job.Interval = time.Duration(interval) * time.Millisecond
+	job.LastError = lastError.String
+	return job, nil
+}
+
+// rebind rewrites ?-style placeholders for the driver.
+func (s *Scheduler) rebind(query string) string {
+	return eventstore.Rebind(s.driver, query)
+}
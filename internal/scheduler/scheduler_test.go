@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"defi/internal/command"
+	"defi/internal/eventstore"
+	"defi/internal/ledger"
+	"defi/internal/model"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type published struct {
+	topic   string
+	payload []byte
+}
+
+type recorder struct {
+	messages []published
+}
+
+func (r *recorder) PublishEvent(topic string, payload []byte) error {
+	r.messages = append(r.messages, published{topic, payload})
+	return nil
+}
+
+func TestNextDue(t *testing.T) {
+	due := time.UnixMilli(0)
+	for _, tt := range []struct {
+		now, want time.Duration
+	}{
+		{now: 0, want: time.Hour},
+		{now: 30 * time.Minute, want: time.Hour},
+		{now: time.Hour, want: 2 * time.Hour},
+		{now: 5*time.Hour + time.Minute, want: 6 * time.Hour},
+	} {
+		if got := nextDue(due, time.Hour, due.Add(tt.now)); !got.Equal(due.Add(tt.want)) {
+			t.Errorf("next after %s: got %s, want %s", tt.now, got.Sub(due), tt.want)
+		}
+	}
+}
+
+func TestFire(t *testing.T) {
+	ctx := context.Background()
+	store := eventstore.NewMemoryEventStore()
+	bus := command.NewBus()
+	ledger.New(store).Register(bus)
+	events := &recorder{}
+
+	s, err := New(nil, "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Commands, s.Events, s.Fence = bus, events, store
+	RegisterCommand[ledger.OpenAccount](s, "ledger.open_account")
+
+	payload, _ := json.Marshal(ledger.OpenAccount{AccountID: "fees", Kind: ledger.KindIncome})
+	job := Job{ID: "open-fees", Kind: KindCommand, Name: "ledger.open_account", Payload: payload, Attempts: 1}
+	if err := s.fire(ctx, job); err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+	page, err := store.FindEvents(eventstore.EventQuery{Metadata: map[string]string{MetadataOccurrence: "open-fees#0"}})
+	if err != nil || len(page.Events) != 1 {
+		t.Fatalf("expected the account event to carry the occurrence, got %v, %v", page.Events, err)
+	}
+
+	// A second attempt at the same occurrence finds its event and stops;
+	// without the fence the command is dispatched again and rejected.
+	job.Attempts = 2
+	if err := s.fire(ctx, job); err != nil {
+		t.Errorf("fenced attempt: %v", err)
+	}
+	s.Fence = nil
+	if err := s.fire(ctx, job); !errors.Is(err, command.ErrRejected) {
+		t.Errorf("unfenced attempt: got %v", err)
+	}
+
+	data, _ := json.Marshal(scheduledEvent{Type: "Tick", Data: json.RawMessage(`{"n":1}`)})
+	tick := Job{ID: "tick", Kind: KindEvent, Name: "events.ticks", Payload: data, Occurrence: 3, Attempts: 1}
+	for i := 0; i < 2; i++ {
+		if err := s.fire(ctx, tick); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(events.messages) != 2 || events.messages[0].topic != "events.ticks" {
+		t.Fatalf("unexpected messages: %v", events.messages)
+	}
+	var first, second model.Event
+	json.Unmarshal(events.messages[0].payload, &first)
+	json.Unmarshal(events.messages[1].payload, &second)
+	if first.ID == "" || first.ID != second.ID || first.Type != "Tick" || first.Metadata[MetadataOccurrence] != "tick#3" {
+		t.Errorf("repeated occurrences must share an event ID: %+v, %+v", first, second)
+	}
+}